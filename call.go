@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/alecthomas/protobuf/call"
+	"github.com/alecthomas/protobuf/compiler"
+)
+
+type CallConfig struct {
+	ProtoPath []string      `short:"I" help:"Search paths for proto imports."`
+	Data      string        `short:"d" help:"Request messages in JSON, or @file to read them from a file or @- from stdin. Client streaming methods take a sequence of messages."`
+	Header    []string      `short:"H" sep:"none" help:"Request metadata as 'name: value'."`
+	Timeout   time.Duration `help:"Deadline of the call."`
+	TLS       bool          `name:"tls" help:"Connect with TLS rather than plaintext."`
+	Address   string        `arg:"" help:"Address of the server, as host:port."`
+	Method    string        `arg:"" help:"Method to call, as pkg.Service/Method."`
+	Files     []string      `arg:"" help:"Proto files declaring the service or importing the file that does."`
+}
+
+func (c *CallConfig) Run() error {
+	result, err := compiler.Build(c.Files, c.ProtoPath, true)
+	if err != nil {
+		return err
+	}
+	reg := result.Registry()
+	md, err := call.FindMethod(reg, c.Method)
+	if err != nil {
+		return err
+	}
+	var data io.Reader = strings.NewReader(c.Data)
+	switch {
+	case c.Data == "@-":
+		data = os.Stdin
+	case strings.HasPrefix(c.Data, "@"):
+		f, err := os.Open(c.Data[1:])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		data = f
+	}
+	ctx := context.Background()
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+	for _, header := range c.Header {
+		name, value, ok := strings.Cut(header, ":")
+		if !ok {
+			return fmt.Errorf("header %q must be 'name: value'", header)
+		}
+		ctx = metadata.AppendToOutgoingContext(ctx, strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+	creds := insecure.NewCredentials()
+	if c.TLS {
+		creds = credentials.NewTLS(&tls.Config{MinVersion: tls.VersionTLS12})
+	}
+	conn, err := grpc.Dial(c.Address, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	options := protojson.MarshalOptions{Multiline: true, Resolver: reg}
+	return call.Invoke(ctx, conn, reg, md, call.JSONRequests(data, reg, md), func(resp proto.Message) error {
+		b, err := options.Marshal(resp)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Println(string(b))
+		return err
+	})
+}
+
+func (c *CallConfig) AfterApply() error {
+	if len(c.Files) == 0 {
+		return fmt.Errorf(`missing .proto input file(s)`)
+	}
+	return nil
+}
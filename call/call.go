@@ -0,0 +1,172 @@
+// Package call invokes gRPC methods with dynamic messages of methods
+// declared in a compiler.Registry, without generated code.
+package call
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+
+	"github.com/alecthomas/protobuf/compiler"
+)
+
+// FindMethod returns the method named by name in the files of reg, as
+// "pkg.Service/Method", "/pkg.Service/Method" or "pkg.Service.Method".
+func FindMethod(reg *compiler.Registry, name string) (protoreflect.MethodDescriptor, error) {
+	fullName := strings.TrimPrefix(name, "/")
+	if i := strings.LastIndexByte(fullName, '/'); i >= 0 {
+		fullName = fullName[:i] + "." + fullName[i+1:]
+	}
+	desc, err := reg.FindDescriptorByName(protoreflect.FullName(fullName))
+	if err != nil {
+		return nil, fmt.Errorf("unknown method %s", name)
+	}
+	md, ok := desc.(protoreflect.MethodDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a method", name)
+	}
+	return md, nil
+}
+
+// Path returns the path of the HTTP/2 requests of a method, such as
+// "/pkg.Service/Method".
+func Path(md protoreflect.MethodDescriptor) string {
+	return "/" + string(md.Parent().FullName()) + "/" + string(md.Name())
+}
+
+// Invoke calls the method md on conn, sending the requests returned by
+// next until it returns io.EOF and calling handle with each response.
+// Methods that do not stream requests are sent the first request returned
+// by next, or an empty request if it returns none. Messages are created
+// with the types of reg.
+//
+// Requests are sent while responses are received, so next may block
+// waiting for input to the requests of bidirectional streaming methods.
+func Invoke(ctx context.Context, conn grpc.ClientConnInterface, reg *compiler.Registry, md protoreflect.MethodDescriptor,
+	next func() (proto.Message, error), handle func(proto.Message) error) error {
+	input, err := reg.FindMessageByName(md.Input().FullName())
+	if err != nil {
+		return err
+	}
+	output, err := reg.FindMessageByName(md.Output().FullName())
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	desc := &grpc.StreamDesc{
+		StreamName:    string(md.Name()),
+		ClientStreams: md.IsStreamingClient(),
+		ServerStreams: md.IsStreamingServer(),
+	}
+	stream, err := conn.NewStream(ctx, desc, Path(md))
+	if err != nil {
+		return err
+	}
+	sent := make(chan error, 1)
+	go func() {
+		err := send(stream, md, input, next)
+		sent <- err
+		if err != nil {
+			cancel()
+		}
+	}()
+	for {
+		resp := output.New().Interface()
+		err := stream.RecvMsg(resp)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			// A request that could not be sent cancels the stream.
+			select {
+			case sendErr := <-sent:
+				if sendErr != nil {
+					return sendErr
+				}
+			default:
+			}
+			return err
+		}
+		if err := handle(resp); err != nil {
+			return err
+		}
+	}
+	// The method may have ended before all requests were sent, in which
+	// case next may still be waiting for input.
+	select {
+	case err := <-sent:
+		return err
+	default:
+		return nil
+	}
+}
+
+// send sends the requests returned by next on stream and closes it.
+func send(stream grpc.ClientStream, md protoreflect.MethodDescriptor, input protoreflect.MessageType, next func() (proto.Message, error)) error {
+	if !md.IsStreamingClient() {
+		// The stream is closed after the first request rather than
+		// waiting for next to end, which may block on input.
+		req, err := next()
+		if errors.Is(err, io.EOF) {
+			req = input.New().Interface()
+		} else if err != nil {
+			return err
+		}
+		if err := stream.SendMsg(req); err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		return stream.CloseSend()
+	}
+	for {
+		req, err := next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if err := stream.SendMsg(req); err != nil {
+			if errors.Is(err, io.EOF) {
+				// The method has ended; its status is returned by RecvMsg.
+				return nil
+			}
+			return err
+		}
+	}
+	return stream.CloseSend()
+}
+
+// JSONRequests returns a function for Invoke that reads request messages
+// of md from r, as a sequence of JSON objects in the protobuf JSON format.
+// Any types in them are resolved with reg.
+func JSONRequests(r io.Reader, reg *compiler.Registry, md protoreflect.MethodDescriptor) func() (proto.Message, error) {
+	dec := json.NewDecoder(r)
+	options := protojson.UnmarshalOptions{Resolver: reg}
+	return func() (proto.Message, error) {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil, io.EOF
+			}
+			return nil, fmt.Errorf("request: %w", err)
+		}
+		mt, err := reg.FindMessageByName(md.Input().FullName())
+		if err != nil {
+			return nil, err
+		}
+		req := mt.New().Interface()
+		if err := options.Unmarshal(raw, req); err != nil {
+			return nil, fmt.Errorf("request: %w", err)
+		}
+		return req, nil
+	}
+}
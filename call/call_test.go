@@ -0,0 +1,252 @@
+package call
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+
+	"github.com/alecthomas/protobuf/compiler"
+)
+
+func TestInvoke(t *testing.T) {
+	tests := []struct {
+		method string
+		data   string
+		want   []string
+		err    string
+	}{
+		{method: "greeter.v1.Greeter/SayHello", data: `{"name": "Ada"}`, want: []string{"Hello, Ada"}},
+		{method: "greeter.v1.Greeter.SayHello", data: `{"name": "Ada"}`, want: []string{"Hello, Ada"}},
+		// An empty request is sent without data.
+		{method: "greeter.v1.Greeter/SayHello",
+			err: "rpc error: code = InvalidArgument desc = name is required"},
+		// Requests after the first are not read.
+		{method: "greeter.v1.Greeter/SayHello", data: `{"name": "Ada"} {"name": "Bob"}`, want: []string{"Hello, Ada"}},
+		{method: "greeter.v1.Greeter/SayHello", data: `{"nom": "Ada"}`,
+			err: `request: proto: (line 1:2): unknown field "nom"`},
+		{method: "greeter.v1.Greeter/SayHello", data: `{"name": "Ada"`,
+			err: "request: unexpected EOF"},
+		{method: "greeter.v1.Greeter/SayHello", data: `{"name": ""}`,
+			err: "rpc error: code = InvalidArgument desc = name is required"},
+		{method: "greeter.v1.Greeter/LotsOfReplies", data: `{"name": "Ada"}`,
+			want: []string{"Hello 1, Ada", "Hello 2, Ada", "Hello 3, Ada"}},
+		{method: "greeter.v1.Greeter/LotsOfGreetings", data: `{"name": "Ada"} {"name": "Bob"}`,
+			want: []string{"Hello, Ada and Bob"}},
+		{method: "greeter.v1.Greeter/LotsOfGreetings", want: []string{"Hello, "}},
+		{method: "greeter.v1.Greeter/BidiHello", data: `{"name": "Ada"} {"name": "Bob"} {"name": "Cy"}`,
+			want: []string{"Hello, Ada", "Hello, Bob", "Hello, Cy"}},
+		{method: "greeter.v1.Greeter/BidiHello", data: `{"name": "Ada"} {"name": ""} {"name": "Cy"}`,
+			want: []string{"Hello, Ada"}, err: "rpc error: code = InvalidArgument desc = name is required"},
+	}
+	reg := newRegistry(t)
+	conn := newConn(t, reg)
+	for _, test := range tests {
+		t.Run(test.method+" "+test.data, func(t *testing.T) {
+			md, err := FindMethod(reg, test.method)
+			require.NoError(t, err)
+			var got []string
+			err = Invoke(context.Background(), conn, reg, md, JSONRequests(strings.NewReader(test.data), reg, md), func(resp proto.Message) error {
+				got = append(got, field(resp, "message").String())
+				return nil
+			})
+			if test.err != "" {
+				// The text of protojson errors is unstable.
+				require.Error(t, err)
+				require.Equal(t, strings.ReplaceAll(test.err, " ", ""), strings.Join(strings.Fields(err.Error()), ""))
+			} else {
+				require.NoError(t, err)
+			}
+			require.Equal(t, test.want, got)
+		})
+	}
+}
+
+func TestInvokeStopsReceiving(t *testing.T) {
+	reg := newRegistry(t)
+	md, err := FindMethod(reg, "greeter.v1.Greeter/LotsOfReplies")
+	require.NoError(t, err)
+	stop := errors.New("stop")
+	n := 0
+	err = Invoke(context.Background(), newConn(t, reg), reg, md, JSONRequests(strings.NewReader(`{"name": "Ada"}`), reg, md), func(proto.Message) error {
+		n++
+		return stop
+	})
+	require.ErrorIs(t, err, stop)
+	require.Equal(t, 1, n)
+}
+
+func TestInvokeDoesNotWaitForRequests(t *testing.T) {
+	reg := newRegistry(t)
+	conn := newConn(t, reg)
+	tests := []struct {
+		method string
+		want   []string
+	}{
+		{method: "greeter.v1.Greeter/SayHello", want: []string{"Hello, Ada"}},
+		{method: "greeter.v1.Greeter/LotsOfReplies", want: []string{"Hello 1, Ada", "Hello 2, Ada", "Hello 3, Ada"}},
+	}
+	for _, test := range tests {
+		t.Run(test.method, func(t *testing.T) {
+			md, err := FindMethod(reg, test.method)
+			require.NoError(t, err)
+			requests := JSONRequests(strings.NewReader(`{"name": "Ada"}`), reg, md)
+			// next blocks after the first request, like reading stdin.
+			block := make(chan struct{})
+			t.Cleanup(func() { close(block) })
+			n := 0
+			next := func() (proto.Message, error) {
+				if n++; n > 1 {
+					<-block
+				}
+				return requests()
+			}
+			var got []string
+			err = Invoke(context.Background(), conn, reg, md, next, func(resp proto.Message) error {
+				got = append(got, field(resp, "message").String())
+				return nil
+			})
+			require.NoError(t, err)
+			require.Equal(t, test.want, got)
+		})
+	}
+}
+
+func TestFindMethod(t *testing.T) {
+	reg := newRegistry(t)
+	md, err := FindMethod(reg, "/greeter.v1.Greeter/BidiHello")
+	require.NoError(t, err)
+	require.Equal(t, "/greeter.v1.Greeter/BidiHello", Path(md))
+	_, err = FindMethod(reg, "greeter.v1.Greeter/Unknown")
+	require.EqualError(t, err, "unknown method greeter.v1.Greeter/Unknown")
+	_, err = FindMethod(reg, "greeter.v1.HelloRequest")
+	require.EqualError(t, err, "greeter.v1.HelloRequest is not a method")
+}
+
+func newRegistry(t *testing.T) *compiler.Registry {
+	t.Helper()
+	result, err := compiler.Build([]string{"greeter.proto"}, []string{"../reflection/testdata", "../compiler/testdata"}, true)
+	require.NoError(t, err)
+	return result.Registry()
+}
+
+// newConn returns a client connection to an in-process Greeter server
+// implemented with dynamic messages of reg.
+func newConn(t *testing.T, reg *compiler.Registry) *grpc.ClientConn {
+	t.Helper()
+	sd, err := reg.FindServiceByName("greeter.v1.Greeter")
+	require.NoError(t, err)
+	desc := &grpc.ServiceDesc{ServiceName: string(sd.FullName()), HandlerType: (*interface{})(nil)}
+	for i := 0; i < sd.Methods().Len(); i++ {
+		md := sd.Methods().Get(i)
+		desc.Streams = append(desc.Streams, grpc.StreamDesc{
+			StreamName:    string(md.Name()),
+			ServerStreams: true,
+			ClientStreams: true,
+			Handler: func(_ interface{}, stream grpc.ServerStream) error {
+				return greet(reg, md, stream)
+			},
+		})
+	}
+	lis := bufconn.Listen(1 << 20)
+	s := grpc.NewServer()
+	s.RegisterService(desc, struct{}{})
+	go func() { _ = s.Serve(lis) }()
+	t.Cleanup(s.Stop)
+	conn, err := grpc.DialContext(context.Background(), "bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	return conn
+}
+
+// greet implements the methods of the Greeter service.
+func greet(reg *compiler.Registry, md protoreflect.MethodDescriptor, stream grpc.ServerStream) error {
+	input, err := reg.FindMessageByName(md.Input().FullName())
+	if err != nil {
+		return err
+	}
+	output, err := reg.FindMessageByName(md.Output().FullName())
+	if err != nil {
+		return err
+	}
+	recv := func() (string, error) {
+		req := input.New().Interface()
+		if err := stream.RecvMsg(req); err != nil {
+			return "", err
+		}
+		name := field(req, "name").String()
+		if name == "" && md.Name() != "LotsOfGreetings" {
+			return "", status.Error(codes.InvalidArgument, "name is required")
+		}
+		return name, nil
+	}
+	reply := func(format string, args ...interface{}) error {
+		resp := output.New()
+		resp.Set(resp.Descriptor().Fields().ByName("message"), protoreflect.ValueOfString(fmt.Sprintf(format, args...)))
+		return stream.SendMsg(resp.Interface())
+	}
+	switch md.Name() {
+	case "SayHello":
+		name, err := recv()
+		if err != nil {
+			return err
+		}
+		return reply("Hello, %s", name)
+	case "LotsOfReplies":
+		name, err := recv()
+		if err != nil {
+			return err
+		}
+		for i := 1; i <= 3; i++ {
+			if err := reply("Hello %d, %s", i, name); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "LotsOfGreetings":
+		var names []string
+		for {
+			name, err := recv()
+			if errors.Is(err, io.EOF) {
+				return reply("Hello, %s", strings.Join(names, " and "))
+			}
+			if err != nil {
+				return err
+			}
+			names = append(names, name)
+		}
+	case "BidiHello":
+		for {
+			name, err := recv()
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if err := reply("Hello, %s", name); err != nil {
+				return err
+			}
+		}
+	}
+	return status.Errorf(codes.Unimplemented, "%s is not implemented", md.FullName())
+}
+
+func field(m proto.Message, name protoreflect.Name) protoreflect.Value {
+	msg := m.ProtoReflect()
+	return msg.Get(msg.Descriptor().Fields().ByName(name))
+}
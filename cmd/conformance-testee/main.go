@@ -0,0 +1,40 @@
+// Command conformance-testee is a testee of the protobuf conformance test
+// runner converting messages with dynamic types compiled by this module.
+//
+// The arguments are the import paths of the conformance protos, by default
+// testdata/conformance, so the runner, which passes no arguments, must be
+// run from the root of this repository:
+//
+//	go build -o conformance-testee ./cmd/conformance-testee
+//	conformance_test_runner ./conformance-testee
+package main
+
+import (
+	"log"
+	"os"
+
+	"github.com/alecthomas/protobuf/compiler"
+	"github.com/alecthomas/protobuf/conformance"
+)
+
+func main() {
+	importPaths := os.Args[1:]
+	if len(importPaths) == 0 {
+		importPaths = []string{"testdata/conformance"}
+	}
+	fds, err := compiler.Compile(conformance.Files, importPaths, true)
+	if err != nil {
+		log.Fatal(err)
+	}
+	reg, err := compiler.NewRegistry(fds, compiler.TypePrecedence(compiler.LocalOnly))
+	if err != nil {
+		log.Fatal(err)
+	}
+	testee, err := conformance.NewTestee(reg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := testee.Serve(os.Stdin, os.Stdout); err != nil {
+		log.Fatal(err)
+	}
+}
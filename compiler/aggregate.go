@@ -0,0 +1,224 @@
+package compiler
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/alecthomas/protobuf/parser"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+const anyFullName = "google.protobuf.Any"
+
+// setValue sets the field fd of msg from an option value, appending
+// to the field if it is repeated. Aggregate values are interpreted
+// directly from the parse tree so that names are resolved in the scope
+// of r and errors refer to positions in the source.
+func setValue(r *scopedResolver, msg protoreflect.Message, fd protoreflect.FieldDescriptor, v *parser.Value) error {
+	if v.ProtoText == nil {
+		if err := setField(msg, fd, v); err != nil {
+			return fmt.Errorf("%s: %w", v.Pos, err)
+		}
+		return nil
+	}
+	switch {
+	case fd.IsMap():
+		return setMapEntry(r, msg.Mutable(fd).Map(), fd, v.ProtoText)
+	case fd.Message() == nil:
+		return fmt.Errorf("%s: %s: cannot use aggregate value as %s value", v.Pos, fd.FullName(), fd.Kind())
+	case fd.IsList():
+		list := msg.Mutable(fd).List()
+		elem := list.NewElement()
+		if err := setAggregate(r, elem.Message(), v.ProtoText); err != nil {
+			return err
+		}
+		list.Append(elem)
+		return nil
+	case msg.Has(fd):
+		return fmt.Errorf("%s: %s: option was already set", v.Pos, fd.FullName())
+	default:
+		return setAggregate(r, msg.Mutable(fd).Message(), v.ProtoText)
+	}
+}
+
+// setAggregate sets the fields of msg from an aggregate value in
+// protobuf text format.
+func setAggregate(r *scopedResolver, msg protoreflect.Message, text *parser.ProtoText) error {
+	for _, f := range text.Fields {
+		if strings.Contains(f.Type, "/") {
+			if err := setAny(r, msg, f); err != nil {
+				return err
+			}
+			continue
+		}
+		fd, err := textField(r, msg.Descriptor(), f)
+		if err != nil {
+			return err
+		}
+		if oneof := fd.ContainingOneof(); oneof != nil && !oneof.IsSynthetic() {
+			if set := msg.WhichOneof(oneof); set != nil && set != fd {
+				return fmt.Errorf("%s: %s: oneof %s already has field %s set", f.Pos, fd.FullName(), oneof.Name(), set.Name())
+			}
+		}
+		values := []*parser.Value{f.Value}
+		if f.Value.Array != nil {
+			if !fd.IsList() && !fd.IsMap() {
+				return fmt.Errorf("%s: %s: list value for non-repeated field", f.Value.Pos, fd.FullName())
+			}
+			values = f.Value.Array.Elements
+		}
+		for _, v := range values {
+			if v.Array != nil {
+				return fmt.Errorf("%s: %s: lists cannot be nested", v.Pos, fd.FullName())
+			}
+			if err := setValue(r, msg, fd, textBool(fd, v)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// textField returns the field of md named by a text format field, which
+// is either a field name, the message name of a group or an extension
+// name relative to the scope of r.
+func textField(r *scopedResolver, md protoreflect.MessageDescriptor, f *parser.ProtoTextField) (protoreflect.FieldDescriptor, error) {
+	if f.Type != "" {
+		xt, err := r.FindExtensionByName(protoreflect.FullName(f.Type))
+		if err != nil {
+			return nil, fmt.Errorf("%s: unknown extension %s", f.Pos, f.Type)
+		}
+		fd := xt.TypeDescriptor()
+		if fd.ContainingMessage().FullName() != md.FullName() {
+			return nil, fmt.Errorf("%s: extension %s does not extend %s", f.Pos, fd.FullName(), md.FullName())
+		}
+		r.types.references[f] = string(fd.FullName())
+		return fd, nil
+	}
+	fields := md.Fields()
+	if fd := fields.ByName(protoreflect.Name(f.Name)); fd != nil && fd.Kind() != protoreflect.GroupKind {
+		return fd, nil
+	}
+	for i := 0; i < fields.Len(); i++ {
+		if fd := fields.Get(i); fd.Kind() == protoreflect.GroupKind && string(fd.Message().Name()) == f.Name {
+			return fd, nil
+		}
+	}
+	return nil, fmt.Errorf("%s: unknown field %s in %s", f.Pos, f.Name, md.FullName())
+}
+
+// setAny sets an Any from an expanded value such as
+// [type.googleapis.com/pkg.Msg] { ... }.
+func setAny(r *scopedResolver, msg protoreflect.Message, f *parser.ProtoTextField) error {
+	md := msg.Descriptor()
+	if md.FullName() != anyFullName {
+		return fmt.Errorf("%s: type URL [%s] can only be used in %s, not %s", f.Pos, f.Type, anyFullName, md.FullName())
+	}
+	name := f.Type[strings.LastIndexByte(f.Type, '/')+1:]
+	typeURL, value := md.Fields().ByName("type_url"), md.Fields().ByName("value")
+	if msg.Has(typeURL) || msg.Has(value) {
+		return fmt.Errorf("%s: %s can only hold one value", f.Pos, anyFullName)
+	}
+	mt, err := r.FindMessageByName(protoreflect.FullName(name))
+	if err != nil {
+		return fmt.Errorf("%s: unknown message type %s in type URL", f.Pos, name)
+	}
+	if f.Value.ProtoText == nil {
+		return fmt.Errorf("%s: type URL [%s] must be followed by an aggregate value", f.Value.Pos, f.Type)
+	}
+	r.types.references[f] = string(mt.Descriptor().FullName())
+	m := mt.New()
+	if err := setAggregate(r, m, f.Value.ProtoText); err != nil {
+		return err
+	}
+	b, err := proto.MarshalOptions{Deterministic: true}.Marshal(m.Interface())
+	if err != nil {
+		return fmt.Errorf("%s: %w", f.Value.Pos, err)
+	}
+	msg.Set(typeURL, protoreflect.ValueOfString(f.Type))
+	msg.Set(value, protoreflect.ValueOfBytes(b))
+	return nil
+}
+
+// setMapEntry adds an entry to a map field from a text format entry
+// message with key and value fields.
+func setMapEntry(r *scopedResolver, m protoreflect.Map, fd protoreflect.FieldDescriptor, text *parser.ProtoText) error {
+	keyFD, valueFD := fd.MapKey(), fd.MapValue()
+	var entry protoreflect.Value
+	if valueFD.Message() != nil {
+		entry = m.NewValue()
+	}
+	var key protoreflect.MapKey
+	hasKey := false
+	for _, f := range text.Fields {
+		switch {
+		case f.Name == "key" && f.Value.ProtoText == nil:
+			v, err := scalarValue(keyFD, f.Value)
+			if err != nil {
+				return err
+			}
+			key, hasKey = v.MapKey(), true
+		case f.Name == "value" && valueFD.Message() != nil:
+			if f.Value.ProtoText == nil {
+				return fmt.Errorf("%s: %s: map value must be an aggregate value", f.Value.Pos, fd.FullName())
+			}
+			if err := setAggregate(r, entry.Message(), f.Value.ProtoText); err != nil {
+				return err
+			}
+		case f.Name == "value":
+			v, err := scalarValue(valueFD, textBool(valueFD, f.Value))
+			if err != nil {
+				return err
+			}
+			entry = v
+		default:
+			return fmt.Errorf("%s: unknown field %s in map entry of %s", f.Pos, f.Name+f.Type, fd.FullName())
+		}
+	}
+	if !hasKey {
+		key = keyFD.Default().MapKey()
+	}
+	if valueFD.Message() == nil && !entry.IsValid() {
+		entry = valueFD.Default()
+	}
+	m.Set(key, entry)
+	return nil
+}
+
+// scalarValue returns the value of a non-message field from an option
+// value.
+func scalarValue(fd protoreflect.FieldDescriptor, v *parser.Value) (protoreflect.Value, error) {
+	if v.ProtoText != nil || v.Array != nil {
+		return protoreflect.Value{}, fmt.Errorf("%s: %s: expected %s value", v.Pos, fd.FullName(), fd.Kind())
+	}
+	// Set the value on a scratch map entry to reuse the checks of setField.
+	entry := dynamicpb.NewMessage(fd.ContainingMessage())
+	if err := setField(entry, fd, v); err != nil {
+		return protoreflect.Value{}, fmt.Errorf("%s: %w", v.Pos, err)
+	}
+	return entry.Get(fd), nil
+}
+
+// textBool converts the additional boolean literals of the text format,
+// "True", "t", "False", "f", 1 and 0, to true and false for bool fields.
+func textBool(fd protoreflect.FieldDescriptor, v *parser.Value) *parser.Value {
+	if fd.Kind() != protoreflect.BoolKind {
+		return v
+	}
+	var b parser.Boolean
+	switch {
+	case v.Reference != nil && (*v.Reference == "True" || *v.Reference == "t"):
+		b = true
+	case v.Reference != nil && (*v.Reference == "False" || *v.Reference == "f"):
+		b = false
+	case v.Number != nil && v.Number.IsInt() && v.Number.Sign() == 0:
+		b = false
+	case v.Number != nil && v.Number.IsInt() && v.Number.String() == "1":
+		b = true
+	default:
+		return v
+	}
+	return &parser.Value{Pos: v.Pos, Bool: &b}
+}
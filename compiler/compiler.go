@@ -7,13 +7,14 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"os"
 	"path/filepath"
 	"reflect"
+	"strconv"
 	"strings"
 
 	"github.com/alecthomas/protobuf/parser"
-	"google.golang.org/protobuf/encoding/prototext"
 	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/reflect/protoreflect"
 	"google.golang.org/protobuf/reflect/protoregistry"
@@ -25,6 +26,7 @@ import (
 // singular structures such as syntax or package.
 type ast struct {
 	proto         *parser.Proto
+	source        []byte
 	file          string
 	pkg           string
 	imports       []string
@@ -38,15 +40,54 @@ type ast struct {
 	extends  []*parser.Extend
 }
 
+// Option configures the behaviour of Compile.
+type Option func(*config)
+
+type config struct {
+	includeSourceInfo bool
+	retainOptions     bool
+}
+
+// IncludeSourceInfo populates the SourceCodeInfo of each generated
+// FileDescriptorProto, similar to protoc --include_source_info. Only
+// declarations (messages, fields, oneofs, enums, enum values, services,
+// methods and extensions) are recorded, along with their comments.
+func IncludeSourceInfo() Option {
+	return func(c *config) { c.includeSourceInfo = true }
+}
+
+// RetainOptions keeps options with source retention in the generated
+// FileDescriptorProtos, like protoc --retain_options. By default they
+// are stripped.
+func RetainOptions() Option {
+	return func(c *config) { c.retainOptions = true }
+}
+
 // Compile creates a FileDescriptorSet similar to protoc:
 //
-// 		protoc -o filedescriptorset.pb -I importPath1 -I importPath2 --include_imports file1.proto file2.proto
+//	protoc -o filedescriptorset.pb -I importPath1 -I importPath2 --include_imports file1.proto file2.proto
 //
 // A FileDescriptorSet contains an array of FileDescriptorProtos, each
 // of which a proto representation of the source proto files. The
 // FileDescriptorSet is the intermediary representation typically
 // passed to proto plugins.
-func Compile(files, importPaths []string, includeImports bool) (*pb.FileDescriptorSet, error) {
+func Compile(files, importPaths []string, includeImports bool, options ...Option) (*pb.FileDescriptorSet, error) {
+	result, err := Build(files, importPaths, includeImports, options...)
+	if err != nil {
+		return nil, err
+	}
+	return result.FileDescriptorSet, nil
+}
+
+// Build compiles files like Compile, additionally returning the ASTs
+// of all parsed files, the resolved options of their declarations and
+// their linked protoreflect descriptors. Errors linking the files, such
+// as duplicate declarations, have the position of the declaration.
+func Build(files, importPaths []string, includeImports bool, options ...Option) (*Result, error) {
+	cfg := &config{}
+	for _, option := range options {
+		option(cfg)
+	}
 	done := map[string]bool{}
 	origFiles := map[string]bool{}
 	for _, file := range files {
@@ -56,32 +97,77 @@ func Compile(files, importPaths []string, includeImports bool) (*pb.FileDescript
 	if err != nil {
 		return nil, err
 	}
+	for _, a := range asts {
+		if err := validate(a); err != nil {
+			return nil, err
+		}
+	}
 	types := newTypes(asts)
+	for _, a := range asts {
+		if err := validateReferences(a, types); err != nil {
+			return nil, err
+		}
+	}
 	all := &pb.FileDescriptorSet{}
 	filtered := &pb.FileDescriptorSet{}
 	for _, a := range asts {
 		fd := newFileDescriptor(a, types)
+		if err := validateExtends(a, types); err != nil {
+			return nil, err
+		}
+		if cfg.includeSourceInfo {
+			if fd.SourceCodeInfo, err = newSourceCodeInfo(a, fd); err != nil {
+				return nil, err
+			}
+		}
 		all.File = append(all.File, fd)
 		if includeImports || origFiles[a.file] {
 			filtered.File = append(filtered.File, fd)
 		}
 	}
-	err = resolveCustomOptions(all, filtered, types)
+	if err := resolveDefaults(all, types); err != nil {
+		return nil, err
+	}
+	if err := resolveCustomOptions(asts, all, types); err != nil {
+		return nil, err
+	}
+	fieldOpts := newFieldOptionsIndex(all)
+	for _, fd := range all.File {
+		if err := fieldOpts.checkTargets(fd); err != nil {
+			return nil, err
+		}
+	}
+	reg, err := NewRegistry(all)
+	if err != nil {
+		return nil, linkError(err, asts)
+	}
+	result, err := newResult(asts, all, filtered, reg, types, files)
 	if err != nil {
 		return nil, err
 	}
-	return filtered, nil
+	if !cfg.retainOptions {
+		// Strip a copy so the options of the Result remain complete.
+		result.FileDescriptorSet = proto.Clone(filtered).(*pb.FileDescriptorSet)
+		for _, fd := range result.FileDescriptorSet.File {
+			fieldOpts.stripSourceRetention(fd)
+		}
+	}
+	return result, nil
 }
 
-func resolveCustomOptions(all, filtered *pb.FileDescriptorSet, types *types) error {
+// resolveCustomOptions resolves the uninterpreted options of all files
+// in place. It links the files to find the extensions the options refer
+// to, and as the linked descriptors keep copies of the options as they
+// were, the files must be linked again once their options are resolved.
+func resolveCustomOptions(asts []*ast, all *pb.FileDescriptorSet, types *types) error {
 	reg, err := NewRegistry(all)
 	if err != nil {
-		return err
+		return linkError(err, asts)
 	}
 
 	r := &scopedResolver{resolver: reg, types: types}
 
-	for _, fd := range filtered.File {
+	for _, fd := range all.File {
 		if err := resolveFileOptions(r, fd); err != nil {
 			return err
 		}
@@ -100,16 +186,14 @@ type scopedResolver struct {
 	types *types
 }
 
-func (sr *scopedResolver) FindExtensionByName(field protoreflect.FullName) (et protoreflect.ExtensionType, err error) {
-	defer func() {
-		if r := recover(); r != nil {
-			et, err = nil, protoregistry.NotFound
-		}
-	}()
-	// lookup full name and strip off leading "." (FindExtensionByName does
-	// not want the leading dot)
-	fullname := protoreflect.FullName(sr.types.extensionName(string(field), sr.scope)[1:])
-	return sr.resolver.FindExtensionByName(fullname)
+func (sr *scopedResolver) FindExtensionByName(field protoreflect.FullName) (protoreflect.ExtensionType, error) {
+	name := sr.types.extensionName(string(field), sr.scope)
+	if name == "" {
+		return nil, protoregistry.NotFound
+	}
+	// strip off leading "." (FindExtensionByName does not want the leading
+	// dot)
+	return sr.resolver.FindExtensionByName(protoreflect.FullName(name[1:]))
 }
 
 func (sr *scopedResolver) pushScopes(scopes ...string) {
@@ -195,7 +279,7 @@ func resolveMessageOptions(r *scopedResolver, md *pb.DescriptorProto) error {
 	return nil
 }
 
-func resolveEnumOptions(r resolver, ed *pb.EnumDescriptorProto) error {
+func resolveEnumOptions(r *scopedResolver, ed *pb.EnumDescriptorProto) error {
 	if err := resolveUninterpretedOptions(r, ed.GetOptions()); err != nil {
 		return err
 	}
@@ -207,7 +291,7 @@ func resolveEnumOptions(r resolver, ed *pb.EnumDescriptorProto) error {
 	return nil
 }
 
-func resolveServiceOptions(r resolver, sd *pb.ServiceDescriptorProto) error {
+func resolveServiceOptions(r *scopedResolver, sd *pb.ServiceDescriptorProto) error {
 	if err := resolveUninterpretedOptions(r, sd.GetOptions()); err != nil {
 		return err
 	}
@@ -224,14 +308,15 @@ type messageWithOptions interface {
 	GetUninterpretedOption() []*pb.UninterpretedOption
 }
 
-func resolveUninterpretedOptions(r resolver, opts messageWithOptions) error {
+func resolveUninterpretedOptions(r *scopedResolver, opts messageWithOptions) error {
 	if opts == nil || reflect.ValueOf(opts).IsNil() {
 		return nil
 	}
 
 	for _, opt := range opts.GetUninterpretedOption() {
-		msg, fd := getLastField(opts.ProtoReflect(), opt.GetName(), r)
-		setField(msg, fd, opt, r)
+		if err := resolveUninterpretedOption(r, opts.ProtoReflect(), opt); err != nil {
+			return err
+		}
 	}
 
 	// Use reflection to set the UninterpretedOption field to nil
@@ -243,12 +328,38 @@ func resolveUninterpretedOptions(r resolver, opts messageWithOptions) error {
 	return nil
 }
 
+// resolveUninterpretedOption sets the field of opts named by opt to the
+// value of opt. Each element of a list value is appended to the field,
+// which must be repeated.
+func resolveUninterpretedOption(r *scopedResolver, opts protoreflect.Message, opt *pb.UninterpretedOption) error {
+	src := r.types.options[opt]
+	msg, fd, err := getLastField(opts, opt.GetName(), r)
+	if err != nil {
+		return fmt.Errorf("%s: %w", src.Pos, err)
+	}
+	if src.Value.Array == nil {
+		return setValue(r, msg, fd, src.Value)
+	}
+	if !fd.IsList() {
+		return fmt.Errorf("%s: %s: list value for non-repeated field", src.Value.Pos, fd.FullName())
+	}
+	for _, v := range src.Value.Array.Elements {
+		if v.Array != nil {
+			return fmt.Errorf("%s: %s: lists cannot be nested", v.Pos, fd.FullName())
+		}
+		if err := setValue(r, msg, fd, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // getLastField returns a message and a field descriptor for the last field
 // for an option value. Options are specified in a .proto file as
 // option field1.(pkg.field2).field3.(field4) = <some-value>. getLastField
 // will return the message of type field3 and a field descriptor for field4
 // so that it can be set <some-value>.
-func getLastField(msg protoreflect.Message, nameparts []*pb.UninterpretedOption_NamePart, r resolver) (protoreflect.Message, protoreflect.FieldDescriptor) {
+func getLastField(msg protoreflect.Message, nameparts []*pb.UninterpretedOption_NamePart, r resolver) (protoreflect.Message, protoreflect.FieldDescriptor, error) {
 	var fd protoreflect.FieldDescriptor
 
 	for i, np := range nameparts {
@@ -257,32 +368,39 @@ func getLastField(msg protoreflect.Message, nameparts []*pb.UninterpretedOption_
 			name := protoreflect.FullName(np.GetNamePart())
 			et, err := r.FindExtensionByName(name[1:]) // does not like leading "."
 			if err != nil {
-				err := fmt.Errorf("unknown extension in option: %s", name)
-				panic(err)
+				return nil, nil, fmt.Errorf("unknown extension in option: %s", name)
 			}
 			fd = et.TypeDescriptor()
 		} else {
 			if fd = msg.Descriptor().Fields().ByName(name); fd == nil {
-				err := fmt.Errorf("unknown field name in option: %s", name)
-				panic(err)
+				return nil, nil, fmt.Errorf("unknown field name in option: %s", name)
 			}
 		}
-		// All but the last namepart must be a message, so get a mutable message
-		// for the field (possibly from a list of messages) for the next level
-		// of iteration.
+		// All but the last namepart must be a singular message, so get a
+		// mutable message for the field for the next level of iteration.
 		if i != len(nameparts)-1 {
-			v := msg.Mutable(fd)
-			if fd.IsList() {
-				v = v.List().NewElement()
+			if fd.Message() == nil {
+				return nil, nil, fmt.Errorf("%s: option field is not a message", fd.FullName())
+			}
+			if fd.IsList() || fd.IsMap() {
+				return nil, nil, fmt.Errorf("%s: repeated option field must be set with an aggregate value", fd.FullName())
 			}
-			msg = v.Message()
+			msg = msg.Mutable(fd).Message()
 		}
 	}
 
-	return msg, fd
+	return msg, fd, nil
 }
 
-func setField(msg protoreflect.Message, fd protoreflect.FieldDescriptor, val *pb.UninterpretedOption, r resolver) {
+// setField sets the field fd of msg to the scalar value pv, appending to
+// the field if it is repeated. Numbers are reported in errors as their
+// literal text in the source, if it is not empty.
+func setField(msg protoreflect.Message, fd protoreflect.FieldDescriptor, pv *parser.Value) error {
+	if !fd.IsList() && msg.Has(fd) {
+		return fmt.Errorf("%s: option was already set", fd.FullName())
+	}
+	val := &pb.UninterpretedOption{}
+	setUninterpretedValue(val, pv)
 	var v protoreflect.Value
 	switch fd.Kind() {
 	case protoreflect.BoolKind:
@@ -306,24 +424,62 @@ func setField(msg protoreflect.Message, fd protoreflect.FieldDescriptor, val *pb
 	case protoreflect.EnumKind:
 		v = valueOfEnum(val, fd)
 	case protoreflect.MessageKind, protoreflect.GroupKind:
-		mval := msg.NewField(fd)
-		if fd.IsList() {
-			mval = mval.List().NewElement()
-		}
-		v = valueOfMessage(val, mval.Message().Interface(), r)
+		// Messages are set from aggregate values by setValue.
 	}
 
 	if !v.IsValid() {
-		err := fmt.Errorf("%s: cannot make %s from %s", fd.FullName(), fd.Kind(), val)
-		panic(err)
+		literal := pv.Literal
+		if literal == "" {
+			literal = uninterpretedValueString(val)
+		}
+		if outOfRange(pv, fd.Kind()) {
+			return fmt.Errorf("%s: %s is out of range for %s", fd.FullName(), literal, fd.Kind())
+		}
+		return fmt.Errorf("%s: cannot use %s as %s value", fd.FullName(), literal, fd.Kind())
 	}
 
-	// We don't need to worry about maps as they cannot be extension fields.
 	if fd.IsList() {
 		msg.Mutable(fd).List().Append(v)
 	} else {
 		msg.Set(fd, v)
 	}
+	return nil
+}
+
+// outOfRange returns true if v is a number that cannot be represented by
+// kind, rather than a value of another type. Floating point literals are
+// of another type than integers, even if they are integral.
+func outOfRange(v *parser.Value, kind protoreflect.Kind) bool {
+	switch kind { //nolint:exhaustive
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind,
+		protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind,
+		protoreflect.Uint32Kind, protoreflect.Fixed32Kind,
+		protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return v.Number != nil && !v.Float
+	case protoreflect.FloatKind:
+		return v.Number != nil
+	}
+	return false
+}
+
+// uninterpretedValueString returns the value of an UninterpretedOption
+// formatted for error messages.
+func uninterpretedValueString(val *pb.UninterpretedOption) string {
+	switch {
+	case val.IdentifierValue != nil:
+		return val.GetIdentifierValue()
+	case val.PositiveIntValue != nil:
+		return strconv.FormatUint(val.GetPositiveIntValue(), 10)
+	case val.NegativeIntValue != nil:
+		return strconv.FormatInt(val.GetNegativeIntValue(), 10)
+	case val.DoubleValue != nil:
+		return strconv.FormatFloat(val.GetDoubleValue(), 'g', -1, 64)
+	case val.StringValue != nil:
+		return strconv.Quote(string(val.StringValue))
+	case val.AggregateValue != nil:
+		return "aggregate value"
+	}
+	return "empty value"
 }
 
 func valueOfBool(val *pb.UninterpretedOption) protoreflect.Value {
@@ -342,9 +498,9 @@ func valueOfBool(val *pb.UninterpretedOption) protoreflect.Value {
 func valueOfInt32(val *pb.UninterpretedOption) protoreflect.Value {
 	var v int32
 	switch {
-	case val.PositiveIntValue != nil:
+	case val.PositiveIntValue != nil && *val.PositiveIntValue <= math.MaxInt32:
 		v = int32(*val.PositiveIntValue)
-	case val.NegativeIntValue != nil:
+	case val.NegativeIntValue != nil && *val.NegativeIntValue >= math.MinInt32:
 		v = int32(*val.NegativeIntValue)
 	default:
 		return protoreflect.Value{}
@@ -355,7 +511,7 @@ func valueOfInt32(val *pb.UninterpretedOption) protoreflect.Value {
 func valueOfInt64(val *pb.UninterpretedOption) protoreflect.Value {
 	var v int64
 	switch {
-	case val.PositiveIntValue != nil:
+	case val.PositiveIntValue != nil && *val.PositiveIntValue <= math.MaxInt64:
 		v = int64(*val.PositiveIntValue)
 	case val.NegativeIntValue != nil:
 		v = *val.NegativeIntValue
@@ -368,10 +524,8 @@ func valueOfInt64(val *pb.UninterpretedOption) protoreflect.Value {
 func valueOfUint32(val *pb.UninterpretedOption) protoreflect.Value {
 	var v uint32
 	switch {
-	case val.PositiveIntValue != nil:
+	case val.PositiveIntValue != nil && *val.PositiveIntValue <= math.MaxUint32:
 		v = uint32(*val.PositiveIntValue)
-	case val.NegativeIntValue != nil:
-		v = uint32(*val.NegativeIntValue)
 	default:
 		return protoreflect.Value{}
 	}
@@ -383,8 +537,6 @@ func valueOfUint64(val *pb.UninterpretedOption) protoreflect.Value {
 	switch {
 	case val.PositiveIntValue != nil:
 		v = *val.PositiveIntValue
-	case val.NegativeIntValue != nil:
-		v = uint64(*val.NegativeIntValue)
 	default:
 		return protoreflect.Value{}
 	}
@@ -396,6 +548,13 @@ func valueOfFloat32(val *pb.UninterpretedOption) protoreflect.Value {
 	switch {
 	case val.DoubleValue != nil:
 		v = float32(*val.DoubleValue)
+		if math.IsInf(float64(v), 0) && !math.IsInf(*val.DoubleValue, 0) {
+			return protoreflect.Value{}
+		}
+	case val.GetIdentifierValue() == "inf":
+		v = float32(math.Inf(1))
+	case val.GetIdentifierValue() == "nan":
+		v = float32(math.NaN())
 	case val.PositiveIntValue != nil:
 		v = float32(*val.PositiveIntValue)
 	case val.NegativeIntValue != nil:
@@ -411,6 +570,10 @@ func valueOfFloat64(val *pb.UninterpretedOption) protoreflect.Value {
 	switch {
 	case val.DoubleValue != nil:
 		v = *val.DoubleValue
+	case val.GetIdentifierValue() == "inf":
+		v = math.Inf(1)
+	case val.GetIdentifierValue() == "nan":
+		v = math.NaN()
 	case val.PositiveIntValue != nil:
 		v = float64(*val.PositiveIntValue)
 	case val.NegativeIntValue != nil:
@@ -436,33 +599,14 @@ func valueOfBytes(val *pb.UninterpretedOption) protoreflect.Value {
 }
 
 func valueOfEnum(val *pb.UninterpretedOption, fd protoreflect.FieldDescriptor) protoreflect.Value {
-	var v protoreflect.EnumNumber
-	switch {
-	case val.PositiveIntValue != nil:
-		v = protoreflect.EnumNumber(*val.PositiveIntValue)
-	case val.NegativeIntValue != nil:
-		v = protoreflect.EnumNumber(*val.NegativeIntValue)
-	case val.IdentifierValue != nil:
-		e := fd.Enum().Values().ByName(protoreflect.Name(*val.IdentifierValue))
-		if e == nil {
-			return protoreflect.Value{}
-		}
-		v = e.Number()
-	default:
+	if val.IdentifierValue == nil {
 		return protoreflect.Value{}
 	}
-	return protoreflect.ValueOfEnum(v)
-}
-
-func valueOfMessage(val *pb.UninterpretedOption, m proto.Message, r resolver) protoreflect.Value {
-	if val.AggregateValue == nil {
+	e := fd.Enum().Values().ByName(protoreflect.Name(*val.IdentifierValue))
+	if e == nil {
 		return protoreflect.Value{}
 	}
-	o := prototext.UnmarshalOptions{Resolver: r}
-	if err := o.Unmarshal([]byte(*val.AggregateValue), m); err != nil {
-		panic(err)
-	}
-	return protoreflect.ValueOfMessage(m.ProtoReflect())
+	return protoreflect.ValueOfEnum(e.Number())
 }
 
 // readProtos creates ASTs for given files and their dependencies in
@@ -511,13 +655,18 @@ func readProto(file string, importPaths []string, done map[string]bool) ([]*ast,
 }
 
 func newAST(file string, r io.Reader) (*ast, error) {
-	proto, err := parser.Parse(file, r)
+	source, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("compile %s: %w", file, err)
+	}
+	proto, err := parser.ParseString(file, string(source))
 	if err != nil {
 		return nil, fmt.Errorf("compile %s: %w", file, err)
 	}
 	a := &ast{
 		file:   file,
 		proto:  proto,
+		source: source,
 		syntax: proto.Syntax,
 	}
 	for _, e := range proto.Entries {
@@ -1,15 +1,20 @@
 package compiler
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
 
+	"github.com/alecthomas/protobuf/parser"
 	"github.com/google/go-cmp/cmp"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/encoding/protowire"
 	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
 	"google.golang.org/protobuf/testing/protocmp"
 	pb "google.golang.org/protobuf/types/descriptorpb"
 )
@@ -65,8 +70,804 @@ func loadPB(t *testing.T, file string) *pb.FileDescriptorSet {
 	// load without it as it cannot resolve the imports.
 	f, err := protodesc.FileOptions{AllowUnresolvable: true}.NewFiles(fds)
 	require.NoError(t, err)
-	reg := &Registry{Files: *f}
+	reg, err := registryOf(f)
+	require.NoError(t, err)
 	err = proto.UnmarshalOptions{Resolver: reg}.Unmarshal(pbBytes, fds)
 	require.NoError(t, err)
 	return fds
 }
+
+func TestIncludeSourceInfo(t *testing.T) {
+	dir := t.TempDir()
+	source := `syntax = "proto3";
+
+package test;
+
+// Detached comment.
+
+// A message.
+message Msg {
+  string name = 1; // The name.
+
+  // Kinds of message.
+  enum Kind {
+    KIND_UNSPECIFIED = 0;
+  }
+}
+
+// A service.
+service Svc {
+  // A method.
+  rpc Get(Msg) returns (Msg);
+}
+`
+	err := os.WriteFile(filepath.Join(dir, "test.proto"), []byte(source), 0o600)
+	require.NoError(t, err)
+	fds, err := Compile([]string{"test.proto"}, []string{dir}, false, IncludeSourceInfo())
+	require.NoError(t, err)
+	fd, err := protodesc.NewFile(fds.File[0], nil)
+	require.NoError(t, err)
+
+	locs := fd.SourceLocations()
+	msg := fd.Messages().ByName("Msg")
+	loc := locs.ByDescriptor(msg)
+	require.Equal(t, " A message.\n", loc.LeadingComments)
+	require.Equal(t, []string{" Detached comment.\n"}, loc.LeadingDetachedComments)
+	require.Equal(t, 7, loc.StartLine)
+	require.Equal(t, 14, loc.EndLine)
+
+	loc = locs.ByDescriptor(msg.Fields().ByName("name"))
+	require.Equal(t, " The name.\n", loc.TrailingComments)
+	require.Equal(t, []int{8, 2, 8, 18}, []int{loc.StartLine, loc.StartColumn, loc.EndLine, loc.EndColumn})
+
+	require.Equal(t, " Kinds of message.\n", locs.ByDescriptor(msg.Enums().ByName("Kind")).LeadingComments)
+	svc := fd.Services().ByName("Svc")
+	require.Equal(t, " A service.\n", locs.ByDescriptor(svc).LeadingComments)
+	require.Equal(t, " A method.\n", locs.ByDescriptor(svc.Methods().ByName("Get")).LeadingComments)
+}
+
+func TestOptionsFor(t *testing.T) {
+	dir := t.TempDir()
+	source := `syntax = "proto3";
+
+package test;
+
+import "google/protobuf/descriptor.proto";
+
+message Rules {
+  StringRules string = 1;
+}
+
+message StringRules {
+  uint64 min_len = 1;
+}
+
+extend google.protobuf.FieldOptions {
+  Rules rules = 50000;
+}
+
+option java_package = "com.example";
+
+message Msg {
+  string name = 1 [(rules).string.min_len = 3, deprecated = true];
+  int32 count = 2;
+  map<string, string> labels = 3 [(test.rules) = {string: {min_len: 1}}];
+}
+
+enum Kind {
+  option allow_alias = true;
+  KIND_UNSPECIFIED = 0;
+  KIND_DEFAULT = 0 [deprecated = true];
+}
+`
+	err := os.WriteFile(filepath.Join(dir, "test.proto"), []byte(source), 0o600)
+	require.NoError(t, err)
+	result, err := Build([]string{"test.proto"}, []string{dir, "testdata"}, false)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(result.FileDescriptorSet.File))
+	require.Contains(t, result.ASTs, "google/protobuf/descriptor.proto")
+
+	ast := result.ASTs["test.proto"]
+	opts := result.OptionsFor(ast)
+	require.Equal(t, "com.example", opts.Interface().(*pb.FileOptions).GetJavaPackage())
+
+	var msg *parser.Message
+	var enum *parser.Enum
+	for _, e := range ast.Entries {
+		switch {
+		case e.Message != nil && e.Message.Name == "Msg":
+			msg = e.Message
+		case e.Enum != nil:
+			enum = e.Enum
+		}
+	}
+	name, count, labels := msg.Entries[0].Field, msg.Entries[1].Field, msg.Entries[2].Field
+
+	require.True(t, result.OptionsFor(name).Interface().(*pb.FieldOptions).GetDeprecated())
+	v, ok := result.Option(name, "(test.rules).string.min_len")
+	require.True(t, ok)
+	require.Equal(t, uint64(3), v.Uint())
+	v, ok = result.Option(labels, "(.test.rules).string.min_len")
+	require.True(t, ok)
+	require.Equal(t, uint64(1), v.Uint())
+	_, ok = result.Option(labels, "deprecated")
+	require.False(t, ok)
+	_, ok = result.Option(count, "(test.rules)")
+	require.False(t, ok)
+	_, ok = result.Option(name, "(test.unknown)")
+	require.False(t, ok)
+
+	// Declarations without options have empty options.
+	require.NotNil(t, result.OptionsFor(count))
+	require.Equal(t, 0, proto.Size(result.OptionsFor(count).Interface()))
+	require.NotNil(t, result.OptionsFor(msg))
+
+	require.True(t, result.OptionsFor(enum).Interface().(*pb.EnumOptions).GetAllowAlias())
+	v, ok = result.Option(enum.Values[2].Value, "deprecated")
+	require.True(t, ok)
+	require.True(t, v.Bool())
+
+	require.Nil(t, result.OptionsFor(&parser.Field{}))
+}
+
+const optionsProto = `syntax = "proto3";
+
+package test;
+
+import "google/protobuf/descriptor.proto";
+
+message Meta {
+  string owner = 1;
+  string note = 2 [retention = RETENTION_SOURCE];
+}
+
+extend google.protobuf.FieldOptions {
+  string field_only = 50000 [targets = TARGET_TYPE_FIELD];
+  string source_only = 50001 [retention = RETENTION_SOURCE];
+}
+
+extend google.protobuf.MessageOptions {
+  Meta meta = 50000 [targets = TARGET_TYPE_MESSAGE, targets = TARGET_TYPE_FIELD];
+  string field_only_on_message = 50001 [targets = TARGET_TYPE_FIELD];
+}
+`
+
+func TestOptionRetention(t *testing.T) {
+	dir := t.TempDir()
+	source := optionsProto + `
+message Msg {
+  option (meta) = {owner: "me", note: "source only"};
+  string name = 1 [(field_only) = "a", (source_only) = "b"];
+}
+`
+	err := os.WriteFile(filepath.Join(dir, "test.proto"), []byte(source), 0o600)
+	require.NoError(t, err)
+	importPaths := []string{dir, "testdata"}
+
+	result, err := Build([]string{"test.proto"}, importPaths, false)
+	require.NoError(t, err)
+	md := result.FileDescriptorSet.File[0].MessageType[1]
+	require.Equal(t, "Msg", md.GetName())
+	b, err := proto.Marshal(md.Field[0].Options)
+	require.NoError(t, err)
+	require.Equal(t, "\x82\xb5\x18\x01a", string(b))
+	b, err = proto.Marshal(md.Options)
+	require.NoError(t, err)
+	require.Equal(t, "\x82\xb5\x18\x04\n\x02me", string(b))
+
+	// The options of the result are not stripped.
+	var msg *parser.Message
+	for _, e := range result.ASTs["test.proto"].Entries {
+		if e.Message != nil && e.Message.Name == "Msg" {
+			msg = e.Message
+		}
+	}
+	v, ok := result.Option(msg.Entries[1].Field, "(test.source_only)")
+	require.True(t, ok)
+	require.Equal(t, "b", v.String())
+
+	fds, err := Compile([]string{"test.proto"}, importPaths, false, RetainOptions())
+	require.NoError(t, err)
+	md = fds.File[0].MessageType[1]
+	b, err = proto.MarshalOptions{Deterministic: true}.Marshal(md.Field[0].Options)
+	require.NoError(t, err)
+	require.Equal(t, "\x82\xb5\x18\x01a\x8a\xb5\x18\x01b", string(b))
+}
+
+func TestOptionTargets(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		err    string
+	}{
+		{name: "Allowed", source: `
+message Msg {
+  option (meta).owner = "me";
+  string name = 1 [(field_only) = "a", (source_only) = "b"];
+}`},
+		{name: "FieldOnlyOnMessage", source: `
+message Msg {
+  option (field_only_on_message) = "a";
+}`, err: "test.proto: option test.field_only_on_message cannot be used on message test.Msg, allowed targets: TARGET_TYPE_FIELD"},
+		{name: "NestedField", source: `
+message Inner {
+  string owner = 1 [targets = TARGET_TYPE_FIELD];
+}
+extend google.protobuf.EnumOptions {
+  Inner inner = 50000;
+}
+enum Kind {
+  option (inner).owner = "me";
+  KIND_UNSPECIFIED = 0;
+}`, err: "test.proto: option test.Inner.owner cannot be used on enum test.Kind, allowed targets: TARGET_TYPE_FIELD"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			err := os.WriteFile(filepath.Join(dir, "test.proto"), []byte(optionsProto+test.source), 0o600)
+			require.NoError(t, err)
+			_, err = Compile([]string{"test.proto"}, []string{dir, "testdata"}, false)
+			if test.err == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.EqualError(t, err, test.err)
+		})
+	}
+}
+
+const listOptionsProto = `syntax = "proto3";
+
+package test;
+
+import "google/protobuf/descriptor.proto";
+
+message Rules {
+  repeated int32 in = 1;
+  repeated string names = 2;
+  repeated Rules nested = 3;
+  Kind kind = 4;
+  repeated Kind kinds = 5;
+  uint32 max = 6;
+}
+
+enum Kind {
+  KIND_UNSPECIFIED = 0;
+  A = 1;
+  B = 2;
+}
+
+extend google.protobuf.FieldOptions {
+  Rules rules = 50000;
+  repeated int32 ints = 50001;
+  int32 int = 50002;
+  uint64 big = 50003;
+  int64 long = 50004;
+  float ratio = 50005;
+  double precise = 50006;
+}
+`
+
+func TestOptionValues(t *testing.T) {
+	tests := []struct {
+		name   string
+		field  string
+		option string
+		want   string
+		err    string
+	}{
+		{name: "AggregateLists",
+			field:  `[(rules) = {in: [1, 2, 3], names: ["a", "b\"c"], nested: [{in: [4]}, {kinds: [A, B]}]}]`,
+			option: "(test.rules)",
+			want:   `in:1 in:2 in:3 names:"a" names:"b\"c" nested:{in:4} nested:{kinds:A kinds:B}`},
+		{name: "AggregateEmptyList",
+			field:  `[(rules) = {in: [], max: 1}]`,
+			option: "(test.rules)",
+			want:   `max:1`},
+		{name: "RepeatedAssignment",
+			field:  `[(ints) = 1, (ints) = -2]`,
+			option: "(test.ints)",
+			want:   `[1, -2]`},
+		{name: "RepeatedAssignmentInMessage",
+			field:  `[(rules).in = 5, (rules).in = 6, (rules).kind = B]`,
+			option: "(test.rules)",
+			want:   `in:5 in:6 kind:B`},
+		{name: "ListAssignment",
+			field:  `[(ints) = [1, 2], (ints) = 3]`,
+			option: "(test.ints)",
+			want:   `[1, 2, 3]`},
+		{name: "ListAssignmentInMessage",
+			field:  `[(rules).kinds = [A, B]]`,
+			option: "(test.rules)",
+			want:   `kinds:A kinds:B`},
+		{name: "ListForSingular",
+			field: `[(int) = [1, 2]]`,
+			err:   "test.proto:33:24: test.int: list value for non-repeated field"},
+		{name: "NestedList",
+			field: `[(ints) = [[1]]]`,
+			err:   "test.proto:33:26: test.ints: lists cannot be nested"},
+		{name: "ListElementType",
+			field: `[(ints) = [1, "two"]]`,
+			err:   `test.proto:33:29: test.ints: cannot use "two" as int32 value`},
+		{name: "Int32Range",
+			field: `[(ints) = 2147483648]`,
+			err:   "test.proto:33:25: test.ints: 2147483648 is out of range for int32"},
+		{name: "NegativeUint32",
+			field: `[(rules).max = -1]`,
+			err:   "test.proto:33:30: test.Rules.max: -1 is out of range for uint32"},
+		{name: "Uint32Range",
+			field: `[(rules).max = 0x10000000000]`,
+			err:   "test.proto:33:30: test.Rules.max: 1099511627776 is out of range for uint32"},
+		{name: "AggregateUint32Range",
+			field: `[(rules) = {max: 4294967296}]`,
+			err:   "test.proto:33:32: test.Rules.max: 4294967296 is out of range for uint32"},
+		{name: "EnumNumber",
+			field: `[(rules).kind = 99]`,
+			err:   "test.proto:33:31: test.Rules.kind: cannot use 99 as enum value"},
+		{name: "EnumNumberRange",
+			field: `[(rules).kind = -2147483649]`,
+			err:   "test.proto:33:31: test.Rules.kind: cannot use -2147483649 as enum value"},
+		{name: "IntegralFloatForUint32",
+			field: `[(rules).max = 5.0]`,
+			err:   "test.proto:33:30: test.Rules.max: cannot use 5.0 as uint32 value"},
+		{name: "IntegralFloatForInt32",
+			field: `[(ints) = 1.0]`,
+			err:   "test.proto:33:25: test.ints: cannot use 1.0 as int32 value"},
+		{name: "IntegralFloatInAggregate",
+			field: `[(rules) = {max: 1e3}]`,
+			err:   "test.proto:33:32: test.Rules.max: cannot use 1e3 as uint32 value"},
+		{name: "Uint64Max",
+			field:  `[(big) = 18446744073709551615]`,
+			option: "(test.big)",
+			want:   "18446744073709551615"},
+		{name: "Uint64Range",
+			field: `[(big) = 18446744073709551616]`,
+			err:   "test.proto:33:24: test.big: 18446744073709551616 is out of range for uint64"},
+		{name: "Uint64RangeBeyondPrecision",
+			field: `[(big) = 0x10000000000000001]`,
+			err:   "test.proto:33:24: test.big: 0x10000000000000001 is out of range for uint64"},
+		{name: "Uint64RangeInAggregate",
+			field: `[(rules) = { max: 077777777777777777777777 }]`,
+			err:   "test.proto:33:33: test.Rules.max: 077777777777777777777777 is out of range for uint32"},
+		{name: "Int64Min",
+			field:  `[(long) = -9223372036854775808]`,
+			option: "(test.long)",
+			want:   "-9223372036854775808"},
+		{name: "Int64Range",
+			field: `[(long) = -9223372036854775809]`,
+			err:   "test.proto:33:25: test.long: -9223372036854775809 is out of range for int64"},
+		{name: "FloatMax",
+			field:  `[(ratio) = 3.4e38]`,
+			option: "(test.ratio)",
+			want:   "3.4e+38"},
+		{name: "FloatRange",
+			field: `[(ratio) = -1e39]`,
+			err:   "test.proto:33:26: test.ratio: -1e39 is out of range for float"},
+		{name: "DoubleInf",
+			field:  `[(precise) = -inf]`,
+			option: "(test.precise)",
+			want:   "-Inf"},
+		{name: "UnknownEnumValue",
+			field: `[(rules).kinds = [A, C]]`,
+			err:   "test.proto:33:36: test.Rules.kinds: cannot use C as enum value"},
+		{name: "AggregateType",
+			field: `[(rules) = {in: ["x"]}]`,
+			err:   `test.proto:33:32: test.Rules.in: cannot use "x" as int32 value`},
+		{name: "AlreadySet",
+			field: `[(int) = 1, (int) = 2]`,
+			err:   "test.proto:33:35: test.int: option was already set"},
+		{name: "RepeatedMessagePath",
+			field: `[(rules).nested.max = 1]`,
+			err:   "test.proto:33:16: test.Rules.nested: repeated option field must be set with an aggregate value"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			source := listOptionsProto + "\nmessage Msg {\n  int32 f = 1 " + test.field + ";\n}\n"
+			err := os.WriteFile(filepath.Join(dir, "test.proto"), []byte(source), 0o600)
+			require.NoError(t, err)
+			result, err := Build([]string{"test.proto"}, []string{dir, "testdata"}, false)
+			if test.err != "" {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), test.err)
+				return
+			}
+			require.NoError(t, err)
+			var field *parser.Field
+			for _, e := range result.ASTs["test.proto"].Entries {
+				if e.Message != nil && e.Message.Name == "Msg" {
+					field = e.Message.Entries[0].Field
+				}
+			}
+			v, ok := result.Option(field, test.option)
+			require.True(t, ok)
+			var got string
+			switch v := v.Interface().(type) {
+			case protoreflect.Message:
+				got = prototext.MarshalOptions{}.Format(v.Interface())
+			case protoreflect.List:
+				elems := make([]string, v.Len())
+				for i := range elems {
+					elems[i] = v.Get(i).String()
+				}
+				got = "[" + strings.Join(elems, ", ") + "]"
+			default:
+				got = fmt.Sprint(v)
+			}
+			require.Equal(t, test.want, strings.Join(strings.Fields(got), " "))
+		})
+	}
+}
+
+const aggregateOptionsProto = `syntax = "proto2";
+
+package test.sub;
+
+import "google/protobuf/any.proto";
+import "google/protobuf/descriptor.proto";
+
+message Rules {
+  optional int32 min = 1;
+  map<string, int32> limits = 2;
+  map<int32, Rules> children = 3;
+  optional group Extra = 4 {
+    optional bool on = 1;
+  }
+  oneof choice {
+    string a = 5;
+    string b = 6;
+  }
+  optional google.protobuf.Any detail = 7;
+  optional bool flag = 8;
+  extensions 100 to 200;
+}
+
+message Detail {
+  optional string note = 1;
+  repeated int32 ids = 2;
+}
+
+extend google.protobuf.FieldOptions {
+  optional Rules rules = 50000;
+}
+
+extend Rules {
+  optional int32 top = 100;
+}
+
+message Other {
+  extend Rules {
+    optional string other = 102;
+  }
+}
+
+message Outer {
+  extend Rules {
+    optional string nested = 101;
+  }
+`
+
+func TestAggregateOptions(t *testing.T) {
+	tests := []struct {
+		name  string
+		field string
+		want  string
+		err   string
+	}{
+		{name: "ScopedExtensions",
+			field: `[(rules) = {[nested]: "n" [sub.top]: 1 [Other.other]: "o" [.test.sub.top]: 2}]`,
+			err:   "test.proto:47:99: test.sub.top: option was already set"},
+		{name: "RelativeExtensions",
+			field: `[(rules) = {[nested]: "n" [sub.top]: 1 [Other.other]: "o"}]`,
+			want:  `[test.sub.Other.other]:"o" [test.sub.Outer.nested]:"n" [test.sub.top]:1`},
+		{name: "AnyExpansion",
+			field: `[(rules) = {detail {[type.googleapis.com/test.sub.Detail] {note: "x" ids: [1, 2]}}}]`,
+			want:  `detail:{[type.googleapis.com/test.sub.Detail]:{note:"x" ids:1 ids:2}}`},
+		{name: "Maps",
+			field: `[(rules) = {limits [{key: "a" value: 1}, {key: "b"}] children {key: 1 value {min: 2}}}]`,
+			want:  `limits:{key:"a" value:1} limits:{key:"b" value:0} children:{key:1 value:{min:2}}`},
+		{name: "GroupAndTextBools",
+			field: `[(rules) = {Extra {on: t} flag: 0}]`,
+			want:  `Extra:{on:true} flag:false`},
+		{name: "UnknownExtension",
+			field: `[(rules) = {[missing]: 1}]`,
+			err:   "test.proto:47:36: unknown extension missing"},
+		{name: "WrongExtendee",
+			field: `[(rules) = {Extra {[top]: 1}}]`,
+			err:   "test.proto:47:43: extension test.sub.top does not extend test.sub.Rules.Extra"},
+		{name: "UnknownField",
+			field: `[(rules) = {extra: {}}]`,
+			err:   "test.proto:47:36: unknown field extra in test.sub.Rules"},
+		{name: "Oneof",
+			field: `[(rules) = {a: "a" b: "b"}]`,
+			err:   "test.proto:47:43: test.sub.Rules.b: oneof choice already has field a set"},
+		{name: "TypeURLOutsideAny",
+			field: `[(rules) = {[type.googleapis.com/test.sub.Detail] {}}]`,
+			err:   "test.proto:47:36: type URL [type.googleapis.com/test.sub.Detail] can only be used in google.protobuf.Any, not test.sub.Rules"},
+		{name: "UnknownAnyType",
+			field: `[(rules) = {detail {[type.googleapis.com/test.sub.Missing] {}}}]`,
+			err:   "test.proto:47:44: unknown message type test.sub.Missing in type URL"},
+		{name: "AnyWithTypeURL",
+			field: `[(rules) = {detail {type_url: "x" [type.googleapis.com/test.sub.Detail] {}}}]`,
+			err:   "test.proto:47:58: google.protobuf.Any can only hold one value"},
+		{name: "AnyFieldError",
+			field: `[(rules) = {detail {[type.googleapis.com/test.sub.Detail] {ids: ["x"]}}}]`,
+			err:   `test.proto:47:89: test.sub.Detail.ids: cannot use "x" as int32 value`},
+		{name: "AggregateForScalar",
+			field: `[(rules) = {min {}}]`,
+			err:   "test.proto:47:40: test.sub.Rules.min: cannot use aggregate value as int32 value"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			source := aggregateOptionsProto + "  optional int32 f = 1 " + test.field + ";\n}\n"
+			err := os.WriteFile(filepath.Join(dir, "test.proto"), []byte(source), 0o600)
+			require.NoError(t, err)
+			result, err := Build([]string{"test.proto"}, []string{dir, "testdata", "../testdata/conformance"}, false)
+			if test.err != "" {
+				require.EqualError(t, err, test.err)
+				return
+			}
+			require.NoError(t, err)
+			var field *parser.Field
+			for _, e := range result.ASTs["test.proto"].Entries {
+				if e.Message != nil && e.Message.Name == "Outer" {
+					field = e.Message.Entries[1].Field
+				}
+			}
+			v, ok := result.Option(field, "(test.sub.rules)")
+			require.True(t, ok)
+			got := prototext.MarshalOptions{Resolver: result.registry}.Format(v.Message().Interface())
+			require.Equal(t, test.want, strings.Join(strings.Fields(got), " "))
+		})
+	}
+}
+
+func TestGroupNames(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		err    string
+	}{
+		{name: "Field",
+			source: "message M {\n  optional group lower = 1 {}\n}\n",
+			err:    "test.proto:3:12: group lower: group names must start with a capital letter"},
+		{name: "Oneof",
+			source: "message M {\n  oneof o {\n    group _Under = 1 {}\n  }\n}\n",
+			err:    "test.proto:4:5: group _Under: group names must start with a capital letter"},
+		{name: "Extend",
+			source: "message M {\n  extensions 10 to 20;\n  extend M {\n    optional group ext = 10 {}\n  }\n}\n",
+			err:    "test.proto:5:14: group ext: group names must start with a capital letter"},
+		{name: "Nested",
+			source: "message M {\n  optional group G = 1 {\n    optional group g = 2 {}\n  }\n}\n",
+			err:    "test.proto:4:14: group g: group names must start with a capital letter"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			source := "syntax = \"proto2\";\n" + test.source
+			err := os.WriteFile(filepath.Join(dir, "test.proto"), []byte(source), 0o600)
+			require.NoError(t, err)
+			_, err = Compile([]string{"test.proto"}, []string{dir}, false)
+			require.EqualError(t, err, test.err)
+		})
+	}
+}
+
+func TestProto3Extends(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		err    string
+	}{
+		{name: "Options",
+			source: "extend google.protobuf.FieldOptions {\n  string label = 50000;\n}\n"},
+		{name: "NestedOptions",
+			source: "message M {\n  extend google.protobuf.MessageOptions {\n    string tag = 50000;\n  }\n}\n"},
+		{name: "Message",
+			source: "message M {}\nextend Base {\n  string name = 100;\n}\n",
+			err:    "test.proto:6:1: extend test.Base: extensions in proto3 are only allowed for defining options"},
+		{name: "NestedMessage",
+			source: "message M {\n  extend .test.Base {\n    string name = 100;\n  }\n}\n",
+			err:    "test.proto:6:3: extend test.Base: extensions in proto3 are only allowed for defining options"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			base := "syntax = \"proto2\";\npackage test;\nmessage Base {\n  extensions 100 to 199;\n}\n"
+			err := os.WriteFile(filepath.Join(dir, "base.proto"), []byte(base), 0o600)
+			require.NoError(t, err)
+			source := "syntax = \"proto3\";\npackage test;\nimport \"base.proto\";\nimport \"google/protobuf/descriptor.proto\";\n" + test.source
+			err = os.WriteFile(filepath.Join(dir, "test.proto"), []byte(source), 0o600)
+			require.NoError(t, err)
+			_, err = Compile([]string{"test.proto"}, []string{dir, "../testdata/conformance"}, false)
+			if test.err == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.EqualError(t, err, test.err)
+		})
+	}
+}
+
+func TestUnknownReferences(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		err    string
+	}{
+		{name: "Field",
+			source: "message M {\n  optional Missing s = 1;\n}\n",
+			err:    "test.proto:5:12: unknown type Missing"},
+		{name: "MapValue",
+			source: "message M {\n  map<string, Missing> m = 1;\n}\n",
+			err:    "test.proto:5:15: unknown type Missing"},
+		{name: "Extendee",
+			source: "extend Missing {\n  optional int32 x = 100;\n}\n",
+			err:    "test.proto:4:1: unknown type Missing"},
+		{name: "FileOption",
+			source: "option (nope) = 1;\n",
+			err:    "test.proto:4:8: unknown option (nope)"},
+		{name: "FieldOption",
+			source: "message M {\n  optional int32 x = 1 [(nope) = 1];\n}\n",
+			err:    "test.proto:5:25: unknown option (nope)"},
+		{name: "GroupOption",
+			source: "message M {\n  optional group G = 1 [(nope) = 1] {}\n}\n",
+			err:    "test.proto:5:25: unknown option (nope)"},
+		{name: "ExtensionsOption",
+			source: "message M {\n  extensions 100 to 199 [(nope) = 1];\n}\n",
+			err:    "test.proto:5:26: unknown option (nope)"},
+		{name: "OutOfScopeOption",
+			source: "message M {\n  extend google.protobuf.MessageOptions {\n    optional string tag = 50000;\n  }\n}\nmessage N {\n  option (tag) = \"n\";\n}\n",
+			err:    "test.proto:10:10: unknown option (tag)"},
+		{name: "ScopedOption",
+			source: "message M {\n  message N {\n    option (tag) = \"n\";\n  }\n  extend google.protobuf.MessageOptions {\n    optional string tag = 50000;\n  }\n}\n"},
+		{name: "MethodRequest",
+			source: "message M {}\nservice S {\n  rpc R(Missing) returns (M);\n}\n",
+			err:    "test.proto:6:9: unknown type Missing"},
+		{name: "MethodEnum",
+			source: "message M {}\nenum E {\n  A = 0;\n}\nservice S {\n  rpc R(M) returns (E);\n}\n",
+			err:    "test.proto:9:21: method R: the response type E must be a message"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			source := "syntax = \"proto2\";\nimport \"google/protobuf/descriptor.proto\";\n\n" + test.source
+			err := os.WriteFile(filepath.Join(dir, "test.proto"), []byte(source), 0o600)
+			require.NoError(t, err)
+			_, err = Compile([]string{"test.proto"}, []string{dir, "../testdata/conformance"}, false)
+			if test.err == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.EqualError(t, err, test.err)
+		})
+	}
+}
+
+func TestGroupEncoding(t *testing.T) {
+	fds, err := Compile([]string{"21_proto2_group_scopes.proto"}, []string{"testdata"}, true)
+	require.NoError(t, err)
+	reg, err := NewRegistry(fds)
+	require.NoError(t, err)
+
+	// A group in a oneof.
+	mt, err := reg.FindMessageByName("pkg.Scope")
+	require.NoError(t, err)
+	scope := mt.New()
+	alternative := scope.Descriptor().Fields().ByName("alternative")
+	require.Equal(t, protoreflect.GroupKind, alternative.Kind())
+	require.Equal(t, protoreflect.Name("choice"), alternative.ContainingOneof().Name())
+	value := scope.NewField(alternative)
+	value.Message().Set(alternative.Message().Fields().ByName("id"), protoreflect.ValueOfInt64(7))
+	scope.Set(alternative, value)
+
+	// Groups are delimited by start and end group tags rather than
+	// length prefixed.
+	want := protowire.AppendTag(nil, 4, protowire.StartGroupType)
+	want = protowire.AppendTag(want, 5, protowire.VarintType)
+	want = protowire.AppendVarint(want, 7)
+	want = protowire.AppendTag(want, 4, protowire.EndGroupType)
+	requireRoundTrip(t, reg, scope, want)
+
+	// A group extension declared in a message, containing a group.
+	mt, err = reg.FindMessageByName("pkg.Base")
+	require.NoError(t, err)
+	base := mt.New()
+	xt, err := reg.FindExtensionByName("pkg.Scope.nested")
+	require.NoError(t, err)
+	xd := xt.TypeDescriptor()
+	require.Equal(t, protoreflect.GroupKind, xd.Kind())
+	require.Equal(t, protoreflect.FullName("pkg.Scope.Nested"), xd.Message().FullName())
+	nested := xt.New().Message()
+	inner := nested.Descriptor().Fields().ByName("inner")
+	value = nested.NewField(inner)
+	value.Message().Set(inner.Message().Fields().ByName("i"), protoreflect.ValueOfInt32(3))
+	nested.Set(inner, value)
+	base.Set(xd, protoreflect.ValueOfMessage(nested))
+
+	want = protowire.AppendTag(nil, 110, protowire.StartGroupType)
+	want = protowire.AppendTag(want, 2, protowire.StartGroupType)
+	want = protowire.AppendTag(want, 3, protowire.VarintType)
+	want = protowire.AppendVarint(want, 3)
+	want = protowire.AppendTag(want, 2, protowire.EndGroupType)
+	want = protowire.AppendTag(want, 110, protowire.EndGroupType)
+	requireRoundTrip(t, reg, base, want)
+}
+
+func requireRoundTrip(t *testing.T, reg *Registry, msg protoreflect.Message, want []byte) {
+	t.Helper()
+	b, err := proto.MarshalOptions{Deterministic: true}.Marshal(msg.Interface())
+	require.NoError(t, err)
+	require.Equal(t, want, b)
+	got := msg.Type().New().Interface()
+	require.NoError(t, proto.UnmarshalOptions{Resolver: reg}.Unmarshal(b, got))
+	requireProtoEqual(t, msg.Interface(), got)
+}
+
+func TestBuildFiles(t *testing.T) {
+	result, err := Build([]string{"17_proto2_custom_options.proto"}, []string{"testdata"}, false)
+	require.NoError(t, err)
+	require.Len(t, result.Files(), 1)
+	fd := result.Files()[0]
+	require.Equal(t, "17_proto2_custom_options.proto", fd.Path())
+
+	// The options of the linked descriptors are resolved.
+	md := fd.Messages().ByName("User")
+	require.NotNil(t, md)
+	opts := md.Options().(*pb.MessageOptions)
+	require.True(t, opts.GetDeprecated())
+	require.Empty(t, opts.GetUninterpretedOption())
+	xt, err := result.Registry().FindExtensionByName("pkg.opt4")
+	require.NoError(t, err)
+	require.Equal(t, "opt4", opts.ProtoReflect().Get(xt.TypeDescriptor()).String())
+
+	mt, err := result.Registry().FindMessageByName("pkg.User")
+	require.NoError(t, err)
+	msg := mt.New()
+	msg.Set(md.Fields().ByName("num"), protoreflect.ValueOfInt64(42))
+	b, err := proto.Marshal(msg.Interface())
+	require.NoError(t, err)
+	got := mt.New().Interface()
+	require.NoError(t, proto.UnmarshalOptions{Resolver: result.Registry()}.Unmarshal(b, got))
+	requireProtoEqual(t, msg.Interface(), got)
+}
+
+func TestLinkErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		pos    string
+		err    string
+	}{
+		{name: "DuplicateImportedMessage",
+			source: "import \"base.proto\";\n\nmessage Base {}\n",
+			pos:    "test.proto:5:1",
+			err:    `file "test.proto" has a name conflict over test.Base`},
+		{name: "DuplicateEnumValue",
+			source: "enum E {\n  A = 0;\n  B = 1;\n  A = 2;\n}\n",
+			pos:    "test.proto:6:3",
+			err:    `descriptor "test.A" already declared`},
+		{name: "DuplicateNestedMessage",
+			source: "message M {\n  message N {}\n  enum N {\n    X = 0;\n  }\n}\n",
+			pos:    "test.proto:5:3",
+			err:    `descriptor "test.M.N" already declared`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			base := "syntax = \"proto3\";\npackage test;\nmessage Base {}\n"
+			err := os.WriteFile(filepath.Join(dir, "base.proto"), []byte(base), 0o600)
+			require.NoError(t, err)
+			source := "syntax = \"proto3\";\npackage test;\n" + test.source
+			err = os.WriteFile(filepath.Join(dir, "test.proto"), []byte(source), 0o600)
+			require.NoError(t, err)
+			_, err = Build([]string{"test.proto"}, []string{dir}, false)
+			// The text of protodesc errors is deliberately unstable, so
+			// only its end is compared.
+			require.Error(t, err)
+			require.True(t, strings.HasPrefix(err.Error(), test.pos+": "), err.Error())
+			require.True(t, strings.HasSuffix(err.Error(), test.err), err.Error())
+		})
+	}
+}
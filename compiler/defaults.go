@@ -0,0 +1,240 @@
+package compiler
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+
+	pb "google.golang.org/protobuf/types/descriptorpb"
+
+	"github.com/alecthomas/protobuf/parser"
+)
+
+// resolveDefaults sets the default values of all fields with a default
+// option, formatted as protoc formats them, and returns an error with the
+// position of the first default that does not fit its field.
+func resolveDefaults(all *pb.FileDescriptorSet, types *types) error {
+	enums := map[string]map[string]bool{}
+	for _, fd := range all.File {
+		prefix := ""
+		if fd.GetPackage() != "" {
+			prefix = "." + fd.GetPackage()
+		}
+		indexEnums(enums, prefix, fd.EnumType, fd.MessageType)
+	}
+	for _, fd := range all.File {
+		fields := fd.Extension
+		fields = appendFields(fields, fd.MessageType)
+		for _, field := range fields {
+			o, ok := types.defaults[field]
+			if !ok {
+				continue
+			}
+			dv, err := formatDefault(o.Value, field, enums)
+			if err == nil && fd.GetSyntax() == "proto3" {
+				err = fmt.Errorf("default values are not allowed in proto3")
+			}
+			if err != nil {
+				return fmt.Errorf("%s: field %s: %w", o.Value.Pos, field.GetName(), err)
+			}
+			field.DefaultValue = &dv
+		}
+	}
+	return nil
+}
+
+// indexEnums adds the names of the values of the enums declared in the
+// given scope to enums, by the full name of the enum.
+func indexEnums(enums map[string]map[string]bool, scope string, eds []*pb.EnumDescriptorProto, mds []*pb.DescriptorProto) {
+	for _, ed := range eds {
+		values := map[string]bool{}
+		for _, v := range ed.Value {
+			values[v.GetName()] = true
+		}
+		enums[scope+"."+ed.GetName()] = values
+	}
+	for _, md := range mds {
+		indexEnums(enums, scope+"."+md.GetName(), md.EnumType, md.NestedType)
+	}
+}
+
+// appendFields appends the fields and extensions of mds and their nested
+// messages to fields.
+func appendFields(fields []*pb.FieldDescriptorProto, mds []*pb.DescriptorProto) []*pb.FieldDescriptorProto {
+	for _, md := range mds {
+		fields = append(fields, md.Field...)
+		fields = append(fields, md.Extension...)
+		fields = appendFields(fields, md.NestedType)
+	}
+	return fields
+}
+
+// formatDefault formats a default value for a field the way protoc does:
+//
+//   - integers in decimal, whatever base they were written in
+//   - floats and doubles with the fewest digits that round-trip, or as
+//     inf, -inf or nan
+//   - strings as they are and bytes C-escaped
+//   - bools and enums by name
+//
+// It returns an error if the value does not fit the type of the field.
+func formatDefault(val *parser.Value, fd *pb.FieldDescriptorProto, enums map[string]map[string]bool) (string, error) {
+	if fd.GetLabel() == pb.FieldDescriptorProto_LABEL_REPEATED {
+		return "", fmt.Errorf("repeated fields cannot have default values")
+	}
+	switch fd.GetType() {
+	case pb.FieldDescriptorProto_TYPE_INT32, pb.FieldDescriptorProto_TYPE_SINT32, pb.FieldDescriptorProto_TYPE_SFIXED32:
+		return formatInt(val, fd, math.MinInt32, math.MaxInt32)
+	case pb.FieldDescriptorProto_TYPE_INT64, pb.FieldDescriptorProto_TYPE_SINT64, pb.FieldDescriptorProto_TYPE_SFIXED64:
+		return formatInt(val, fd, math.MinInt64, math.MaxInt64)
+	case pb.FieldDescriptorProto_TYPE_UINT32, pb.FieldDescriptorProto_TYPE_FIXED32:
+		return formatInt(val, fd, 0, math.MaxUint32)
+	case pb.FieldDescriptorProto_TYPE_UINT64, pb.FieldDescriptorProto_TYPE_FIXED64:
+		return formatInt(val, fd, 0, math.MaxUint64)
+	case pb.FieldDescriptorProto_TYPE_FLOAT, pb.FieldDescriptorProto_TYPE_DOUBLE:
+		return formatFloat(val, fd)
+	case pb.FieldDescriptorProto_TYPE_BOOL:
+		if val.Bool == nil {
+			return "", fmt.Errorf("default value %s must be true or false", literal(val))
+		}
+		return strconv.FormatBool(bool(*val.Bool)), nil
+	case pb.FieldDescriptorProto_TYPE_STRING:
+		if val.String == nil {
+			return "", fmt.Errorf("default value %s must be a string", literal(val))
+		}
+		return *val.String, nil
+	case pb.FieldDescriptorProto_TYPE_BYTES:
+		if val.String == nil {
+			return "", fmt.Errorf("default value %s must be a string", literal(val))
+		}
+		return cEscape(*val.String), nil
+	case pb.FieldDescriptorProto_TYPE_ENUM:
+		if val.Reference == nil || strings.Contains(*val.Reference, ".") {
+			return "", fmt.Errorf("default value %s of an enum field must be the name of an enum value", literal(val))
+		}
+		if !enums[fd.GetTypeName()][*val.Reference] {
+			return "", fmt.Errorf("enum %s has no value named %s", strings.TrimPrefix(fd.GetTypeName(), "."), *val.Reference)
+		}
+		return *val.Reference, nil
+	case pb.FieldDescriptorProto_TYPE_MESSAGE, pb.FieldDescriptorProto_TYPE_GROUP:
+		return "", fmt.Errorf("message fields cannot have default values")
+	default:
+		panic(fmt.Sprintf("formatDefault: unknown field type %s", fd.GetType()))
+	}
+}
+
+func formatInt(val *parser.Value, fd *pb.FieldDescriptorProto, minimum int64, maximum uint64) (string, error) {
+	if val.Number == nil || val.Number.IsInf() || !val.Number.IsInt() {
+		return "", fmt.Errorf("default value %s must be an integer", literal(val))
+	}
+	i, _ := val.Number.Int(nil)
+	if minimum == 0 && val.Number.Signbit() {
+		return "", fmt.Errorf("default value %s of an unsigned field cannot be negative", literal(val))
+	}
+	if i.Cmp(big.NewInt(minimum)) < 0 || i.Cmp(new(big.Int).SetUint64(maximum)) > 0 {
+		return "", fmt.Errorf("default value %s is out of range for %s", literal(val), typeName(fd))
+	}
+	if val.Number.Signbit() && i.Sign() == 0 {
+		// protoc keeps the sign of -0.
+		return "-0", nil
+	}
+	return i.String(), nil
+}
+
+func formatFloat(val *parser.Value, fd *pb.FieldDescriptorProto) (string, error) {
+	switch {
+	case val.NaN != nil:
+		// protoc keeps the sign of -nan, but the Go runtime cannot parse
+		// it and the sign of a nan has no meaning.
+		return "nan", nil
+	case val.Reference != nil && (*val.Reference == "inf" || *val.Reference == "nan"):
+		return *val.Reference, nil
+	case val.Number == nil:
+		return "", fmt.Errorf("default value %s must be a number", literal(val))
+	}
+	f, _ := val.Number.Float64()
+	if !val.Number.IsInf() && math.IsInf(f, 0) ||
+		fd.GetType() == pb.FieldDescriptorProto_TYPE_FLOAT && !math.IsInf(f, 0) && math.IsInf(float64(float32(f)), 0) {
+		return "", fmt.Errorf("default value %s is out of range for %s", literal(val), typeName(fd))
+	}
+	if fd.GetType() == pb.FieldDescriptorProto_TYPE_FLOAT {
+		return formatDouble(float64(float32(f)), 32), nil
+	}
+	return formatDouble(f, 64), nil
+}
+
+// formatDouble formats f, of the given bit size, like protoc formats the
+// defaults of descriptors: doubles as %.15g like SimpleDtoa and floats as
+// %.6g like SimpleFtoa, or with 17 and 9 digits if that does not parse
+// back to f.
+func formatDouble(f float64, bitSize int) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	case math.IsNaN(f):
+		return "nan"
+	}
+	digits, fallback := 15, 17
+	if bitSize == 32 {
+		digits, fallback = 6, 9
+	}
+	s := strconv.FormatFloat(f, 'g', digits, bitSize)
+	if g, err := strconv.ParseFloat(s, bitSize); err != nil || g != f {
+		s = strconv.FormatFloat(f, 'g', fallback, bitSize)
+	}
+	return s
+}
+
+// cEscape escapes s like protoc's CEscape: \n, \r, \t, quotes and
+// backslashes are escaped with a backslash, other unprintable bytes are
+// escaped as three octal digits.
+func cEscape(s string) string {
+	var out strings.Builder
+	for _, b := range []byte(s) {
+		switch {
+		case b == '\n':
+			out.WriteString(`\n`)
+		case b == '\r':
+			out.WriteString(`\r`)
+		case b == '\t':
+			out.WriteString(`\t`)
+		case b == '"' || b == '\'' || b == '\\':
+			out.WriteByte('\\')
+			out.WriteByte(b)
+		case b >= 0x20 && b <= 0x7E: // printable
+			out.WriteByte(b)
+		default:
+			fmt.Fprintf(&out, `\%03o`, b)
+		}
+	}
+	return out.String()
+}
+
+// literal returns val as it would be written in a .proto file, with
+// integers in decimal.
+func literal(val *parser.Value) string {
+	if val.Number != nil && val.Number.IsInf() {
+		return formatDouble(math.Inf(val.Number.Sign()), 64)
+	}
+	if val.Number != nil && val.Number.IsInt() && val.Number.MantExp(nil) <= 128 {
+		i, _ := val.Number.Int(nil)
+		if val.Number.Signbit() && i.Sign() == 0 {
+			return "-0"
+		}
+		return i.String()
+	}
+	if val.Number != nil {
+		return val.Number.Text('g', -1)
+	}
+	return val.ToString()
+}
+
+// typeName returns the name of the scalar type of fd as it is written in
+// .proto files.
+func typeName(fd *pb.FieldDescriptorProto) string {
+	return strings.ToLower(strings.TrimPrefix(fd.GetType().String(), "TYPE_"))
+}
@@ -0,0 +1,203 @@
+package compiler
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	pb "google.golang.org/protobuf/types/descriptorpb"
+
+	"github.com/alecthomas/protobuf/parser"
+)
+
+func TestDefaultValues(t *testing.T) {
+	tests := []struct {
+		field  string
+		syntax string
+		want   string
+		err    string
+	}{
+		{field: "optional int32 f = 1 [default = 0x7fffffff];", want: "2147483647"},
+		{field: "optional sfixed32 f = 1 [default = -0x80000000];", want: "-2147483648"},
+		{field: "optional int32 f = 1 [default = 0x80000000];",
+			err: "test.proto:8:35: field f: default value 2147483648 is out of range for int32"},
+		{field: "optional sint64 f = 1 [default = 010];", want: "8"},
+		{field: "optional int64 f = 1 [default = -0];", want: "-0"},
+		{field: "optional int64 f = 1 [default = -9223372036854775809];",
+			err: "test.proto:8:35: field f: default value -9223372036854775809 is out of range for int64"},
+		{field: "optional int32 f = 1 [default = 1.5];",
+			err: "test.proto:8:35: field f: default value 1.5 must be an integer"},
+		{field: "optional int32 f = 1 [default = -inf];",
+			err: "test.proto:8:35: field f: default value -inf must be an integer"},
+		{field: "optional uint32 f = 1 [default = -1];",
+			err: "test.proto:8:36: field f: default value -1 of an unsigned field cannot be negative"},
+		{field: "optional fixed64 f = 1 [default = 18446744073709551615];", want: "18446744073709551615"},
+		{field: "optional uint64 f = 1 [default = 18446744073709551616];",
+			err: "test.proto:8:36: field f: default value 18446744073709551616 is out of range for uint64"},
+		{field: "optional double f = 1 [default = 2E8];", want: "200000000"},
+		{field: "optional float f = 1 [default = 2E8];", want: "2e+08"},
+		{field: "optional double f = 1 [default = 0x10];", want: "16"},
+		{field: "optional double f = 1 [default = 0.1];", want: "0.1"},
+		{field: "optional double f = 1 [default = 1234567.125];", want: "1234567.125"},
+		{field: "optional double f = 1 [default = 1e6];", want: "1000000"},
+		{field: "optional double f = 1 [default = 123456789012];", want: "123456789012"},
+		{field: "optional double f = 1 [default = 1e15];", want: "1e+15"},
+		{field: "optional double f = 1 [default = 0.30000000000000004];", want: "0.30000000000000004"},
+		{field: "optional float f = 1 [default = 1e6];", want: "1e+06"},
+		{field: "optional float f = 1 [default = 1234567.125];", want: "1234567.12"},
+		{field: "optional float f = 1 [default = 0.1];", want: "0.1"},
+		{field: "optional float f = 1 [default = -.00001];", want: "-1e-05"},
+		{field: "optional double f = 1 [default = -0.0];", want: "-0"},
+		{field: "optional float f = 1 [default = 1e39];",
+			err: "test.proto:8:35: field f: default value 1e+39 is out of range for float"},
+		{field: "optional double f = 1 [default = 1e400];",
+			err: "test.proto:8:36: field f: default value 1e+400 is out of range for double"},
+		{field: "optional float f = 1 [default = -inf];", want: "-inf"},
+		{field: "optional double f = 1 [default = +infinity];", want: "inf"},
+		{field: "optional double f = 1 [default = inf];", want: "inf"},
+		{field: "optional float f = 1 [default = nan];", want: "nan"},
+		{field: "optional double f = 1 [default = -nan];", want: "nan"},
+		{field: "optional double f = 1 [default = infinity];",
+			err: "test.proto:8:36: field f: default value infinity must be a number"},
+		{field: "optional bool f = 1 [default = false];", want: "false"},
+		{field: "optional bool f = 1 [default = 1];",
+			err: "test.proto:8:34: field f: default value 1 must be true or false"},
+		{field: `optional string f = 1 [default = "\303\251\né"];`, want: "é\né"},
+		{field: `optional string f = 1 [default = E];`,
+			err: "test.proto:8:36: field f: default value E must be a string"},
+		{field: `optional bytes f = 1 [default = "\0\x01'\"\\\xff a"];`, want: `\000\001\'\"\\\377 a`},
+		{field: "optional E f = 1 [default = B];", want: "B"},
+		{field: "optional E f = 1 [default = C];",
+			err: "test.proto:8:31: field f: enum test.E has no value named C"},
+		{field: "optional E f = 1 [default = 1];",
+			err: "test.proto:8:31: field f: default value 1 of an enum field must be the name of an enum value"},
+		{field: "repeated int32 f = 1 [default = 1];",
+			err: "test.proto:8:35: field f: repeated fields cannot have default values"},
+		{field: "optional M f = 1 [default = 1];",
+			err: "test.proto:8:31: field f: message fields cannot have default values"},
+		{field: "int32 f = 1 [default = 1];", syntax: "proto3",
+			err: "test.proto:8:26: field f: default values are not allowed in proto3"},
+	}
+	for _, test := range tests {
+		t.Run(test.field, func(t *testing.T) {
+			syntax := test.syntax
+			if syntax == "" {
+				syntax = "proto2"
+			}
+			dir := t.TempDir()
+			source := "syntax = \"" + syntax + "\";\npackage test;\nenum E {\n  A = 0;\n  B = 1;\n}\nmessage M {\n  " + test.field + "\n}\n"
+			err := os.WriteFile(filepath.Join(dir, "test.proto"), []byte(source), 0o600)
+			require.NoError(t, err)
+			fds, err := Compile([]string{"test.proto"}, []string{dir}, false)
+			if test.err != "" {
+				require.EqualError(t, err, test.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, test.want, fds.File[0].MessageType[0].Field[0].GetDefaultValue())
+		})
+	}
+}
+
+// FuzzDefaultValues checks that every default that formatDefault accepts
+// is accepted by the Go protobuf runtime and formats to itself, starting
+// from the defaults of the protoc-generated conformance fixtures.
+func FuzzDefaultValues(f *testing.F) {
+	files, err := filepath.Glob("../testdata/conformance/pb/*.pb")
+	require.NoError(f, err)
+	for _, file := range files {
+		b, err := os.ReadFile(file)
+		require.NoError(f, err)
+		fds := &pb.FileDescriptorSet{}
+		require.NoError(f, proto.Unmarshal(b, fds))
+		for _, fd := range fds.File {
+			fields := appendFields(fd.Extension, fd.MessageType)
+			for _, field := range fields {
+				if field.DefaultValue == nil || field.GetType() == pb.FieldDescriptorProto_TYPE_ENUM {
+					continue
+				}
+				lit := defaultLiteral(field.GetType(), field.GetDefaultValue())
+				// The fixtures are formatted as protoc formats them.
+				got, err := formatLiteral(field.GetType(), lit)
+				require.NoError(f, err)
+				require.Equal(f, field.GetDefaultValue(), got, "%s: %s", fd.GetName(), field.GetName())
+				f.Add(int32(field.GetType()), lit)
+			}
+		}
+	}
+	// The fixtures have no defaults from 1e6, from which protoc formats
+	// doubles without an exponent unlike the shortest %g.
+	for _, lit := range []string{"1e6", "1234567.125", "123456789012", "1e15", "0.30000000000000004", "-3.4028234663852886e38"} {
+		f.Add(int32(pb.FieldDescriptorProto_TYPE_DOUBLE), lit)
+		f.Add(int32(pb.FieldDescriptorProto_TYPE_FLOAT), lit)
+	}
+	f.Fuzz(func(t *testing.T, typ int32, lit string) {
+		fType := pb.FieldDescriptorProto_Type(typ)
+		if _, ok := pb.FieldDescriptorProto_Type_name[typ]; !ok || fType == pb.FieldDescriptorProto_TYPE_ENUM ||
+			fType == pb.FieldDescriptorProto_TYPE_MESSAGE || fType == pb.FieldDescriptorProto_TYPE_GROUP {
+			t.Skip()
+		}
+		dv, err := formatLiteral(fType, lit)
+		if err != nil {
+			t.Skip()
+		}
+		again, err := formatLiteral(fType, defaultLiteral(fType, dv))
+		require.NoError(t, err)
+		require.Equal(t, dv, again)
+		fdp := &pb.FileDescriptorProto{
+			Name:   proto.String("test.proto"),
+			Syntax: proto.String("proto2"),
+			MessageType: []*pb.DescriptorProto{{
+				Name: proto.String("M"),
+				Field: []*pb.FieldDescriptorProto{{
+					Name:         proto.String("f"),
+					Number:       proto.Int32(1),
+					Label:        pb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+					Type:         fType.Enum(),
+					DefaultValue: &dv,
+				}},
+			}},
+		}
+		_, err = protodesc.NewFile(fdp, nil)
+		require.NoError(t, err)
+	})
+}
+
+// defaultLiteral returns the literal of a default formatted by protoc.
+func defaultLiteral(fType pb.FieldDescriptorProto_Type, dv string) string {
+	switch fType { //nolint:exhaustive
+	case pb.FieldDescriptorProto_TYPE_STRING:
+		return `"` + cEscape(dv) + `"`
+	case pb.FieldDescriptorProto_TYPE_BYTES:
+		return `"` + dv + `"`
+	}
+	return dv
+}
+
+// formatLiteral formats lit as the default of an optional field of the
+// given type.
+func formatLiteral(fType pb.FieldDescriptorProto_Type, lit string) (string, error) {
+	if strings.ContainsAny(lit, "\n;]") {
+		return "", os.ErrInvalid
+	}
+	p, err := parser.ParseString("test.proto", "syntax = \"proto2\";\noption (o) = "+lit+";\n")
+	if err != nil {
+		return "", err
+	}
+	var val *parser.Value
+	_ = parser.Visit(p, func(node parser.Node, next func() error) error {
+		if o, ok := node.(*parser.Option); ok && val == nil {
+			val = o.Value
+		}
+		return next()
+	})
+	if val == nil {
+		return "", os.ErrInvalid
+	}
+	fd := &pb.FieldDescriptorProto{Label: pb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(), Type: fType.Enum()}
+	return formatDefault(val, fd, nil)
+}
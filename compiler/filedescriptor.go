@@ -172,38 +172,53 @@ func newUninterpretedOption(o *parser.Option, scope []string, types *types) *pb.
 			name = strings.TrimPrefix(name, "(")
 			name = strings.TrimSuffix(name, ")")
 			name = types.extensionName(name, scope)
+			types.references[optName] = name
 			isExtension = true
 		}
 		o := &pb.UninterpretedOption_NamePart{NamePart: &name, IsExtension: &isExtension}
 		opt.Name = append(opt.Name, o)
 	}
+	types.options[opt] = o
+	setUninterpretedValue(opt, o.Value)
+	return opt
+}
+
+// setUninterpretedValue sets the value of an UninterpretedOption. A list
+// value cannot be represented, so it is left unset and its elements are
+// set individually when the option is resolved.
+func setUninterpretedValue(opt *pb.UninterpretedOption, v *parser.Value) {
 	switch {
-	case o.Value.String != nil:
-		opt.StringValue = []byte(*o.Value.String)
-	case o.Value.Number != nil && o.Value.Number.IsInt():
-		if v, accuracy := o.Value.Number.Uint64(); accuracy == big.Exact {
-			opt.PositiveIntValue = &v
-		} else if v, accuracy := o.Value.Number.Int64(); accuracy == big.Exact {
-			opt.NegativeIntValue = &v
+	case v.String != nil:
+		opt.StringValue = []byte(*v.String)
+	case v.Number != nil && v.Number.IsInt() && !v.Float:
+		if n, accuracy := v.Number.Uint64(); accuracy == big.Exact {
+			opt.PositiveIntValue = &n
+		} else if n, accuracy := v.Number.Int64(); accuracy == big.Exact {
+			opt.NegativeIntValue = &n
 		} else {
-			panic(fmt.Sprintf("value to large for (u)int64: %v", *o.Value.Number))
+			// The value is out of range for every integer type, which
+			// is reported with its literal when it is resolved.
+			f, _ := v.Number.Float64()
+			opt.DoubleValue = &f
 		}
-	case o.Value.Number != nil && !o.Value.Number.IsInt():
-		v, _ := o.Value.Number.Float64()
-		opt.DoubleValue = &v
-	case o.Value.Bool != nil:
-		v := strconv.FormatBool(bool(*o.Value.Bool))
-		opt.IdentifierValue = &v
-	case o.Value.Reference != nil:
-		opt.IdentifierValue = o.Value.Reference
-	case o.Value.ProtoText != nil:
-		v := o.Value.ProtoText.String()
-		opt.AggregateValue = &v
+	case v.Number != nil:
+		f, _ := v.Number.Float64()
+		opt.DoubleValue = &f
+	case v.NaN != nil:
+		f := math.NaN()
+		opt.DoubleValue = &f
+	case v.Bool != nil:
+		b := strconv.FormatBool(bool(*v.Bool))
+		opt.IdentifierValue = &b
+	case v.Reference != nil:
+		opt.IdentifierValue = v.Reference
+	case v.ProtoText != nil:
+		text := v.ProtoText.String()
+		opt.AggregateValue = &text
+	case v.Array != nil:
 	default:
-		// This includes o.Value.Array which does not appear to be valid.
-		panic(fmt.Sprintf("Unknown option value form: %#v", o.Value))
+		panic(fmt.Sprintf("Unknown option value form: %#v", v))
 	}
-	return opt
 }
 
 func (b *messageBuilder) buildOneof(po *parser.OneOf, md *pb.DescriptorProto) {
@@ -267,10 +282,10 @@ func newExtensionRanges(er *parser.Extensions, scope []string, types *types) []*
 }
 
 func reservedRange(r *parser.Range) (start int32, end int32) {
-	start = int32(r.Start)
-	end = int32(r.Start) + 1
+	start = int32(r.Start.Int64())
+	end = start + 1
 	if r.End != nil {
-		end = int32(*r.End) + 1
+		end = int32(r.End.Int64()) + 1
 	}
 	if r.Max {
 		end = maxReserved
@@ -357,18 +372,24 @@ func fieldName(f *parser.Field) string {
 		return f.Direct.Name
 	}
 	if f.Group != nil {
-		return strings.ToLower(f.Group.Name)
+		return groupFieldName(f.Group)
 	}
 	panic(fmt.Sprintf("%s: fieldName: no direct or group", f.Pos))
 }
 
+// groupFieldName returns the name of the field of a group, which is the
+// lowercased name of the group.
+func groupFieldName(g *parser.Group) string {
+	return strings.ToLower(g.Name)
+}
+
 func fieldTag(f *parser.Field) *int32 {
 	var tag int32
 	switch {
 	case f.Direct != nil:
-		tag = int32(f.Direct.Tag)
+		tag = int32(f.Direct.Tag.Int64())
 	case f.Group != nil:
-		tag = int32(f.Group.Tag)
+		tag = int32(f.Group.Tag.Int64())
 	default:
 		panic(fmt.Sprintf("%s: fieldTag: no direct or group", f.Pos))
 	}
@@ -381,15 +402,12 @@ func fieldType(f *parser.Field, scope []string, types *types) (pb.FieldDescripto
 		name, pbType := types.fullName(mapTypeStr(f.Direct.Name), scope)
 		return pbType, &name
 	case f.Direct != nil:
-		fType, name := newFieldDescriptorProtoType(f.Direct.Type, scope, types)
-		if fType == pb.FieldDescriptorProto_TYPE_GROUP {
-			// references to groups are stored as Messages 🤷‍♀️
-			fType = pb.FieldDescriptorProto_TYPE_MESSAGE
-		}
-		return fType, name
+		return newFieldDescriptorProtoType(f.Direct.Type, scope, types)
 	case f.Group != nil:
-		name, pbType := types.fullName(f.Group.Name, scope)
-		return pbType, &name
+		// The group is declared in the scope of its field, so its name
+		// is not resolved: an outer declaration cannot shadow it.
+		name := scopedName(f.Group.Name, scope)
+		return pb.FieldDescriptorProto_TYPE_GROUP, &name
 	default:
 		panic(fmt.Sprintf("%s: fieldType: no direct or group", f.Pos))
 	}
@@ -401,6 +419,13 @@ func newFieldDescriptorProtoType(t *parser.Type, scope []string, types *types) (
 	}
 	if t.Reference != nil {
 		name, pbType := types.fullName(*t.Reference, scope)
+		types.references[t] = name
+		if pbType == pb.FieldDescriptorProto_TYPE_GROUP {
+			// Only the field declaring a group has the group type,
+			// other references to it, including map values, are
+			// messages.
+			pbType = pb.FieldDescriptorProto_TYPE_MESSAGE
+		}
 		return pbType, &name
 	}
 	panic("unimplemented type, probably map")
@@ -423,7 +448,9 @@ func newFieldOptions(field *parser.Field, fd *pb.FieldDescriptorProto, scope []s
 		// other options - DefaultValue is a field in the FieldDescriptor
 		switch {
 		case len(o.Name) == 1 && o.Name[0].Name == "default":
-			fd.DefaultValue = formatDefault(o.Value, *fd.Type)
+			// The default is formatted by resolveDefaults once the
+			// enums of all files are known.
+			types.defaults[fd] = o
 		case len(o.Name) == 1 && o.Name[0].Name == "json_name" && o.Value.String != nil:
 			fd.JsonName = o.Value.String
 		default:
@@ -438,64 +465,6 @@ func newFieldOptions(field *parser.Field, fd *pb.FieldDescriptorProto, scope []s
 	return opts
 }
 
-// formatDefault formats a value as a string suitable for the field type.
-// descriptor.proto describes some of this, but compared to what protoc
-// does, some of that description is wrong. Most of the formatting
-// chosen here was to make the conformance tests pass. Ideally, protoc
-// would be "reverse-engineered" to spec out exactly how default values
-// should be formatted.
-func formatDefault(val *parser.Value, fType pb.FieldDescriptorProto_Type) *string {
-	switch fType { //nolint:exhaustive // default case is after switch
-	case pb.FieldDescriptorProto_TYPE_INT64, pb.FieldDescriptorProto_TYPE_INT32,
-		pb.FieldDescriptorProto_TYPE_UINT64, pb.FieldDescriptorProto_TYPE_UINT32,
-		pb.FieldDescriptorProto_TYPE_FIXED64, pb.FieldDescriptorProto_TYPE_FIXED32,
-		pb.FieldDescriptorProto_TYPE_SFIXED64, pb.FieldDescriptorProto_TYPE_SFIXED32,
-		pb.FieldDescriptorProto_TYPE_SINT64, pb.FieldDescriptorProto_TYPE_SINT32:
-		if val.Number == nil || !val.Number.IsInt() {
-			break
-		}
-		dv := val.Number.Text('g', 64)
-		return &dv
-	case pb.FieldDescriptorProto_TYPE_FLOAT, pb.FieldDescriptorProto_TYPE_DOUBLE:
-		if val.Number == nil {
-			break
-		}
-		// Not sure if this is right, but it works!
-		dv := strings.ToLower(fmt.Sprintf("%v", val.Number))
-		return &dv
-	case pb.FieldDescriptorProto_TYPE_STRING:
-		if val.String == nil {
-			break
-		}
-		return val.String
-	case pb.FieldDescriptorProto_TYPE_BYTES:
-		if val.String == nil {
-			break
-		}
-		escapedStr := ""
-		for _, b := range []byte(*val.String) {
-			switch {
-			case b == '\n':
-				escapedStr += `\n`
-			case b == '\r':
-				escapedStr += `\r`
-			case b == '\t':
-				escapedStr += `\t`
-			case b == '"' || b == '\'' || b == '\\':
-				escapedStr += `\`
-				fallthrough
-			case b >= 0x20 && b <= 0x7E: // printable
-				escapedStr += string(b)
-			default:
-				escapedStr += fmt.Sprintf(`\%03o`, uint(b))
-			}
-		}
-		return &escapedStr
-	}
-	dv := val.ToString()
-	return &dv
-}
-
 func newMapEntry(f *parser.Field, scope []string, types *types) *pb.DescriptorProto {
 	keyField := MapEntryField("key", 1, f.Direct.Type.Map.Key, scope, types)
 	valueField := MapEntryField("value", 2, f.Direct.Type.Map.Value, scope, types)
@@ -675,7 +644,7 @@ func buildEnumOption(ed *pb.EnumDescriptorProto, o *parser.Option, scope []strin
 }
 
 func newEnumValue(e *parser.EnumValue, scope []string, types *types) *pb.EnumValueDescriptorProto {
-	val := int32(e.Value)
+	val := int32(e.Value.Int64())
 	ed := &pb.EnumValueDescriptorProto{
 		Name:   &e.Key,
 		Number: &val,
@@ -693,10 +662,10 @@ func newEnumValue(e *parser.EnumValue, scope []string, types *types) *pb.EnumVal
 func newEnumRanges(pr *parser.Reserved) []*pb.EnumDescriptorProto_EnumReservedRange {
 	reservedRanges := make([]*pb.EnumDescriptorProto_EnumReservedRange, 0, len(pr.Ranges))
 	for _, r := range pr.Ranges {
-		start := int32(r.Start)
-		end := int32(r.Start)
+		start := int32(r.Start.Int64())
+		end := start
 		if r.End != nil {
-			end = int32(*r.End)
+			end = int32(r.End.Int64())
 		}
 		if r.Max {
 			end = math.MaxInt32
@@ -709,6 +678,7 @@ func newEnumRanges(pr *parser.Reserved) []*pb.EnumDescriptorProto_EnumReservedRa
 
 func newExtend(e *parser.Extend, proto3 bool, scope []string, types *types) (fields []*pb.FieldDescriptorProto, groups []*pb.DescriptorProto) {
 	extendee, _ := types.fullName(e.Reference, scope)
+	types.references[e] = extendee
 	fdBuilder := fieldBuilder{proto3: proto3, scope: scope, types: types, extendee: &extendee}
 	fds := make([]*pb.FieldDescriptorProto, len(e.Fields))
 	var groupDescs []*pb.DescriptorProto
@@ -0,0 +1,68 @@
+package compiler
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/alecthomas/participle/v2/lexer"
+
+	"github.com/alecthomas/protobuf/parser"
+)
+
+// linkedName matches the names in the errors of protodesc, such as
+// `proto: descriptor "pkg.M" already declared` or
+// `proto: file "a.proto" has a name conflict over pkg.M`.
+var linkedName = regexp.MustCompile(`"([\w./-]+)"|over ([\w.]+)`)
+
+// linkError prefixes an error of protodesc linking the files of asts with
+// the position of the first declaration it names, or else of the file it
+// names. Other errors are returned as they are. Later files are searched
+// first, as a name declared twice is reported for the second declaration.
+func linkError(err error, asts []*ast) error {
+	var names []string
+	for _, m := range linkedName.FindAllStringSubmatch(err.Error(), -1) {
+		names = append(names, m[1]+m[2])
+	}
+	decls := make([]declarations, len(asts))
+	for i, a := range asts {
+		decls[i] = newDeclarations(a)
+	}
+	for _, name := range names {
+		for i := len(asts) - 1; i >= 0; i-- {
+			if pos, ok := declarationPos(decls[i][name]); ok {
+				return fmt.Errorf("%s: %w", pos, err)
+			}
+		}
+	}
+	for _, name := range names {
+		for _, a := range asts {
+			if name == a.file {
+				return fmt.Errorf("%s: %w", a.proto.Pos, err)
+			}
+		}
+	}
+	return err
+}
+
+// declarationPos returns the position of a node of declarations.
+func declarationPos(node parser.Node) (lexer.Position, bool) {
+	switch n := node.(type) {
+	case *parser.Message:
+		return n.Pos, true
+	case *parser.Group:
+		return n.Pos, true
+	case *parser.Field:
+		return n.Pos, true
+	case *parser.OneOf:
+		return n.Pos, true
+	case *parser.Enum:
+		return n.Pos, true
+	case *parser.EnumValue:
+		return n.Pos, true
+	case *parser.Service:
+		return n.Pos, true
+	case *parser.Method:
+		return n.Pos, true
+	}
+	return lexer.Position{}, false
+}
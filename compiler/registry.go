@@ -1,8 +1,13 @@
 package compiler
 
 import (
+	"errors"
+	"fmt"
+	"sort"
 	"strings"
+	"sync"
 
+	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/reflect/protodesc"
 	"google.golang.org/protobuf/reflect/protoreflect"
 	"google.golang.org/protobuf/reflect/protoregistry"
@@ -10,97 +15,224 @@ import (
 	"google.golang.org/protobuf/types/dynamicpb"
 )
 
+// Registry of linked file descriptors that resolves their messages, enums
+// and extensions as types. By default, types registered in
+// protoregistry.GlobalTypes, such as those of generated code, take
+// precedence; see TypePrecedence. Other types are dynamicpb types,
+// created once per declaration.
 type Registry struct {
 	protoregistry.Files
+
+	precedence      Precedence
+	detectConflicts bool
+
+	// mu guards the indexes of extensions, which are built from Files
+	// when first needed by a Registry that was not created with
+	// NewRegistry.
+	mu sync.Mutex
+	// extensions are the extension descriptors of the files by the full
+	// name of the extended message, in declaration order.
+	extensions map[protoreflect.FullName][]protoreflect.ExtensionDescriptor
+	// extensionNumbers indexes extensions by extended message and number.
+	extensionNumbers map[extensionKey]protoreflect.ExtensionDescriptor
+	// types caches the dynamic types of the registry by full name.
+	types sync.Map
+}
+
+type extensionKey struct {
+	message protoreflect.FullName
+	number  protoreflect.FieldNumber
 }
 
-func NewRegistry(fds *descriptorpb.FileDescriptorSet) (*Registry, error) {
+// Precedence of the types of a Registry over the types registered in
+// protoregistry.GlobalTypes.
+type Precedence int
+
+const (
+	// GlobalFirst resolves types from protoregistry.GlobalTypes, falling
+	// back to the files of the registry.
+	GlobalFirst Precedence = iota
+	// LocalFirst resolves types from the files of the registry, falling
+	// back to protoregistry.GlobalTypes.
+	LocalFirst
+	// LocalOnly resolves types only from the files of the registry.
+	LocalOnly
+)
+
+// RegistryOption configures the behaviour of a Registry.
+type RegistryOption func(*Registry)
+
+// TypePrecedence sets whether the registry resolves types from its files
+// or from protoregistry.GlobalTypes first. The default is GlobalFirst.
+func TypePrecedence(p Precedence) RegistryOption {
+	return func(r *Registry) { r.precedence = p }
+}
+
+// DetectConflicts makes the registry return a *ConflictError for a type
+// declared both in its files and in protoregistry.GlobalTypes with
+// different fields, enum values or extendee, rather than resolving the
+// type by precedence. Options are not compared.
+func DetectConflicts() RegistryOption {
+	return func(r *Registry) { r.detectConflicts = true }
+}
+
+// ConflictError is returned by a Registry with DetectConflicts for a type
+// declared differently in its files and in protoregistry.GlobalTypes.
+type ConflictError struct {
+	Name protoreflect.FullName
+	// Local is the file of the registry declaring Name.
+	Local string
+	// Global is the file declaring the registered type of Name.
+	Global string
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("%s declared in %s differs from the registered type declared in %s", e.Name, e.Local, e.Global)
+}
+
+func NewRegistry(fds *descriptorpb.FileDescriptorSet, options ...RegistryOption) (*Registry, error) {
 	f, err := protodesc.NewFiles(fds)
 	if err != nil {
 		return nil, err
 	}
-	return &Registry{Files: *f}, nil
+	return registryOf(f, options...)
 }
 
-// FindExtensionByName implements protoregistry.ExtensionTypeResolver.
-func (f *Registry) FindExtensionByName(field protoreflect.FullName) (protoreflect.ExtensionType, error) {
-	if desc, err := protoregistry.GlobalTypes.FindExtensionByName(field); err == nil {
-		return desc, nil
+// registryOf returns a registry of the files of f with their extensions
+// indexed.
+func registryOf(f *protoregistry.Files, options ...RegistryOption) (*Registry, error) {
+	r := &Registry{}
+	for _, option := range options {
+		option(r)
 	}
-	desc, err := f.FindDescriptorByName(field)
+	var err error
+	f.RangeFiles(func(fd protoreflect.FileDescriptor) bool {
+		err = r.RegisterFile(fd)
+		return err == nil
+	})
 	if err != nil {
 		return nil, err
 	}
-	ed, ok := desc.(protoreflect.ExtensionDescriptor)
-	if !ok {
-		return nil, protoregistry.NotFound
+	return r, nil
+}
+
+// RegisterFile registers a file like protoregistry.Files.RegisterFile and
+// indexes its extensions.
+func (f *Registry) RegisterFile(fd protoreflect.FileDescriptor) error {
+	if err := f.Files.RegisterFile(fd); err != nil {
+		return err
 	}
-	return dynamicpb.NewExtensionType(ed), nil
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.extensions == nil {
+		// The files registered directly in Files are indexed with fd.
+		f.indexFiles()
+	} else {
+		f.indexExtensions(fd)
+	}
+	return nil
 }
 
-// FindExtensionByNumber implements protoregistry.ExtensionTypeResolver.
-func (f *Registry) FindExtensionByNumber(message protoreflect.FullName, field protoreflect.FieldNumber) (protoreflect.ExtensionType, error) {
-	if desc, err := protoregistry.GlobalTypes.FindExtensionByNumber(message, field); err == nil {
-		return desc, nil
-	}
-	// FileDescriptor and MessageDescriptor implement this interface.
-	type extensionContainer interface {
-		Messages() protoreflect.MessageDescriptors
-		Extensions() protoreflect.ExtensionDescriptors
-	}
-
-	var getExtension func(extensionContainer) protoreflect.ExtensionType
-	getExtension = func(ec extensionContainer) protoreflect.ExtensionType {
-		eds := ec.Extensions()
-		for i := 0; i < eds.Len(); i++ {
-			ed := eds.Get(i)
-			if ed.ContainingMessage().FullName() == message && ed.Number() == field {
-				return dynamicpb.NewExtensionType(ed)
-			}
-		}
+// extensionIndex returns the indexes of the extensions of the files,
+// building them if files were registered directly in Files.
+func (f *Registry) extensionIndex() (map[protoreflect.FullName][]protoreflect.ExtensionDescriptor, map[extensionKey]protoreflect.ExtensionDescriptor) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.extensions == nil {
+		f.indexFiles()
+	}
+	return f.extensions, f.extensionNumbers
+}
 
-		mds := ec.Messages()
-		for i := 0; i < mds.Len(); i++ {
-			if et := getExtension(mds.Get(i)); et != nil {
-				return et
-			}
+// indexFiles indexes the extensions of all the files of f.
+func (f *Registry) indexFiles() {
+	f.extensions = map[protoreflect.FullName][]protoreflect.ExtensionDescriptor{}
+	f.extensionNumbers = map[extensionKey]protoreflect.ExtensionDescriptor{}
+	f.RangeFiles(func(fd protoreflect.FileDescriptor) bool {
+		f.indexExtensions(fd)
+		return true
+	})
+}
+
+// indexExtensions adds the extensions declared in fd to the indexes. The
+// first extension of a message and number is kept.
+func (f *Registry) indexExtensions(fd protoreflect.FileDescriptor) {
+	rangeDeclarations(fd, func(desc protoreflect.Descriptor) bool {
+		xd, ok := desc.(protoreflect.ExtensionDescriptor)
+		if !ok {
+			return true
 		}
+		key := extensionKey{message: xd.ContainingMessage().FullName(), number: xd.Number()}
+		if _, ok := f.extensionNumbers[key]; !ok {
+			f.extensionNumbers[key] = xd
+		}
+		f.extensions[key.message] = append(f.extensions[key.message], xd)
+		return true
+	})
+}
 
-		return nil
+// FindExtensionByName implements protoregistry.ExtensionTypeResolver.
+func (f *Registry) FindExtensionByName(field protoreflect.FullName) (protoreflect.ExtensionType, error) {
+	t, err := f.resolve(func() (interface{}, error) {
+		return protoregistry.GlobalTypes.FindExtensionByName(field)
+	}, func() (interface{}, error) {
+		desc, err := f.FindDescriptorByName(field)
+		if err != nil {
+			return nil, err
+		}
+		xd, ok := desc.(protoreflect.ExtensionDescriptor)
+		if !ok {
+			return nil, protoregistry.NotFound
+		}
+		return f.extensionType(xd), nil
+	})
+	if err != nil {
+		return nil, err
 	}
+	return t.(protoreflect.ExtensionType), nil
+}
 
-	var et protoreflect.ExtensionType
-	f.RangeFiles(func(fd protoreflect.FileDescriptor) bool {
-		et = getExtension(fd)
-		return et == nil
+// FindExtensionByNumber implements protoregistry.ExtensionTypeResolver.
+func (f *Registry) FindExtensionByNumber(message protoreflect.FullName, field protoreflect.FieldNumber) (protoreflect.ExtensionType, error) {
+	t, err := f.resolve(func() (interface{}, error) {
+		return protoregistry.GlobalTypes.FindExtensionByNumber(message, field)
+	}, func() (interface{}, error) {
+		_, numbers := f.extensionIndex()
+		xd, ok := numbers[extensionKey{message: message, number: field}]
+		if !ok {
+			return nil, protoregistry.NotFound
+		}
+		return f.extensionType(xd), nil
 	})
-	if et == nil {
-		return nil, protoregistry.NotFound
+	if err != nil {
+		return nil, err
 	}
-	return et, nil
+	return t.(protoreflect.ExtensionType), nil
 }
 
 // FindMessageByName implements protoregistry.MessageTypeResolver.
 func (f *Registry) FindMessageByName(name protoreflect.FullName) (protoreflect.MessageType, error) {
-	if desc, err := protoregistry.GlobalTypes.FindMessageByName(name); err == nil {
-		return desc, nil
-	}
-	desc, err := f.FindDescriptorByName(name)
+	t, err := f.resolve(func() (interface{}, error) {
+		return protoregistry.GlobalTypes.FindMessageByName(name)
+	}, func() (interface{}, error) {
+		desc, err := f.FindDescriptorByName(name)
+		if err != nil {
+			return nil, err
+		}
+		md, ok := desc.(protoreflect.MessageDescriptor)
+		if !ok {
+			return nil, protoregistry.NotFound
+		}
+		return f.messageType(md), nil
+	})
 	if err != nil {
 		return nil, err
 	}
-	md, ok := desc.(protoreflect.MessageDescriptor)
-	if !ok {
-		return nil, protoregistry.NotFound
-	}
-	return dynamicpb.NewMessageType(md), nil
+	return t.(protoreflect.MessageType), nil
 }
 
 // FindMessageByURL implements protoregistry.MessageTypeResolver.
 func (f *Registry) FindMessageByURL(url string) (protoreflect.MessageType, error) {
-	if desc, err := protoregistry.GlobalTypes.FindMessageByURL(url); err == nil {
-		return desc, nil
-	}
 	message := protoreflect.FullName(url)
 	// Strip off before the last slash - we only look locally for the
 	// message and do not hit the network. The part after the last slash
@@ -110,3 +242,310 @@ func (f *Registry) FindMessageByURL(url string) (protoreflect.MessageType, error
 	}
 	return f.FindMessageByName(message)
 }
+
+// FindEnumByName returns the enum type with the given full name, like
+// protoregistry.Types.
+func (f *Registry) FindEnumByName(name protoreflect.FullName) (protoreflect.EnumType, error) {
+	t, err := f.resolve(func() (interface{}, error) {
+		return protoregistry.GlobalTypes.FindEnumByName(name)
+	}, func() (interface{}, error) {
+		desc, err := f.FindDescriptorByName(name)
+		if err != nil {
+			return nil, err
+		}
+		ed, ok := desc.(protoreflect.EnumDescriptor)
+		if !ok {
+			return nil, protoregistry.NotFound
+		}
+		return f.enumType(ed), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return t.(protoreflect.EnumType), nil
+}
+
+// resolve returns the type found by global, from
+// protoregistry.GlobalTypes, or by local, from the files of the registry,
+// in the precedence of the registry. With DetectConflicts, both are looked
+// up and a *ConflictError is returned if their declarations differ.
+func (f *Registry) resolve(global, local func() (interface{}, error)) (interface{}, error) {
+	first, second := global, local
+	switch f.precedence {
+	case LocalFirst:
+		first, second = local, global
+	case LocalOnly:
+		first, second = local, nil
+	}
+	t, err := first()
+	if err != nil {
+		if second == nil || !errors.Is(err, protoregistry.NotFound) {
+			return nil, err
+		}
+		return second()
+	}
+	if !f.detectConflicts || second == nil {
+		return t, nil
+	}
+	other, err := second()
+	if err != nil {
+		return t, nil //nolint:nilerr
+	}
+	g, l := typeDescriptor(t), typeDescriptor(other)
+	if f.precedence == LocalFirst {
+		g, l = l, g
+	}
+	if !sameShape(g, l) {
+		return nil, &ConflictError{Name: l.FullName(), Local: l.ParentFile().Path(), Global: g.ParentFile().Path()}
+	}
+	return t, nil
+}
+
+// typeDescriptor returns the descriptor of a message, enum or extension
+// type.
+func typeDescriptor(t interface{}) protoreflect.Descriptor {
+	switch t := t.(type) {
+	case protoreflect.MessageType:
+		return t.Descriptor()
+	case protoreflect.EnumType:
+		return t.Descriptor()
+	case protoreflect.ExtensionType:
+		return t.TypeDescriptor()
+	}
+	panic(fmt.Sprintf("typeDescriptor: unexpected type %T", t))
+}
+
+// sameShape reports whether two message, enum or extension descriptors
+// are declared alike, ignoring their options and the order of their
+// declarations.
+func sameShape(a, b protoreflect.Descriptor) bool {
+	if a == b {
+		return true
+	}
+	return proto.Equal(shapeOf(a), shapeOf(b))
+}
+
+// shapeOf returns the descriptor proto of desc without options and with
+// its declarations sorted.
+func shapeOf(desc protoreflect.Descriptor) proto.Message {
+	switch d := desc.(type) {
+	case protoreflect.MessageDescriptor:
+		md := protodesc.ToDescriptorProto(d)
+		normalizeMessage(md)
+		return md
+	case protoreflect.EnumDescriptor:
+		ed := protodesc.ToEnumDescriptorProto(d)
+		normalizeEnum(ed)
+		return ed
+	case protoreflect.FieldDescriptor:
+		fd := protodesc.ToFieldDescriptorProto(d)
+		fd.Options = nil
+		return fd
+	}
+	panic(fmt.Sprintf("shapeOf: unexpected descriptor %T", desc))
+}
+
+func normalizeMessage(md *descriptorpb.DescriptorProto) {
+	md.Options = nil
+	fields := [][]*descriptorpb.FieldDescriptorProto{md.Field, md.Extension}
+	for _, fds := range fields {
+		for _, fd := range fds {
+			fd.Options = nil
+		}
+		sort.Slice(fds, func(i, j int) bool {
+			if fds[i].GetExtendee() != fds[j].GetExtendee() {
+				return fds[i].GetExtendee() < fds[j].GetExtendee()
+			}
+			return fds[i].GetNumber() < fds[j].GetNumber()
+		})
+	}
+	for _, od := range md.OneofDecl {
+		od.Options = nil
+	}
+	for _, er := range md.ExtensionRange {
+		er.Options = nil
+	}
+	sort.Slice(md.NestedType, func(i, j int) bool { return md.NestedType[i].GetName() < md.NestedType[j].GetName() })
+	for _, nested := range md.NestedType {
+		normalizeMessage(nested)
+	}
+	sort.Slice(md.EnumType, func(i, j int) bool { return md.EnumType[i].GetName() < md.EnumType[j].GetName() })
+	for _, ed := range md.EnumType {
+		normalizeEnum(ed)
+	}
+}
+
+func normalizeEnum(ed *descriptorpb.EnumDescriptorProto) {
+	ed.Options = nil
+	for _, v := range ed.Value {
+		v.Options = nil
+	}
+	sort.Slice(ed.Value, func(i, j int) bool {
+		if ed.Value[i].GetNumber() != ed.Value[j].GetNumber() {
+			return ed.Value[i].GetNumber() < ed.Value[j].GetNumber()
+		}
+		return ed.Value[i].GetName() < ed.Value[j].GetName()
+	})
+}
+
+// FindServiceByName returns the descriptor of the service with the given
+// full name.
+func (f *Registry) FindServiceByName(name protoreflect.FullName) (protoreflect.ServiceDescriptor, error) {
+	desc, err := f.FindDescriptorByName(name)
+	if err != nil {
+		return nil, err
+	}
+	sd, ok := desc.(protoreflect.ServiceDescriptor)
+	if !ok {
+		return nil, protoregistry.NotFound
+	}
+	return sd, nil
+}
+
+// RangeMessages calls fn for the type of every message declared in the
+// files of the registry, except map entries, until fn returns false.
+func (f *Registry) RangeMessages(fn func(protoreflect.MessageType) bool) {
+	f.rangeDescriptors(func(desc protoreflect.Descriptor) bool {
+		md, ok := desc.(protoreflect.MessageDescriptor)
+		if !ok || md.IsMapEntry() {
+			return true
+		}
+		mt, err := f.FindMessageByName(md.FullName())
+		return err != nil || fn(mt)
+	})
+}
+
+// RangeEnums calls fn for the type of every enum declared in the files of
+// the registry until fn returns false.
+func (f *Registry) RangeEnums(fn func(protoreflect.EnumType) bool) {
+	f.rangeDescriptors(func(desc protoreflect.Descriptor) bool {
+		ed, ok := desc.(protoreflect.EnumDescriptor)
+		if !ok {
+			return true
+		}
+		et, err := f.FindEnumByName(ed.FullName())
+		return err != nil || fn(et)
+	})
+}
+
+// RangeExtensions calls fn for the type of every extension declared in
+// the files of the registry until fn returns false.
+func (f *Registry) RangeExtensions(fn func(protoreflect.ExtensionType) bool) {
+	f.rangeDescriptors(func(desc protoreflect.Descriptor) bool {
+		xd, ok := desc.(protoreflect.ExtensionDescriptor)
+		if !ok {
+			return true
+		}
+		xt, err := f.FindExtensionByName(xd.FullName())
+		return err != nil || fn(xt)
+	})
+}
+
+// RangeExtensionsByMessage calls fn for the type of every extension of
+// message declared in the files of the registry until fn returns false.
+func (f *Registry) RangeExtensionsByMessage(message protoreflect.FullName, fn func(protoreflect.ExtensionType) bool) {
+	extensions, _ := f.extensionIndex()
+	for _, xd := range extensions[message] {
+		xt, err := f.FindExtensionByName(xd.FullName())
+		if err == nil && !fn(xt) {
+			return
+		}
+	}
+}
+
+// NumMessages returns the number of messages that RangeMessages visits.
+func (f *Registry) NumMessages() int {
+	n := 0
+	f.RangeMessages(func(protoreflect.MessageType) bool { n++; return true })
+	return n
+}
+
+// NumEnums returns the number of enums that RangeEnums visits.
+func (f *Registry) NumEnums() int {
+	n := 0
+	f.RangeEnums(func(protoreflect.EnumType) bool { n++; return true })
+	return n
+}
+
+// NumExtensions returns the number of extensions that RangeExtensions
+// visits.
+func (f *Registry) NumExtensions() int {
+	n := 0
+	f.RangeExtensions(func(protoreflect.ExtensionType) bool { n++; return true })
+	return n
+}
+
+// NumExtensionsByMessage returns the number of extensions of message that
+// RangeExtensionsByMessage visits.
+func (f *Registry) NumExtensionsByMessage(message protoreflect.FullName) int {
+	n := 0
+	f.RangeExtensionsByMessage(message, func(protoreflect.ExtensionType) bool { n++; return true })
+	return n
+}
+
+// rangeDescriptors calls fn for every message, enum and extension
+// declared in the files of the registry, outer declarations before the
+// declarations nested in them, until fn returns false.
+func (f *Registry) rangeDescriptors(fn func(protoreflect.Descriptor) bool) {
+	f.RangeFiles(func(fd protoreflect.FileDescriptor) bool {
+		return rangeDeclarations(fd, fn)
+	})
+}
+
+// declarationContainer is implemented by FileDescriptor and
+// MessageDescriptor.
+type declarationContainer interface {
+	Messages() protoreflect.MessageDescriptors
+	Enums() protoreflect.EnumDescriptors
+	Extensions() protoreflect.ExtensionDescriptors
+}
+
+// rangeDeclarations calls fn for every message, enum and extension
+// declared in c, recursively, until fn returns false. It returns false if
+// fn did.
+func rangeDeclarations(c declarationContainer, fn func(protoreflect.Descriptor) bool) bool {
+	for i := 0; i < c.Enums().Len(); i++ {
+		if !fn(c.Enums().Get(i)) {
+			return false
+		}
+	}
+	for i := 0; i < c.Extensions().Len(); i++ {
+		if !fn(c.Extensions().Get(i)) {
+			return false
+		}
+	}
+	for i := 0; i < c.Messages().Len(); i++ {
+		md := c.Messages().Get(i)
+		if !fn(md) || !rangeDeclarations(md, fn) {
+			return false
+		}
+	}
+	return true
+}
+
+// messageType returns the cached dynamic type of md.
+func (f *Registry) messageType(md protoreflect.MessageDescriptor) protoreflect.MessageType {
+	if t, ok := f.types.Load(md.FullName()); ok {
+		return t.(protoreflect.MessageType)
+	}
+	t, _ := f.types.LoadOrStore(md.FullName(), dynamicpb.NewMessageType(md))
+	return t.(protoreflect.MessageType)
+}
+
+// enumType returns the cached dynamic type of ed.
+func (f *Registry) enumType(ed protoreflect.EnumDescriptor) protoreflect.EnumType {
+	if t, ok := f.types.Load(ed.FullName()); ok {
+		return t.(protoreflect.EnumType)
+	}
+	t, _ := f.types.LoadOrStore(ed.FullName(), dynamicpb.NewEnumType(ed))
+	return t.(protoreflect.EnumType)
+}
+
+// extensionType returns the cached dynamic type of xd.
+func (f *Registry) extensionType(xd protoreflect.ExtensionDescriptor) protoreflect.ExtensionType {
+	if t, ok := f.types.Load(xd.FullName()); ok {
+		return t.(protoreflect.ExtensionType)
+	}
+	t, _ := f.types.LoadOrStore(xd.FullName(), dynamicpb.NewExtensionType(xd))
+	return t.(protoreflect.ExtensionType)
+}
@@ -2,14 +2,17 @@ package compiler
 
 import (
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/require"
 	"google.golang.org/genproto/googleapis/api/annotations"
+	"google.golang.org/protobuf/encoding/protojson"
 	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/reflect/protoreflect"
 	"google.golang.org/protobuf/reflect/protoregistry"
 	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
 )
 
 // ensure Registry implments ExtensionTypeResolver
@@ -18,6 +21,21 @@ var _ protoregistry.ExtensionTypeResolver = (*Registry)(nil)
 // ensure Registry implments MessageTypeResolver
 var _ protoregistry.MessageTypeResolver = (*Registry)(nil)
 
+// ensure Registry has the methods of protoregistry.Types
+var _ interface {
+	protoregistry.ExtensionTypeResolver
+	protoregistry.MessageTypeResolver
+	FindEnumByName(protoreflect.FullName) (protoreflect.EnumType, error)
+	NumEnums() int
+	NumExtensions() int
+	NumExtensionsByMessage(protoreflect.FullName) int
+	NumMessages() int
+	RangeEnums(func(protoreflect.EnumType) bool)
+	RangeExtensions(func(protoreflect.ExtensionType) bool)
+	RangeExtensionsByMessage(protoreflect.FullName, func(protoreflect.ExtensionType) bool)
+	RangeMessages(func(protoreflect.MessageType) bool)
+} = (*Registry)(nil)
+
 func TestFindExtensionByName(t *testing.T) {
 	tests := map[string]struct {
 		extName string
@@ -142,6 +160,342 @@ func TestConcreteType(t *testing.T) {
 	require.True(t, ok, "unexpected extension type")
 }
 
+func TestTypesAreCached(t *testing.T) {
+	r := newRegistry(t)
+	mt, err := r.FindMessageByName("regtest.BaseMessage")
+	require.NoError(t, err)
+	again, err := r.FindMessageByURL("example.com/regtest.BaseMessage")
+	require.NoError(t, err)
+	require.True(t, mt == again, "message type was not cached")
+
+	et, err := r.FindExtensionByName("regtest.ExtensionMessage.ef2")
+	require.NoError(t, err)
+	byNumber, err := r.FindExtensionByNumber("regtest.BaseMessage", 1001)
+	require.NoError(t, err)
+	require.True(t, et == byNumber, "extension type was not cached")
+
+	// Types of extensions and messages refer to the same types.
+	m := mt.New()
+	m.Set(et.TypeDescriptor(), et.New())
+	b, err := proto.Marshal(m.Interface())
+	require.NoError(t, err)
+	m = mt.New()
+	require.NoError(t, proto.UnmarshalOptions{Resolver: r}.Unmarshal(b, m.Interface()))
+	require.True(t, m.Has(et.TypeDescriptor()))
+}
+
+func TestRegisterFile(t *testing.T) {
+	files := newRegistry(t)
+	r := &Registry{}
+	files.RangeFiles(func(fd protoreflect.FileDescriptor) bool {
+		require.NoError(t, r.RegisterFile(fd))
+		return true
+	})
+	et, err := r.FindExtensionByNumber("regtest.BaseMessage", 1002)
+	require.NoError(t, err)
+	require.Equal(t, protoreflect.FullName("regtest.ExtensionMessage.NestedExtension.ef3"), et.TypeDescriptor().FullName())
+	require.Equal(t, 3, r.NumExtensionsByMessage("regtest.BaseMessage"))
+}
+
+func TestRegistryOfFiles(t *testing.T) {
+	files := newRegistry(t)
+	r := &Registry{Files: files.Files}
+	et, err := r.FindExtensionByNumber("regtest.BaseMessage", 1002)
+	require.NoError(t, err)
+	require.Equal(t, protoreflect.FullName("regtest.ExtensionMessage.NestedExtension.ef3"), et.TypeDescriptor().FullName())
+	require.Equal(t, 3, r.NumExtensionsByMessage("regtest.BaseMessage"))
+}
+
+func TestTypePrecedence(t *testing.T) {
+	// google.api.HttpRule is linked into the test binary by the
+	// annotations import, with a different shape to this one.
+	source := `syntax = "proto3";
+package google.api;
+message HttpRule { string selector = 1; }
+message CustomHttpPattern { string kind = 1; string path = 2; }
+`
+	tests := map[string]struct {
+		options []RegistryOption
+		message string
+		global  bool
+		err     string
+	}{
+		"global first":                {message: "google.api.HttpRule", global: true},
+		"local first":                 {options: []RegistryOption{TypePrecedence(LocalFirst)}, message: "google.api.HttpRule"},
+		"local only":                  {options: []RegistryOption{TypePrecedence(LocalOnly)}, message: "google.api.HttpRule"},
+		"local first falls back":      {options: []RegistryOption{TypePrecedence(LocalFirst)}, message: "google.protobuf.FileOptions", global: true},
+		"local only does not":         {options: []RegistryOption{TypePrecedence(LocalOnly)}, message: "google.protobuf.FileOptions", err: "not found"},
+		"same shape is no conflict":   {options: []RegistryOption{DetectConflicts()}, message: "google.api.CustomHttpPattern", global: true},
+		"only local is no conflict":   {options: []RegistryOption{DetectConflicts(), TypePrecedence(LocalFirst)}, message: "google.api.CustomHttpPattern"},
+		"local only has no conflicts": {options: []RegistryOption{DetectConflicts(), TypePrecedence(LocalOnly)}, message: "google.api.HttpRule"},
+		"global first conflict": {options: []RegistryOption{DetectConflicts()}, message: "google.api.HttpRule",
+			err: "google.api.HttpRule declared in http.proto differs from the registered type declared in google/api/http.proto"},
+		"local first conflict": {options: []RegistryOption{DetectConflicts(), TypePrecedence(LocalFirst)}, message: "google.api.HttpRule",
+			err: "google.api.HttpRule declared in http.proto differs from the registered type declared in google/api/http.proto"},
+	}
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "http.proto"), []byte(source), 0o600)
+	require.NoError(t, err)
+	fds, err := Compile([]string{"http.proto"}, []string{dir}, true)
+	require.NoError(t, err)
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			r, err := NewRegistry(fds, tc.options...)
+			require.NoError(t, err)
+			mt, err := r.FindMessageByName(protoreflect.FullName(tc.message))
+			switch {
+			case tc.err == "not found":
+				require.ErrorIs(t, err, protoregistry.NotFound)
+				return
+			case tc.err != "":
+				require.EqualError(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			_, dynamic := mt.New().Interface().(*dynamicpb.Message)
+			require.Equal(t, tc.global, !dynamic)
+		})
+	}
+}
+
+func TestConflictingExtension(t *testing.T) {
+	source := `syntax = "proto2";
+package google.api;
+import "google/protobuf/descriptor.proto";
+extend google.protobuf.MethodOptions { optional string http = 72295728; }
+`
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "annotations.proto"), []byte(source), 0o600)
+	require.NoError(t, err)
+	fds, err := Compile([]string{"annotations.proto"}, []string{dir, "testdata"}, true)
+	require.NoError(t, err)
+	r, err := NewRegistry(fds, DetectConflicts(), TypePrecedence(LocalFirst))
+	require.NoError(t, err)
+	_, err = r.FindExtensionByNumber("google.protobuf.MethodOptions", 72295728)
+	var conflict *ConflictError
+	require.ErrorAs(t, err, &conflict)
+	require.Equal(t, protoreflect.FullName("google.api.http"), conflict.Name)
+	_, err = r.FindExtensionByName("google.api.http")
+	require.ErrorAs(t, err, &conflict)
+	// The descriptor.proto of testdata declares the values of Label in
+	// another order than the one linked into the binary, but lacks the
+	// features field of the options messages.
+	_, err = r.FindEnumByName("google.protobuf.FieldDescriptorProto.Label")
+	require.NoError(t, err)
+	_, err = r.FindMessageByName("google.protobuf.FieldDescriptorProto")
+	require.NoError(t, err)
+	_, err = r.FindMessageByName("google.protobuf.MethodOptions")
+	require.EqualError(t, err, "google.protobuf.MethodOptions declared in google/protobuf/descriptor.proto differs from the registered type declared in google/protobuf/descriptor.proto")
+}
+
+func TestFindEnumByName(t *testing.T) {
+	r := newCompiledRegistry(t, "09_proto2_enum.proto")
+	tests := map[string]struct {
+		name string
+		err  error
+	}{
+		"top-level enum":      {"pkg.Color", nil},
+		"nested enum":         {"pkg.Nest.Egg.EggKind", nil},
+		"unknown enum":        {"pkg.Foo", protoregistry.NotFound},
+		"non-enum descriptor": {"pkg.Nest", protoregistry.NotFound},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			enumName := protoreflect.FullName(tc.name)
+			et, err := r.FindEnumByName(enumName)
+			if tc.err != nil {
+				require.ErrorIs(t, err, tc.err)
+			} else {
+				require.NoError(t, err, tc.name)
+				require.Equal(t, enumName, et.Descriptor().FullName())
+			}
+		})
+	}
+}
+
+func TestFindServiceByName(t *testing.T) {
+	r := newRegistry(t)
+	sd, err := r.FindServiceByName("regtest.Dummy")
+	require.NoError(t, err)
+	require.Equal(t, protoreflect.Name("Dummy"), sd.Methods().Get(0).Name())
+	_, err = r.FindServiceByName("regtest.Empty")
+	require.ErrorIs(t, err, protoregistry.NotFound)
+	_, err = r.FindServiceByName("regtest.Unknown")
+	require.ErrorIs(t, err, protoregistry.NotFound)
+}
+
+func TestRangeTypes(t *testing.T) {
+	r := newRegistry(t)
+	var messages []protoreflect.FullName
+	r.RangeMessages(func(mt protoreflect.MessageType) bool {
+		if name := mt.Descriptor().FullName(); name.Parent() == "regtest" || name.Parent().Parent() == "regtest" {
+			messages = append(messages, name)
+		}
+		return true
+	})
+	require.Equal(t, []protoreflect.FullName{
+		"regtest.BaseMessage",
+		"regtest.ExtensionMessage",
+		"regtest.ExtensionMessage.NestedExtension",
+		"regtest.Empty",
+	}, messages)
+	require.Greater(t, r.NumMessages(), len(messages))
+
+	var extensions []protoreflect.FullName
+	r.RangeExtensionsByMessage("regtest.BaseMessage", func(xt protoreflect.ExtensionType) bool {
+		extensions = append(extensions, xt.TypeDescriptor().FullName())
+		return true
+	})
+	require.Equal(t, []protoreflect.FullName{
+		"regtest.ef1",
+		"regtest.ExtensionMessage.ef2",
+		"regtest.ExtensionMessage.NestedExtension.ef3",
+	}, extensions)
+	require.Equal(t, 3, r.NumExtensionsByMessage("regtest.BaseMessage"))
+	require.Equal(t, 2, r.NumExtensionsByMessage("google.protobuf.MethodOptions"))
+	require.Equal(t, 0, r.NumExtensionsByMessage("regtest.Empty"))
+	require.Equal(t, 5, r.NumExtensions())
+
+	// Ranging stops when fn returns false.
+	n := 0
+	r.RangeExtensions(func(protoreflect.ExtensionType) bool { n++; return n < 2 })
+	require.Equal(t, 2, n)
+
+	enums := map[protoreflect.FullName]bool{}
+	r.RangeEnums(func(et protoreflect.EnumType) bool {
+		enums[et.Descriptor().FullName()] = true
+		return true
+	})
+	require.True(t, enums["google.protobuf.FieldDescriptorProto.Type"])
+	require.Equal(t, len(enums), r.NumEnums())
+}
+
+func TestRangeDynamicTypes(t *testing.T) {
+	r := newCompiledRegistry(t, "15_proto3_map.proto")
+	r.RangeMessages(func(mt protoreflect.MessageType) bool {
+		require.False(t, mt.Descriptor().IsMapEntry(), mt.Descriptor().FullName())
+		// Messages of the registry can be marshalled and unmarshalled
+		// without generated code.
+		msg := mt.New().Interface()
+		b, err := protojson.Marshal(msg)
+		require.NoError(t, err)
+		require.NoError(t, protojson.UnmarshalOptions{Resolver: r}.Unmarshal(b, msg))
+		return true
+	})
+	require.NotZero(t, r.NumMessages())
+}
+
+func BenchmarkNewRegistry(b *testing.B) {
+	fds := loadConformanceFiles(b, "unittest_enormous_descriptor.pb", "unittest.pb")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := NewRegistry(fds); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkFindExtensionByNumber(b *testing.B) {
+	r := newConformanceRegistry(b)
+	var xds []protoreflect.ExtensionDescriptor
+	r.RangeExtensionsByMessage("protobuf_unittest.TestAllExtensions", func(xt protoreflect.ExtensionType) bool {
+		xds = append(xds, xt.TypeDescriptor())
+		return true
+	})
+	require.NotEmpty(b, xds)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		xd := xds[i%len(xds)]
+		if _, err := r.FindExtensionByNumber(xd.ContainingMessage().FullName(), xd.Number()); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkFindExtensionByNumberNotFound(b *testing.B) {
+	r := newConformanceRegistry(b)
+	for i := 0; i < b.N; i++ {
+		number := protoreflect.FieldNumber(i%1000 + 1)
+		if _, err := r.FindExtensionByNumber("protobuf_unittest.TestEnormousDescriptor", number); err == nil {
+			b.Fatal("unexpected extension")
+		}
+	}
+}
+
+func BenchmarkUnmarshalEnormous(b *testing.B) {
+	r := newConformanceRegistry(b)
+	mt, err := r.FindMessageByName("protobuf_unittest.TestEnormousDescriptor")
+	require.NoError(b, err)
+	m := mt.New()
+	fields := m.Descriptor().Fields()
+	for i := 0; i < fields.Len(); i++ {
+		m.Set(fields.Get(i), fields.Get(i).Default())
+	}
+	data, err := proto.Marshal(m.Interface())
+	require.NoError(b, err)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		mt, err := r.FindMessageByName("protobuf_unittest.TestEnormousDescriptor")
+		if err != nil {
+			b.Fatal(err)
+		}
+		if err := (proto.UnmarshalOptions{Resolver: r}).Unmarshal(data, mt.New().Interface()); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkUnmarshalExtensions(b *testing.B) {
+	r := newConformanceRegistry(b)
+	mt, err := r.FindMessageByName("protobuf_unittest.TestAllExtensions")
+	require.NoError(b, err)
+	m := mt.New()
+	r.RangeExtensionsByMessage(mt.Descriptor().FullName(), func(xt protoreflect.ExtensionType) bool {
+		if xd := xt.TypeDescriptor(); !xd.IsList() && xd.Message() == nil {
+			m.Set(xd, xd.Default())
+		}
+		return true
+	})
+	data, err := proto.Marshal(m.Interface())
+	require.NoError(b, err)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := (proto.UnmarshalOptions{Resolver: r}).Unmarshal(data, mt.New().Interface()); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// newConformanceRegistry returns a registry of the enormous descriptor
+// and unittest.proto conformance fixtures, which has about 1000 fields in
+// one message and over 100 extensions.
+func newConformanceRegistry(tb testing.TB) *Registry {
+	tb.Helper()
+	r, err := NewRegistry(loadConformanceFiles(tb, "unittest_enormous_descriptor.pb", "unittest.pb"))
+	require.NoError(tb, err)
+	return r
+}
+
+func loadConformanceFiles(tb testing.TB, files ...string) *descriptorpb.FileDescriptorSet {
+	tb.Helper()
+	all := &descriptorpb.FileDescriptorSet{}
+	for _, file := range files {
+		b, err := os.ReadFile("../testdata/conformance/pb/" + file)
+		require.NoError(tb, err)
+		fds := &descriptorpb.FileDescriptorSet{}
+		require.NoError(tb, proto.Unmarshal(b, fds))
+		all.File = append(all.File, fds.File...)
+	}
+	return all
+}
+
+func newCompiledRegistry(t *testing.T, file string) *Registry {
+	t.Helper()
+	result, err := Build([]string{file}, []string{"testdata"}, true)
+	require.NoError(t, err)
+	return result.Registry()
+}
+
 func newRegistry(t *testing.T) *Registry {
 	t.Helper()
 	b, err := os.ReadFile("testdata/pb/regtest.pb")
@@ -0,0 +1,202 @@
+package compiler
+
+import (
+	"strings"
+
+	"github.com/alecthomas/protobuf/parser"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	pb "google.golang.org/protobuf/types/descriptorpb"
+)
+
+// Result of Build.
+type Result struct {
+	// FileDescriptorSet of the compiled files, and of their imports if
+	// includeImports was set. It is the value returned by Compile.
+	FileDescriptorSet *pb.FileDescriptorSet
+	// ASTs of all parsed files, including imports, by file name.
+	ASTs map[string]*parser.Proto
+
+	registry   *Registry
+	files      []protoreflect.FileDescriptor
+	options    map[parser.Node]proto.Message
+	references map[parser.Node]string
+}
+
+func newResult(asts []*ast, all, filtered *pb.FileDescriptorSet, reg *Registry, types *types, files []string) (*Result, error) {
+	r := &Result{
+		FileDescriptorSet: filtered,
+		ASTs:              make(map[string]*parser.Proto, len(asts)),
+		registry:          reg,
+		options:           map[parser.Node]proto.Message{},
+		references:        types.references,
+	}
+	for i, a := range asts {
+		r.ASTs[a.file] = a.proto
+		r.addFileOptions(a, all.File[i])
+	}
+	for _, file := range files {
+		fd, err := reg.FindFileByPath(file)
+		if err != nil {
+			return nil, err
+		}
+		r.files = append(r.files, fd)
+	}
+	return r, nil
+}
+
+// Registry returns the linked descriptors of the compiled files and all
+// of their imports, with their options resolved. The registry also
+// provides dynamic types for their messages and extensions, so that
+// dynamicpb messages can be created and unmarshalled without generated
+// code.
+func (r *Result) Registry() *Registry { return r.registry }
+
+// Files returns the linked descriptors of the files given to Build, in
+// the same order.
+func (r *Result) Files() []protoreflect.FileDescriptor { return r.files }
+
+// OptionsFor returns the resolved options of a node of one of the ASTs
+// of r, such as a *pb.FieldOptions for a *parser.Field. Custom options
+// are set as extension fields. A *parser.Proto returns its file
+// options and a *parser.Group the options of its message.
+//
+// OptionsFor returns nil if node does not declare something with
+// options, or is not part of the ASTs of r. A declaration without any
+// options returns an empty options message.
+func (r *Result) OptionsFor(node parser.Node) protoreflect.Message {
+	opts, ok := r.options[node]
+	if !ok {
+		return nil
+	}
+	return opts.ProtoReflect()
+}
+
+// Resolve returns the full name of the declaration that a reference in
+// one of the ASTs of r resolves to: the message or enum of a
+// *parser.Type with a Reference, the extendee of a *parser.Extend, the
+// extension of a *parser.OptionName in parentheses, or the extension or
+// Any type URL in brackets of a *parser.ProtoTextField.
+func (r *Result) Resolve(node parser.Node) (protoreflect.FullName, bool) {
+	name, ok := r.references[node]
+	return protoreflect.FullName(strings.TrimPrefix(name, ".")), ok
+}
+
+// Option returns the value of an option of a node, with name as it would
+// appear in an option statement, such as "deprecated" or
+// "(validate.rules).string.min_len". Extension names must be fully
+// qualified. The second return value is false if the option is not set
+// or if any part of name cannot be resolved.
+func (r *Result) Option(node parser.Node, name string) (protoreflect.Value, bool) {
+	msg := r.OptionsFor(node)
+	if msg == nil {
+		return protoreflect.Value{}, false
+	}
+	parts := splitOptionName(name)
+	for i, part := range parts {
+		var fd protoreflect.FieldDescriptor
+		if strings.HasPrefix(part, "(") {
+			name := protoreflect.FullName(strings.TrimPrefix(strings.Trim(part, "()"), "."))
+			xt, err := r.registry.FindExtensionByName(name)
+			if err != nil {
+				return protoreflect.Value{}, false
+			}
+			fd = xt.TypeDescriptor()
+		} else {
+			fd = msg.Descriptor().Fields().ByName(protoreflect.Name(part))
+		}
+		if fd == nil || fd.ContainingMessage().FullName() != msg.Descriptor().FullName() || !msg.Has(fd) {
+			return protoreflect.Value{}, false
+		}
+		if i == len(parts)-1 {
+			return msg.Get(fd), true
+		}
+		if fd.Message() == nil || fd.IsList() || fd.IsMap() {
+			return protoreflect.Value{}, false
+		}
+		msg = msg.Get(fd).Message()
+	}
+	return protoreflect.Value{}, false
+}
+
+// splitOptionName splits an option name at the dots that are not
+// within parentheses.
+func splitOptionName(name string) []string {
+	var parts []string
+	start, depth := 0, 0
+	for i, r := range name {
+		switch r {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case '.':
+			if depth == 0 {
+				parts = append(parts, name[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, name[start:])
+}
+
+func (r *Result) addFileOptions(a *ast, fd *pb.FileDescriptorProto) {
+	decls := newDeclarations(a)
+	r.add(a.proto, fd.GetOptions(), &pb.FileOptions{})
+	prefix := fd.GetPackage()
+	for _, md := range fd.GetMessageType() {
+		r.addMessageOptions(decls, md, prefix)
+	}
+	for _, ed := range fd.GetEnumType() {
+		r.addEnumOptions(decls, ed, prefix)
+	}
+	for _, sd := range fd.GetService() {
+		name := qualify(prefix, sd.GetName())
+		r.add(decls[name], sd.GetOptions(), &pb.ServiceOptions{})
+		for _, md := range sd.GetMethod() {
+			r.add(decls[qualify(name, md.GetName())], md.GetOptions(), &pb.MethodOptions{})
+		}
+	}
+	for _, ext := range fd.GetExtension() {
+		r.add(decls[qualify(prefix, ext.GetName())], ext.GetOptions(), &pb.FieldOptions{})
+	}
+}
+
+func (r *Result) addMessageOptions(decls declarations, md *pb.DescriptorProto, prefix string) {
+	name := qualify(prefix, md.GetName())
+	r.add(decls[name], md.GetOptions(), &pb.MessageOptions{})
+	for _, fd := range md.GetField() {
+		r.add(decls[qualify(name, fd.GetName())], fd.GetOptions(), &pb.FieldOptions{})
+	}
+	for _, ext := range md.GetExtension() {
+		r.add(decls[qualify(name, ext.GetName())], ext.GetOptions(), &pb.FieldOptions{})
+	}
+	for _, od := range md.GetOneofDecl() {
+		r.add(decls[qualify(name, od.GetName())], od.GetOptions(), &pb.OneofOptions{})
+	}
+	for _, nested := range md.GetNestedType() {
+		r.addMessageOptions(decls, nested, name)
+	}
+	for _, ed := range md.GetEnumType() {
+		r.addEnumOptions(decls, ed, name)
+	}
+}
+
+func (r *Result) addEnumOptions(decls declarations, ed *pb.EnumDescriptorProto, prefix string) {
+	r.add(decls[qualify(prefix, ed.GetName())], ed.GetOptions(), &pb.EnumOptions{})
+	for _, ev := range ed.GetValue() {
+		r.add(decls[qualify(prefix, ev.GetName())], ev.GetOptions(), &pb.EnumValueOptions{})
+	}
+}
+
+// add records the options of a node, or empty if the options are nil.
+// Synthetic declarations without a node are skipped.
+func (r *Result) add(node parser.Node, opts, empty proto.Message) {
+	if node == nil {
+		return
+	}
+	if opts.ProtoReflect().IsValid() {
+		empty = opts
+	}
+	r.options[node] = empty
+}
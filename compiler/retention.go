@@ -0,0 +1,176 @@
+package compiler
+
+import (
+	"fmt"
+	"strings"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+	pb "google.golang.org/protobuf/types/descriptorpb"
+)
+
+// optionTargets maps each options message to the target type of the
+// declarations it is used on.
+var optionTargets = map[protoreflect.FullName]pb.FieldOptions_OptionTargetType{
+	"google.protobuf.FileOptions":           pb.FieldOptions_TARGET_TYPE_FILE,
+	"google.protobuf.ExtensionRangeOptions": pb.FieldOptions_TARGET_TYPE_EXTENSION_RANGE,
+	"google.protobuf.MessageOptions":        pb.FieldOptions_TARGET_TYPE_MESSAGE,
+	"google.protobuf.FieldOptions":          pb.FieldOptions_TARGET_TYPE_FIELD,
+	"google.protobuf.OneofOptions":          pb.FieldOptions_TARGET_TYPE_ONEOF,
+	"google.protobuf.EnumOptions":           pb.FieldOptions_TARGET_TYPE_ENUM,
+	"google.protobuf.EnumValueOptions":      pb.FieldOptions_TARGET_TYPE_ENUM_ENTRY,
+	"google.protobuf.ServiceOptions":        pb.FieldOptions_TARGET_TYPE_SERVICE,
+	"google.protobuf.MethodOptions":         pb.FieldOptions_TARGET_TYPE_METHOD,
+}
+
+// fieldOptions maps the full name of every field and extension of a
+// FileDescriptorSet to its resolved options, which hold the retention
+// and targets of the field when it is used in an option.
+type fieldOptions map[protoreflect.FullName]*pb.FieldOptions
+
+func newFieldOptionsIndex(fds *pb.FileDescriptorSet) fieldOptions {
+	fo := fieldOptions{}
+	for _, fd := range fds.File {
+		prefix := fd.GetPackage()
+		for _, md := range fd.GetMessageType() {
+			fo.addMessage(md, prefix)
+		}
+		fo.addFields(fd.GetExtension(), prefix)
+	}
+	return fo
+}
+
+func (fo fieldOptions) addMessage(md *pb.DescriptorProto, prefix string) {
+	name := qualify(prefix, md.GetName())
+	fo.addFields(md.GetField(), name)
+	fo.addFields(md.GetExtension(), name)
+	for _, nested := range md.GetNestedType() {
+		fo.addMessage(nested, name)
+	}
+}
+
+func (fo fieldOptions) addFields(fields []*pb.FieldDescriptorProto, prefix string) {
+	for _, fd := range fields {
+		if fd.Options != nil {
+			fo[protoreflect.FullName(qualify(prefix, fd.GetName()))] = fd.Options
+		}
+	}
+}
+
+// checkTargets returns an error if an option of a declaration in fd,
+// or a field set within the value of an option, is not allowed on the
+// target type of the declaration.
+func (fo fieldOptions) checkTargets(fd *pb.FileDescriptorProto) error {
+	return rangeOptions(fd.ProtoReflect(), fd.GetPackage(), func(name string, opts protoreflect.Message) error {
+		target := optionTargets[opts.Descriptor().FullName()]
+		return fo.rangeFields(opts, func(_ protoreflect.Message, field protoreflect.FieldDescriptor, options *pb.FieldOptions) error {
+			targets := options.GetTargets()
+			if len(targets) == 0 {
+				return nil
+			}
+			allowed := make([]string, len(targets))
+			for i, t := range targets {
+				if t == target {
+					return nil
+				}
+				allowed[i] = t.String()
+			}
+			return fmt.Errorf("%s: option %s cannot be used on %s %s, allowed targets: %s",
+				fd.GetName(), field.FullName(), targetName(target), name, strings.Join(allowed, ", "))
+		})
+	})
+}
+
+// stripSourceRetention clears all options of declarations in fd, and
+// fields within the values of options, that have source retention.
+func (fo fieldOptions) stripSourceRetention(fd *pb.FileDescriptorProto) {
+	_ = rangeOptions(fd.ProtoReflect(), fd.GetPackage(), func(_ string, opts protoreflect.Message) error {
+		return fo.rangeFields(opts, func(msg protoreflect.Message, field protoreflect.FieldDescriptor, options *pb.FieldOptions) error {
+			if options.GetRetention() == pb.FieldOptions_RETENTION_SOURCE {
+				msg.Clear(field)
+			}
+			return nil
+		})
+	})
+}
+
+// rangeFields calls fn for every set field of msg and of the messages
+// nested within it that has options, depth first. fn may clear the
+// field it is called with.
+func (fo fieldOptions) rangeFields(msg protoreflect.Message, fn func(protoreflect.Message, protoreflect.FieldDescriptor, *pb.FieldOptions) error) error {
+	var fields []protoreflect.FieldDescriptor
+	msg.Range(func(field protoreflect.FieldDescriptor, _ protoreflect.Value) bool {
+		fields = append(fields, field)
+		return true
+	})
+	for _, field := range fields {
+		if field.Message() != nil {
+			if err := fo.rangeNested(msg.Get(field), field, fn); err != nil {
+				return err
+			}
+		}
+		if options, ok := fo[field.FullName()]; ok {
+			if err := fn(msg, field, options); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (fo fieldOptions) rangeNested(v protoreflect.Value, field protoreflect.FieldDescriptor, fn func(protoreflect.Message, protoreflect.FieldDescriptor, *pb.FieldOptions) error) error {
+	switch {
+	case field.IsList():
+		list := v.List()
+		for i := 0; i < list.Len(); i++ {
+			if err := fo.rangeFields(list.Get(i).Message(), fn); err != nil {
+				return err
+			}
+		}
+	case field.IsMap():
+		if field.MapValue().Message() == nil {
+			return nil
+		}
+		var err error
+		v.Map().Range(func(_ protoreflect.MapKey, v protoreflect.Value) bool {
+			err = fo.rangeFields(v.Message(), fn)
+			return err == nil
+		})
+		return err
+	default:
+		return fo.rangeFields(v.Message(), fn)
+	}
+	return nil
+}
+
+// rangeOptions calls fn with the options of msg and of every
+// declaration nested in it, along with the full name of the
+// declaration. The options of a file are named by its package and those
+// of an extension range by its message.
+func rangeOptions(msg protoreflect.Message, name string, fn func(name string, opts protoreflect.Message) error) error {
+	var err error
+	msg.Range(func(field protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		switch {
+		case field.Name() == "options":
+			err = fn(name, v.Message())
+		case field.Message() != nil && field.IsList():
+			list := v.List()
+			for i := 0; i < list.Len() && err == nil; i++ {
+				elem := list.Get(i).Message()
+				elemName := name
+				if nameField := elem.Descriptor().Fields().ByName("name"); nameField != nil && elem.Has(nameField) {
+					elemName = qualify(name, elem.Get(nameField).String())
+				}
+				err = rangeOptions(elem, elemName, fn)
+			}
+		}
+		return err == nil
+	})
+	return err
+}
+
+// targetName returns a readable name of a target type, such as
+// "enum entry" for TARGET_TYPE_ENUM_ENTRY.
+func targetName(target pb.FieldOptions_OptionTargetType) string {
+	name := strings.TrimPrefix(target.String(), "TARGET_TYPE_")
+	return strings.ReplaceAll(strings.ToLower(name), "_", " ")
+}
@@ -0,0 +1,351 @@
+package compiler
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/alecthomas/participle/v2/lexer"
+	"github.com/alecthomas/protobuf/parser"
+	pb "google.golang.org/protobuf/types/descriptorpb"
+)
+
+// Field numbers of the repeated declaration fields in descriptor.proto,
+// used to build SourceCodeInfo_Location paths.
+const (
+	fileMessageTypePath   = 4
+	fileEnumTypePath      = 5
+	fileServicePath       = 6
+	fileExtensionPath     = 7
+	messageFieldPath      = 2
+	messageNestedTypePath = 3
+	messageEnumTypePath   = 4
+	messageExtensionPath  = 6
+	messageOneofDeclPath  = 8
+	enumValuePath         = 2
+	serviceMethodPath     = 2
+)
+
+// declarations maps the fully qualified name (without leading ".") of
+// every declaration in an AST to the parser node declaring it. Group
+// fields map to their *parser.Field and group messages to their
+// *parser.Group. Synthetic declarations such as map entries have no node.
+type declarations map[string]parser.Node
+
+func newDeclarations(a *ast) declarations {
+	d := declarations{}
+	var scope []string
+	if a.pkg != "" {
+		scope = strings.Split(a.pkg, ".")
+	}
+	for _, e := range a.proto.Entries {
+		switch {
+		case e.Message != nil:
+			d.addMessage(e.Message.Name, e.Message, e.Message.Entries, scope)
+		case e.Enum != nil:
+			d.addEnum(e.Enum, scope)
+		case e.Service != nil:
+			name := d.add(e.Service.Name, e.Service, scope)
+			for _, se := range e.Service.Entries {
+				if se.Method != nil {
+					d.add(se.Method.Name, se.Method, []string{name})
+				}
+			}
+		case e.Extend != nil:
+			d.addFields(e.Extend.Fields, scope)
+		}
+	}
+	return d
+}
+
+func (d declarations) add(name string, node parser.Node, scope []string) string {
+	fullName := strings.Join(append(scope[:len(scope):len(scope)], name), ".")
+	d[fullName] = node
+	return fullName
+}
+
+func (d declarations) addMessage(name string, node parser.Node, entries []*parser.MessageEntry, scope []string) {
+	d.add(name, node, scope)
+	scope = append(scope[:len(scope):len(scope)], name)
+	for _, e := range entries {
+		switch {
+		case e.Field != nil:
+			d.addFields([]*parser.Field{e.Field}, scope)
+		case e.Message != nil:
+			d.addMessage(e.Message.Name, e.Message, e.Message.Entries, scope)
+		case e.Enum != nil:
+			d.addEnum(e.Enum, scope)
+		case e.Extend != nil:
+			d.addFields(e.Extend.Fields, scope)
+		case e.Oneof != nil:
+			d.add(e.Oneof.Name, e.Oneof, scope)
+			for _, oe := range e.Oneof.Entries {
+				if oe.Field != nil {
+					d.addFields([]*parser.Field{oe.Field}, scope)
+				}
+			}
+		}
+	}
+}
+
+func (d declarations) addFields(fields []*parser.Field, scope []string) {
+	for _, f := range fields {
+		d.add(fieldName(f), f, scope)
+		if g := f.Group; g != nil {
+			d.addMessage(g.Name, g, g.Entries, scope)
+		}
+	}
+}
+
+func (d declarations) addEnum(e *parser.Enum, scope []string) {
+	d.add(e.Name, e, scope)
+	// Enum values are siblings of their enum, not children.
+	for _, ee := range e.Values {
+		if ee.Value != nil {
+			d.add(ee.Value.Key, ee.Value, scope)
+		}
+	}
+}
+
+// sourceInfoBuilder creates SourceCodeInfo locations for the
+// declarations of a file from their nodes' positions and the raw
+// tokens of the source, including comments.
+type sourceInfoBuilder struct {
+	decls  declarations
+	tokens []lexer.Token
+	// offsets maps the byte offset of every token to its index in tokens.
+	offsets map[int]int
+	info    *pb.SourceCodeInfo
+}
+
+func newSourceCodeInfo(a *ast, fd *pb.FileDescriptorProto) (*pb.SourceCodeInfo, error) {
+	tokens, err := parser.Lex(a.file, bytes.NewReader(a.source))
+	if err != nil {
+		return nil, err
+	}
+	b := &sourceInfoBuilder{
+		decls:   newDeclarations(a),
+		tokens:  tokens,
+		offsets: make(map[int]int, len(tokens)),
+		info:    &pb.SourceCodeInfo{},
+	}
+	for i, t := range tokens {
+		b.offsets[t.Pos.Offset] = i
+	}
+	prefix := fd.GetPackage()
+	for i, md := range fd.GetMessageType() {
+		b.addMessage(md, prefix, []int32{fileMessageTypePath, int32(i)})
+	}
+	for i, ed := range fd.GetEnumType() {
+		b.addEnum(ed, prefix, []int32{fileEnumTypePath, int32(i)})
+	}
+	for i, sd := range fd.GetService() {
+		name := qualify(prefix, sd.GetName())
+		path := []int32{fileServicePath, int32(i)}
+		b.addLocation(name, path)
+		for j, md := range sd.GetMethod() {
+			b.addLocation(qualify(name, md.GetName()), appendPath(path, serviceMethodPath, j))
+		}
+	}
+	for i, ext := range fd.GetExtension() {
+		b.addLocation(qualify(prefix, ext.GetName()), []int32{fileExtensionPath, int32(i)})
+	}
+	return b.info, nil
+}
+
+func (b *sourceInfoBuilder) addMessage(md *pb.DescriptorProto, prefix string, path []int32) {
+	name := qualify(prefix, md.GetName())
+	b.addLocation(name, path)
+	for i, fd := range md.GetField() {
+		b.addLocation(qualify(name, fd.GetName()), appendPath(path, messageFieldPath, i))
+	}
+	for i, nested := range md.GetNestedType() {
+		b.addMessage(nested, name, appendPath(path, messageNestedTypePath, i))
+	}
+	for i, ed := range md.GetEnumType() {
+		b.addEnum(ed, name, appendPath(path, messageEnumTypePath, i))
+	}
+	for i, ext := range md.GetExtension() {
+		b.addLocation(qualify(name, ext.GetName()), appendPath(path, messageExtensionPath, i))
+	}
+	for i, od := range md.GetOneofDecl() {
+		b.addLocation(qualify(name, od.GetName()), appendPath(path, messageOneofDeclPath, i))
+	}
+}
+
+func (b *sourceInfoBuilder) addEnum(ed *pb.EnumDescriptorProto, prefix string, path []int32) {
+	b.addLocation(qualify(prefix, ed.GetName()), path)
+	for i, ev := range ed.GetValue() {
+		b.addLocation(qualify(prefix, ev.GetName()), appendPath(path, enumValuePath, i))
+	}
+}
+
+// addLocation adds a location for the declaration with the given full
+// name. Declarations without a source node, such as map entries and
+// synthetic oneofs, are skipped.
+func (b *sourceInfoBuilder) addLocation(fullName string, path []int32) {
+	node, ok := b.decls[fullName]
+	if !ok {
+		return
+	}
+	start, end, ok := b.tokenRange(node)
+	if !ok {
+		return
+	}
+	loc := &pb.SourceCodeInfo_Location{
+		Path: path,
+		Span: span(b.tokens[start], b.tokens[end]),
+	}
+	leading, detached := b.leadingComments(start)
+	if leading != "" {
+		loc.LeadingComments = &leading
+	}
+	loc.LeadingDetachedComments = detached
+	if trailing := b.trailingComment(end); trailing != "" {
+		loc.TrailingComments = &trailing
+	}
+	b.info.Location = append(b.info.Location, loc)
+}
+
+// tokenRange returns the indices of the first and last non-trivia
+// tokens of a node, including a terminating ";" if there is one.
+func (b *sourceInfoBuilder) tokenRange(node parser.Node) (start, end int, ok bool) {
+	var pos, endPos lexer.Position
+	switch n := node.(type) {
+	case *parser.Message:
+		pos, endPos = n.Pos, n.EndPos
+	case *parser.Group:
+		pos, endPos = n.Pos, n.EndPos
+	case *parser.Field:
+		pos, endPos = n.Pos, n.EndPos
+	case *parser.OneOf:
+		pos, endPos = n.Pos, n.EndPos
+	case *parser.Enum:
+		pos, endPos = n.Pos, n.EndPos
+	case *parser.EnumValue:
+		pos, endPos = n.Pos, n.EndPos
+	case *parser.Service:
+		pos, endPos = n.Pos, n.EndPos
+	case *parser.Method:
+		pos, endPos = n.Pos, n.EndPos
+	default:
+		return 0, 0, false
+	}
+	if start, ok = b.offsets[pos.Offset]; !ok {
+		return 0, 0, false
+	}
+	if end, ok = b.offsets[endPos.Offset]; !ok {
+		end = len(b.tokens)
+	}
+	end = b.prevNonTrivia(end)
+	if next := b.nextNonTrivia(end); next < len(b.tokens) && b.tokens[next].Value == ";" {
+		end = next
+	}
+	return start, end, true
+}
+
+func (b *sourceInfoBuilder) prevNonTrivia(i int) int {
+	for i--; i >= 0 && parser.IsTrivia(b.tokens[i]); i-- {
+	}
+	return i
+}
+
+func (b *sourceInfoBuilder) nextNonTrivia(i int) int {
+	for i++; i < len(b.tokens) && parser.IsTrivia(b.tokens[i]); i++ {
+	}
+	return i
+}
+
+// leadingComments returns the comment block directly preceding the token
+// at start and the blocks before it that are separated from it by blank
+// lines, similar to the rules protoc uses for SourceCodeInfo comments.
+func (b *sourceInfoBuilder) leadingComments(start int) (leading string, detached []string) {
+	prev := b.prevNonTrivia(start)
+	var blocks [][]lexer.Token
+	// newlines since the end of the last comment, two or more of which
+	// are a blank line separating comment blocks.
+	newlines := 0
+	for i := prev + 1; i < start; i++ {
+		t := b.tokens[i]
+		if !parser.IsComment(t) {
+			newlines += strings.Count(t.Value, "\n")
+			continue
+		}
+		sameLineAsPrev := prev >= 0 && t.Pos.Line == b.tokens[prev].Pos.Line
+		switch {
+		case sameLineAsPrev:
+			// trailing comment of the previous token
+		case len(blocks) == 0 || newlines > 1:
+			blocks = append(blocks, []lexer.Token{t})
+		default:
+			blocks[len(blocks)-1] = append(blocks[len(blocks)-1], t)
+		}
+		newlines = 0
+		if strings.HasSuffix(t.Value, "\n") {
+			newlines = 1
+		}
+	}
+	if len(blocks) > 0 && newlines <= 1 {
+		leading = commentText(blocks[len(blocks)-1])
+		blocks = blocks[:len(blocks)-1]
+	}
+	for _, block := range blocks {
+		detached = append(detached, commentText(block))
+	}
+	return leading, detached
+}
+
+// trailingComment returns a comment starting on the same line as the
+// token at end.
+func (b *sourceInfoBuilder) trailingComment(end int) string {
+	for i := end + 1; i < len(b.tokens) && parser.IsTrivia(b.tokens[i]); i++ {
+		t := b.tokens[i]
+		if t.Pos.Line != b.tokens[end].Pos.Line {
+			break
+		}
+		if parser.IsComment(t) {
+			return commentText([]lexer.Token{t})
+		}
+	}
+	return ""
+}
+
+// commentText strips comment markers from tokens, keeping the remaining
+// text verbatim as protoc does.
+func commentText(tokens []lexer.Token) string {
+	var sb strings.Builder
+	for _, t := range tokens {
+		switch {
+		case strings.HasPrefix(t.Value, "//"):
+			sb.WriteString(strings.TrimPrefix(t.Value, "//"))
+			if !strings.HasSuffix(t.Value, "\n") {
+				sb.WriteString("\n")
+			}
+		case strings.HasPrefix(t.Value, "/*"):
+			text := strings.TrimPrefix(t.Value, "/*")
+			sb.WriteString(strings.TrimSuffix(text, "*/"))
+		}
+	}
+	return sb.String()
+}
+
+// span returns a SourceCodeInfo span from the start of the first token
+// to the end of the last token. Lines and columns are zero based.
+func span(first, last lexer.Token) []int32 {
+	startLine, startCol := int32(first.Pos.Line-1), int32(first.Pos.Column-1)
+	endLine, endCol := int32(last.Pos.Line-1), int32(last.Pos.Column-1+len(last.Value))
+	if startLine == endLine {
+		return []int32{startLine, startCol, endCol}
+	}
+	return []int32{startLine, startCol, endLine, endCol}
+}
+
+func qualify(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return prefix + "." + name
+}
+
+func appendPath(path []int32, field int32, index int) []int32 {
+	return append(path[:len(path):len(path)], field, int32(index))
+}
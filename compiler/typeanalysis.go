@@ -1,7 +1,6 @@
 package compiler
 
 import (
-	"fmt"
 	"strings"
 
 	"github.com/alecthomas/protobuf/parser"
@@ -37,6 +36,15 @@ import (
 type types struct {
 	types      map[string]pb.FieldDescriptorProto_Type
 	extensions map[string]bool
+	// options maps every UninterpretedOption to the option it was
+	// created from.
+	options map[*pb.UninterpretedOption]*parser.Option
+	// defaults maps every field with a default option to the option.
+	defaults map[*pb.FieldDescriptorProto]*parser.Option
+	// references maps every reference to a declaration, such as a
+	// *parser.Type or the extendee of a *parser.Extend, to the full name
+	// it resolves to. See Result.Resolve.
+	references map[parser.Node]string
 }
 
 func (t *types) fullName(typeName string, scope []string) (string, pb.FieldDescriptorProto_Type) {
@@ -44,7 +52,7 @@ func (t *types) fullName(typeName string, scope []string) (string, pb.FieldDescr
 		if pbType, ok := t.types[typeName]; ok {
 			return typeName, pbType
 		}
-		panic(fmt.Sprintf("typeanalysis: not found: %s, %v", typeName, scope))
+		return "", 0
 	}
 	for i := len(scope); i >= 0; i-- {
 		sn := scopedName(typeName, scope[:i])
@@ -52,15 +60,17 @@ func (t *types) fullName(typeName string, scope []string) (string, pb.FieldDescr
 			return sn, pbType
 		}
 	}
-	panic(fmt.Sprintf("typeanalysis: not found: %s, %v, %v", typeName, scope, t))
+	return "", 0
 }
 
+// extensionName returns the full name of the extension name in scope, or
+// an empty string if there is none.
 func (t *types) extensionName(name string, scope []string) string {
 	if strings.HasPrefix(name, ".") {
 		if t.extensions[name] {
 			return name
 		}
-		panic(fmt.Sprintf("typeanalysis: not found: %s, %v", name, scope))
+		return ""
 	}
 	for i := len(scope); i >= 0; i-- {
 		sn := scopedName(name, scope[:i])
@@ -68,22 +78,21 @@ func (t *types) extensionName(name string, scope []string) string {
 			return sn
 		}
 	}
-	panic(fmt.Sprintf("typeanalysis: not found: %s, %v", name, scope))
+	return ""
 }
 
 func (t *types) addName(relTypeName string, pbType pb.FieldDescriptorProto_Type, scope []string) {
 	sn := scopedName(relTypeName, scope)
 	if _, ok := t.types[sn]; ok {
-		panic(fmt.Sprintf("typeanalysis: duplicate type name: %s", sn))
+		// Duplicates are reported with their position when the files
+		// are linked.
+		return
 	}
 	t.types[sn] = pbType
 }
 
 func (t *types) addExtension(relName string, scope []string) {
 	sn := scopedName(relName, scope)
-	if _, ok := t.extensions[sn]; ok {
-		panic(fmt.Sprintf("typeanalysis: duplicate extension: %s", sn))
-	}
 	t.extensions[sn] = true
 }
 
@@ -99,6 +108,9 @@ func newTypes(asts []*ast) *types {
 	t := &types{
 		types:      map[string]pb.FieldDescriptorProto_Type{},
 		extensions: map[string]bool{},
+		options:    map[*pb.UninterpretedOption]*parser.Option{},
+		defaults:   map[*pb.FieldDescriptorProto]*parser.Option{},
+		references: map[parser.Node]string{},
 	}
 	for _, ast := range asts {
 		analyseTypes(ast, t)
@@ -141,7 +153,7 @@ func analyseExtend(e *parser.Extend, scope []string, t *types) {
 	for _, f := range e.Fields {
 		if f.Group != nil {
 			analyseGroup(f.Group, scope, t)
-			t.addExtension(strings.ToLower(f.Group.Name), scope)
+			t.addExtension(groupFieldName(f.Group), scope)
 		} else if f.Direct != nil {
 			t.addExtension(f.Direct.Name, scope)
 		}
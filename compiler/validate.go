@@ -0,0 +1,128 @@
+package compiler
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+	pb "google.golang.org/protobuf/types/descriptorpb"
+
+	"github.com/alecthomas/protobuf/parser"
+)
+
+// validate checks the rules of the protobuf language that the grammar of
+// the parser does not enforce, returning the first violation in a with
+// its position.
+func validate(a *ast) error {
+	return parser.Visit(a.proto, func(node parser.Node, next func() error) error {
+		if g, ok := node.(*parser.Group); ok {
+			if err := validateGroup(g); err != nil {
+				return err
+			}
+		}
+		return next()
+	})
+}
+
+func validateGroup(g *parser.Group) error {
+	if r := []rune(g.Name)[0]; !unicode.IsUpper(r) {
+		return fmt.Errorf("%s: group %s: group names must start with a capital letter", g.Pos, g.Name)
+	}
+	return nil
+}
+
+// validateExtends checks that a proto3 file only extends options, once
+// the extendees have been resolved into types.
+func validateExtends(a *ast, types *types) error {
+	if a.syntax != "proto3" {
+		return nil
+	}
+	return parser.Visit(a.proto, func(node parser.Node, next func() error) error {
+		if e, ok := node.(*parser.Extend); ok {
+			extendee := protoreflect.FullName(strings.TrimPrefix(types.references[e], "."))
+			if _, ok := optionTargets[extendee]; !ok {
+				return fmt.Errorf("%s: extend %s: extensions in proto3 are only allowed for defining options", e.Pos, extendee)
+			}
+		}
+		return next()
+	})
+}
+
+// validateReferences checks that the types and option names referred to
+// in a resolve in types, so that the descriptors of a can be built.
+func validateReferences(a *ast, types *types) error {
+	var scope []string
+	if a.pkg != "" {
+		scope = strings.Split(a.pkg, ".")
+	}
+	return parser.Visit(a.proto, func(node parser.Node, next func() error) error {
+		switch n := node.(type) {
+		case *parser.Message:
+			scope = append(scope, n.Name)
+			defer func() { scope = scope[:len(scope)-1] }()
+		case *parser.Group:
+			// The options of a group are those of its field, which are
+			// not children of the group and are not in its scope.
+			if err := validateOptionNames(n.Options, scope, types); err != nil {
+				return err
+			}
+			scope = append(scope, n.Name)
+			defer func() { scope = scope[:len(scope)-1] }()
+		case *parser.Extensions:
+			if err := validateOptionNames(n.Options, scope, types); err != nil {
+				return err
+			}
+		case *parser.Option:
+			if err := validateOptionNames([]*parser.Option{n}, scope, types); err != nil {
+				return err
+			}
+		case *parser.Extend:
+			if name, _ := types.fullName(n.Reference, scope); name == "" {
+				return fmt.Errorf("%s: unknown type %s", n.Pos, n.Reference)
+			}
+		case *parser.Type:
+			if n.Reference != nil {
+				if name, _ := types.fullName(*n.Reference, scope); name == "" {
+					return fmt.Errorf("%s: unknown type %s", n.Pos, *n.Reference)
+				}
+			}
+		case *parser.Method:
+			if err := validateMethodType(n, "request", n.Request, scope, types); err != nil {
+				return err
+			}
+			if err := validateMethodType(n, "response", n.Response, scope, types); err != nil {
+				return err
+			}
+		}
+		return next()
+	})
+}
+
+func validateOptionNames(options parser.Options, scope []string, types *types) error {
+	for _, o := range options {
+		for _, n := range o.Name {
+			if !strings.HasPrefix(n.Name, "(") {
+				continue
+			}
+			name := strings.TrimSuffix(strings.TrimPrefix(n.Name, "("), ")")
+			if types.extensionName(name, scope) == "" {
+				return fmt.Errorf("%s: unknown option %s", n.Pos, n.Name)
+			}
+		}
+	}
+	return nil
+}
+
+func validateMethodType(m *parser.Method, kind string, t *parser.Type, scope []string, types *types) error {
+	if t.Reference == nil {
+		return fmt.Errorf("%s: method %s: the %s type must be a message", t.Pos, m.Name, kind)
+	}
+	switch name, pbType := types.fullName(*t.Reference, scope); {
+	case name == "":
+		return fmt.Errorf("%s: unknown type %s", t.Pos, *t.Reference)
+	case pbType != pb.FieldDescriptorProto_TYPE_MESSAGE && pbType != pb.FieldDescriptorProto_TYPE_GROUP:
+		return fmt.Errorf("%s: method %s: the %s type %s must be a message", t.Pos, m.Name, kind, *t.Reference)
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+// Package conformance implements the protocol of the protobuf conformance
+// test runner with dynamic messages, to test the descriptors of a
+// compiler.Registry against the binary, JSON and text formats.
+//
+// A Testee responds to the requests of the upstream conformance test
+// runner, and Check is a harness sending requests to a testee and
+// comparing its responses with the types of reference descriptors, such as
+// those produced by protoc.
+//
+// Requests and responses are conformance.ConformanceRequest and
+// conformance.ConformanceResponse messages of conformance.proto, each
+// preceded by its length as a 32-bit little-endian integer.
+package conformance
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+
+	"github.com/alecthomas/protobuf/compiler"
+)
+
+// Files are the proto files declaring the messages of the protocol and the
+// test messages of the conformance test runner.
+var Files = []string{"conformance.proto", "test_messages_proto2.proto", "test_messages_proto3.proto"}
+
+// Names of the messages of the protocol.
+const (
+	requestName    protoreflect.FullName = "conformance.ConformanceRequest"
+	responseName   protoreflect.FullName = "conformance.ConformanceResponse"
+	failureSetName protoreflect.FullName = "conformance.FailureSet"
+)
+
+// findMessage returns the type of the message name in reg.
+func findMessage(reg *compiler.Registry, name protoreflect.FullName) (protoreflect.MessageType, error) {
+	mt, err := reg.FindMessageByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown message %s", name)
+	}
+	return mt, nil
+}
+
+// readMessage reads a message preceded by its length from r into m. It
+// returns io.EOF if r ends before the length.
+func readMessage(r io.Reader, m proto.Message) error {
+	var n uint32
+	if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
+		return err
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		if errors.Is(err, io.EOF) {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	return proto.Unmarshal(b, m)
+}
+
+// writeMessage writes m preceded by its length to w.
+func writeMessage(w io.Writer, m proto.Message) error {
+	b, err := proto.Marshal(m)
+	if err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.LittleEndian, uint32(len(b))); err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+// field returns the value of the field name of m.
+func field(m protoreflect.Message, name protoreflect.Name) protoreflect.Value {
+	return m.Get(m.Descriptor().Fields().ByName(name))
+}
+
+// enumName returns the name of the value of the enum field name of m, or
+// an empty name if the value is not declared.
+func enumName(m protoreflect.Message, name protoreflect.Name) protoreflect.Name {
+	fd := m.Descriptor().Fields().ByName(name)
+	if ev := fd.Enum().Values().ByNumber(m.Get(fd).Enum()); ev != nil {
+		return ev.Name()
+	}
+	return ""
+}
+
+// setOneof sets the field name of the oneof of m to b, as bytes or as a
+// string depending on the kind of the field.
+func setOneof(m protoreflect.Message, name protoreflect.Name, b []byte) {
+	fd := m.Descriptor().Fields().ByName(name)
+	if fd.Kind() == protoreflect.BytesKind {
+		m.Set(fd, protoreflect.ValueOfBytes(b))
+		return
+	}
+	m.Set(fd, protoreflect.ValueOfString(string(b)))
+}
+
+// oneofBytes returns the value of the field fd of a oneof as bytes.
+func oneofBytes(m protoreflect.Message, fd protoreflect.FieldDescriptor) []byte {
+	if fd.Kind() == protoreflect.BytesKind {
+		return m.Get(fd).Bytes()
+	}
+	return []byte(m.Get(fd).String())
+}
@@ -0,0 +1,118 @@
+package conformance
+
+import (
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+
+	"github.com/alecthomas/protobuf/compiler"
+)
+
+func TestCheck(t *testing.T) {
+	fds, err := compiler.Compile(Files, []string{"../testdata/conformance"}, true)
+	require.NoError(t, err)
+	reg, err := compiler.NewRegistry(fds, compiler.TypePrecedence(compiler.LocalOnly))
+	require.NoError(t, err)
+	testee, err := NewTestee(reg)
+	require.NoError(t, err)
+	reqR, reqW := io.Pipe()
+	resR, resW := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		err := testee.Serve(reqR, resW)
+		resW.Close()
+		done <- err
+	}()
+	c, err := NewClient(reqW, resR, referenceRegistry(t))
+	require.NoError(t, err)
+	failures, err := Check(c, 1, 20)
+	require.NoError(t, err)
+	require.Empty(t, failures)
+	require.NoError(t, reqW.Close())
+	require.NoError(t, <-done)
+}
+
+func TestCheckFailures(t *testing.T) {
+	// A testee of a registry without the proto2 test messages skips them.
+	fds, err := compiler.Compile([]string{"conformance.proto", "test_messages_proto3.proto"}, []string{"../testdata/conformance"}, true)
+	require.NoError(t, err)
+	reg, err := compiler.NewRegistry(fds)
+	require.NoError(t, err)
+	testee, err := NewTestee(reg)
+	require.NoError(t, err)
+	reqR, reqW := io.Pipe()
+	resR, resW := io.Pipe()
+	go testee.Serve(reqR, resW) //nolint:errcheck // ends with the pipe
+	defer reqW.Close()
+	c, err := NewClient(reqW, resR, referenceRegistry(t))
+	require.NoError(t, err)
+	failures, err := Check(c, 1, 1)
+	require.NoError(t, err)
+	require.Len(t, failures, 9+2)
+	require.Equal(t, Failure{
+		Test:    "TestAllTypesProto2.1.ProtobufInput.ProtobufOutput",
+		Problem: `skipped "unknown message protobuf_test_messages.proto2.TestAllTypesProto2"`,
+	}, failures[0])
+	require.Equal(t, "TestAllTypesProto2.ProtobufInput.Truncated", failures[9].Test)
+	require.Equal(t, "TestAllTypesProto2.TextFormatInput.UnknownField", failures[10].Test)
+}
+
+func TestNewTestee(t *testing.T) {
+	result, err := compiler.Build([]string{"test_messages_proto3.proto"}, []string{"../testdata/conformance"}, true)
+	require.NoError(t, err)
+	_, err = NewTestee(result.Registry())
+	require.EqualError(t, err, "unknown message conformance.ConformanceRequest")
+}
+
+func TestTesteeCommand(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds the testee")
+	}
+	bin := filepath.Join(t.TempDir(), "conformance-testee")
+	out, err := exec.Command("go", "build", "-o", bin, "../cmd/conformance-testee").CombinedOutput()
+	require.NoError(t, err, "%s", out)
+	cmd := exec.Command(bin)
+	cmd.Dir = ".."
+	cmd.Stderr = os.Stderr
+	w, err := cmd.StdinPipe()
+	require.NoError(t, err)
+	r, err := cmd.StdoutPipe()
+	require.NoError(t, err)
+	require.NoError(t, cmd.Start())
+	c, err := NewClient(w, r, referenceRegistry(t))
+	require.NoError(t, err)
+	failures, err := Check(c, 2, 3)
+	require.NoError(t, err)
+	require.Empty(t, failures)
+	require.NoError(t, w.Close())
+	require.NoError(t, cmd.Wait())
+}
+
+// referenceRegistry returns a registry of the conformance protos compiled
+// by protoc.
+func referenceRegistry(t *testing.T) *compiler.Registry {
+	t.Helper()
+	all := &descriptorpb.FileDescriptorSet{}
+	seen := map[string]bool{}
+	for _, file := range []string{"conformance.pb", "test_messages_proto2.pb", "test_messages_proto3.pb"} {
+		b, err := os.ReadFile("../testdata/conformance/pb/" + file)
+		require.NoError(t, err)
+		fds := &descriptorpb.FileDescriptorSet{}
+		require.NoError(t, proto.Unmarshal(b, fds))
+		for _, fd := range fds.File {
+			if !seen[fd.GetName()] {
+				seen[fd.GetName()] = true
+				all.File = append(all.File, fd)
+			}
+		}
+	}
+	reg, err := compiler.NewRegistry(all)
+	require.NoError(t, err)
+	return reg
+}
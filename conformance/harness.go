@@ -0,0 +1,274 @@
+package conformance
+
+import (
+	"fmt"
+	"io"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+
+	"github.com/alecthomas/protobuf/compiler"
+	"github.com/alecthomas/protobuf/gen"
+)
+
+// testMessages are the messages of the requests of Check.
+var testMessages = []protoreflect.FullName{
+	"protobuf_test_messages.proto3.TestAllTypesProto3",
+	"protobuf_test_messages.proto2.TestAllTypesProto2",
+}
+
+// Client sends conformance requests to a testee.
+type Client struct {
+	w        io.Writer
+	r        io.Reader
+	reg      *compiler.Registry
+	request  protoreflect.MessageType
+	response protoreflect.MessageType
+}
+
+// NewClient returns a Client writing requests to w and reading responses
+// from r, with the types of reg, which must declare the messages of
+// conformance.proto as well as the test messages.
+func NewClient(w io.Writer, r io.Reader, reg *compiler.Registry) (*Client, error) {
+	c := &Client{w: w, r: r, reg: reg}
+	var err error
+	if c.request, err = findMessage(reg, requestName); err != nil {
+		return nil, err
+	}
+	if c.response, err = findMessage(reg, responseName); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Call sends req, a conformance.ConformanceRequest of the registry of c, and
+// returns the response.
+func (c *Client) Call(req protoreflect.Message) (protoreflect.Message, error) {
+	if err := writeMessage(c.w, req.Interface()); err != nil {
+		return nil, err
+	}
+	res := c.response.New()
+	if err := readMessage(c.r, res.Interface()); err != nil {
+		return nil, fmt.Errorf("no response: %w", err)
+	}
+	return res, nil
+}
+
+// Failure is a request to which a testee did not respond as expected.
+type Failure struct {
+	// Test names the request, such as
+	// "TestAllTypesProto3.1.ProtobufInput.JsonOutput".
+	Test    string
+	Problem string
+}
+
+func (f Failure) String() string {
+	return f.Test + ": " + f.Problem
+}
+
+// format is a format of payloads in requests and responses.
+type format struct {
+	name       string
+	wireFormat protoreflect.Name
+	category   protoreflect.Name
+	// payload is the field of the payload in requests and responses.
+	payload   protoreflect.Name
+	marshal   func(*compiler.Registry, proto.Message) ([]byte, error)
+	unmarshal func(*compiler.Registry, []byte, proto.Message) error
+}
+
+var formats = []format{
+	{
+		name: "Protobuf", wireFormat: "PROTOBUF", category: "BINARY_TEST", payload: "protobuf_payload",
+		marshal: func(_ *compiler.Registry, m proto.Message) ([]byte, error) {
+			return proto.Marshal(m)
+		},
+		unmarshal: func(reg *compiler.Registry, b []byte, m proto.Message) error {
+			return proto.UnmarshalOptions{Resolver: reg}.Unmarshal(b, m)
+		},
+	},
+	{
+		name: "Json", wireFormat: "JSON", category: "JSON_TEST", payload: "json_payload",
+		marshal: func(reg *compiler.Registry, m proto.Message) ([]byte, error) {
+			return protojson.MarshalOptions{Resolver: reg}.Marshal(m)
+		},
+		unmarshal: func(reg *compiler.Registry, b []byte, m proto.Message) error {
+			return protojson.UnmarshalOptions{Resolver: reg}.Unmarshal(b, m)
+		},
+	},
+	{
+		name: "TextFormat", wireFormat: "TEXT_FORMAT", category: "TEXT_FORMAT_TEST", payload: "text_payload",
+		marshal: func(reg *compiler.Registry, m proto.Message) ([]byte, error) {
+			return prototext.MarshalOptions{Resolver: reg}.Marshal(m)
+		},
+		unmarshal: func(reg *compiler.Registry, b []byte, m proto.Message) error {
+			return prototext.UnmarshalOptions{Resolver: reg}.Unmarshal(b, m)
+		},
+	},
+}
+
+// invalid is a request with a payload that a testee must respond to with
+// a result other than a payload.
+type invalid struct {
+	name    string
+	message protoreflect.FullName
+	payload protoreflect.Name
+	b       string
+	// result is the expected result of the response.
+	result protoreflect.Name
+}
+
+var invalids = []invalid{
+	{name: "TestAllTypesProto3.ProtobufInput.Truncated", message: testMessages[0], payload: "protobuf_payload", b: "\x08", result: "parse_error"},
+	{name: "TestAllTypesProto3.ProtobufInput.InvalidUTF8", message: testMessages[0], payload: "protobuf_payload", b: "\x72\x01\xff", result: "parse_error"},
+	{name: "TestAllTypesProto3.JsonInput.WrongType", message: testMessages[0], payload: "json_payload", b: `{"optionalInt32": true}`, result: "parse_error"},
+	{name: "TestAllTypesProto3.JsonInput.UnknownField", message: testMessages[0], payload: "json_payload", b: `{"unknownField": 1}`, result: "parse_error"},
+	{name: "TestAllTypesProto3.TextFormatInput.WrongType", message: testMessages[0], payload: "text_payload", b: `optional_int32: "x"`, result: "parse_error"},
+	{name: "TestAllTypesProto2.ProtobufInput.Truncated", message: testMessages[1], payload: "protobuf_payload", b: "\x08", result: "parse_error"},
+	{name: "TestAllTypesProto2.TextFormatInput.UnknownField", message: testMessages[1], payload: "text_payload", b: `unknown_field: 1`, result: "parse_error"},
+	{name: "UnknownMessage", message: "conformance.Unknown", payload: "protobuf_payload", result: "skipped"},
+	{name: "TestAllTypesProto3.JspbInput", message: testMessages[0], payload: "jspb_payload", b: "[]", result: "skipped"},
+}
+
+// Check sends n random test messages of each type in each of the binary,
+// JSON and text formats to the testee of c, requesting each format in
+// return, and returns the responses that do not parse with the types of
+// the registry of c to a message equal to the one sent. It also checks the
+// responses to invalid payloads and to the request of the failure set.
+// The messages are the same for the same seed.
+func Check(c *Client, seed int64, n int) ([]Failure, error) {
+	var failures []Failure
+	fail := func(test, format string, args ...interface{}) {
+		failures = append(failures, Failure{Test: test, Problem: fmt.Sprintf(format, args...)})
+	}
+	g := gen.New(seed)
+	for _, name := range testMessages {
+		mt, err := findMessage(c.reg, name)
+		if err != nil {
+			return nil, err
+		}
+		for i := 1; i <= n; i++ {
+			m, err := g.Message(mt.Descriptor())
+			if err != nil {
+				return nil, err
+			}
+			for _, input := range formats {
+				b, err := input.marshal(c.reg, m)
+				if err != nil {
+					return nil, err
+				}
+				for _, output := range formats {
+					test := fmt.Sprintf("%s.%d.%sInput.%sOutput", name.Name(), i, input.name, output.name)
+					req := c.newRequest(name, output.wireFormat)
+					setOneof(req, input.payload, b)
+					setEnum(req, "test_category", input.category)
+					res, err := c.Call(req)
+					if err != nil {
+						return nil, err
+					}
+					got, ok := payload(res, output.payload)
+					if !ok {
+						fail(test, "%s", describe(res))
+						continue
+					}
+					parsed := mt.New().Interface()
+					if err := output.unmarshal(c.reg, got, parsed); err != nil {
+						fail(test, "%s: %q", err, got)
+						continue
+					}
+					if !proto.Equal(m, parsed) {
+						fail(test, "%q does not match the input %q", got, b)
+					}
+				}
+			}
+		}
+	}
+	for _, inv := range invalids {
+		req := c.newRequest(inv.message, "PROTOBUF")
+		setOneof(req, inv.payload, []byte(inv.b))
+		res, err := c.Call(req)
+		if err != nil {
+			return nil, err
+		}
+		if fd := res.WhichOneof(res.Descriptor().Oneofs().ByName("result")); fd == nil || fd.Name() != inv.result {
+			fail(inv.name, "expected %s, got %s", inv.result, describe(res))
+		}
+	}
+	failures = append(failures, c.checkIgnoreUnknown()...)
+	failures = append(failures, c.checkFailureSet()...)
+	return failures, nil
+}
+
+// checkIgnoreUnknown checks that unknown JSON fields are discarded by tests
+// of the category JSON_IGNORE_UNKNOWN_PARSING_TEST.
+func (c *Client) checkIgnoreUnknown() []Failure {
+	const test = "TestAllTypesProto3.JsonInput.IgnoreUnknownField"
+	req := c.newRequest(testMessages[0], "PROTOBUF")
+	setOneof(req, "json_payload", []byte(`{"optionalInt32": 1, "unknownField": 2}`))
+	setEnum(req, "test_category", "JSON_IGNORE_UNKNOWN_PARSING_TEST")
+	res, err := c.Call(req)
+	if err != nil {
+		return []Failure{{Test: test, Problem: err.Error()}}
+	}
+	if got, ok := payload(res, "protobuf_payload"); !ok || string(got) != "\x08\x01" {
+		return []Failure{{Test: test, Problem: fmt.Sprintf("expected %q, got %s", "\x08\x01", describe(res))}}
+	}
+	return nil
+}
+
+// checkFailureSet checks that the testee responds to the request of the
+// failure set with a failure set.
+func (c *Client) checkFailureSet() []Failure {
+	const test = "FailureSet"
+	mt, err := findMessage(c.reg, failureSetName)
+	if err != nil {
+		return []Failure{{Test: test, Problem: err.Error()}}
+	}
+	res, err := c.Call(c.newRequest(failureSetName, "PROTOBUF"))
+	if err != nil {
+		return []Failure{{Test: test, Problem: err.Error()}}
+	}
+	got, ok := payload(res, "protobuf_payload")
+	if !ok {
+		return []Failure{{Test: test, Problem: describe(res)}}
+	}
+	if err := proto.Unmarshal(got, mt.New().Interface()); err != nil {
+		return []Failure{{Test: test, Problem: err.Error()}}
+	}
+	return nil
+}
+
+// newRequest returns a request for a message of the type message in the
+// output format.
+func (c *Client) newRequest(message protoreflect.FullName, output protoreflect.Name) protoreflect.Message {
+	req := c.request.New()
+	req.Set(req.Descriptor().Fields().ByName("message_type"), protoreflect.ValueOfString(string(message)))
+	setEnum(req, "requested_output_format", output)
+	return req
+}
+
+// payload returns the payload of res if its result is the field name.
+func payload(res protoreflect.Message, name protoreflect.Name) ([]byte, bool) {
+	fd := res.WhichOneof(res.Descriptor().Oneofs().ByName("result"))
+	if fd == nil || fd.Name() != name {
+		return nil, false
+	}
+	return oneofBytes(res, fd), true
+}
+
+// describe describes the result of res.
+func describe(res protoreflect.Message) string {
+	fd := res.WhichOneof(res.Descriptor().Oneofs().ByName("result"))
+	if fd == nil {
+		return "no result"
+	}
+	return fmt.Sprintf("%s %q", fd.Name(), oneofBytes(res, fd))
+}
+
+// setEnum sets the enum field name of m to the value named value.
+func setEnum(m protoreflect.Message, name, value protoreflect.Name) {
+	fd := m.Descriptor().Fields().ByName(name)
+	m.Set(fd, protoreflect.ValueOfEnum(fd.Enum().Values().ByName(value).Number()))
+}
@@ -0,0 +1,120 @@
+package conformance
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+
+	"github.com/alecthomas/protobuf/compiler"
+)
+
+// Testee responds to conformance requests by converting payloads between
+// formats with dynamic messages of the types of a registry.
+type Testee struct {
+	reg        *compiler.Registry
+	request    protoreflect.MessageType
+	response   protoreflect.MessageType
+	failureSet protoreflect.MessageType
+}
+
+// NewTestee returns a Testee of the types of reg, which must declare the
+// messages of conformance.proto as well as the test messages.
+func NewTestee(reg *compiler.Registry) (*Testee, error) {
+	t := &Testee{reg: reg}
+	var err error
+	if t.request, err = findMessage(reg, requestName); err != nil {
+		return nil, err
+	}
+	if t.response, err = findMessage(reg, responseName); err != nil {
+		return nil, err
+	}
+	if t.failureSet, err = findMessage(reg, failureSetName); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Serve reads requests from r and writes a response to each of them to w
+// until r ends.
+func (t *Testee) Serve(r io.Reader, w io.Writer) error {
+	for {
+		req := t.request.New()
+		if err := readMessage(r, req.Interface()); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		res := t.response.New()
+		name, v := t.handle(req)
+		res.Set(res.Descriptor().Fields().ByName(name), v)
+		if err := writeMessage(w, res.Interface()); err != nil {
+			return err
+		}
+	}
+}
+
+// handle returns the field of the result of the response to req and its
+// value.
+func (t *Testee) handle(req protoreflect.Message) (protoreflect.Name, protoreflect.Value) {
+	typeName := protoreflect.FullName(field(req, "message_type").String())
+	if typeName == failureSetName {
+		// No test is expected to fail.
+		b, err := proto.Marshal(t.failureSet.New().Interface())
+		if err != nil {
+			return "runtime_error", protoreflect.ValueOfString(err.Error())
+		}
+		return "protobuf_payload", protoreflect.ValueOfBytes(b)
+	}
+	mt, err := t.reg.FindMessageByName(typeName)
+	if err != nil {
+		return "skipped", protoreflect.ValueOfString(fmt.Sprintf("unknown message %s", typeName))
+	}
+	m := mt.New().Interface()
+	payload := req.WhichOneof(req.Descriptor().Oneofs().ByName("payload"))
+	if payload == nil {
+		return "runtime_error", protoreflect.ValueOfString("missing payload")
+	}
+	b := oneofBytes(req, payload)
+	switch payload.Name() {
+	case "protobuf_payload":
+		err = proto.UnmarshalOptions{Resolver: t.reg}.Unmarshal(b, m)
+	case "json_payload":
+		ignoreUnknown := enumName(req, "test_category") == "JSON_IGNORE_UNKNOWN_PARSING_TEST"
+		err = protojson.UnmarshalOptions{Resolver: t.reg, DiscardUnknown: ignoreUnknown}.Unmarshal(b, m)
+	case "text_payload":
+		err = prototext.UnmarshalOptions{Resolver: t.reg}.Unmarshal(b, m)
+	default:
+		return "skipped", protoreflect.ValueOfString(fmt.Sprintf("%s is not supported", payload.Name()))
+	}
+	if err != nil {
+		return "parse_error", protoreflect.ValueOfString(err.Error())
+	}
+	var marshal func(proto.Message) ([]byte, error)
+	var result protoreflect.Name
+	switch output := enumName(req, "requested_output_format"); output {
+	case "PROTOBUF":
+		marshal, result = proto.Marshal, "protobuf_payload"
+	case "JSON":
+		marshal, result = protojson.MarshalOptions{Resolver: t.reg}.Marshal, "json_payload"
+	case "TEXT_FORMAT":
+		emitUnknown := field(req, "print_unknown_fields").Bool()
+		marshal, result = prototext.MarshalOptions{Resolver: t.reg, EmitUnknown: emitUnknown}.Marshal, "text_payload"
+	case "JSPB":
+		return "skipped", protoreflect.ValueOfString("JSPB is not supported")
+	default:
+		return "runtime_error", protoreflect.ValueOfString(fmt.Sprintf("unknown output format %s", output))
+	}
+	if b, err = marshal(m); err != nil {
+		return "serialize_error", protoreflect.ValueOfString(err.Error())
+	}
+	if result == "protobuf_payload" {
+		return result, protoreflect.ValueOfBytes(b)
+	}
+	return result, protoreflect.ValueOfString(string(b))
+}
@@ -0,0 +1,528 @@
+// Package edit modifies protobuf source files.
+//
+// Edits are made to the concrete syntax tree of a file and produce the
+// minimal text edits against the original source, so the formatting and
+// comments of everything that is not edited are preserved. Edits are
+// not reflected in the AST of a file: each edit refers to nodes of the
+// source as it was parsed, and edits of a file must not overlap.
+package edit
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/alecthomas/participle/v2/lexer"
+
+	"github.com/alecthomas/protobuf/parser"
+)
+
+// File is a protobuf source file being edited.
+type File struct {
+	// Name of the file, relative to its import path.
+	Name string
+	// Path of the file on disk, if it was loaded from disk.
+	Path string
+
+	cst     *parser.CST
+	parents map[parser.Node]parser.Node
+}
+
+// Parse protobuf source for editing.
+func Parse(name string, r io.Reader) (*File, error) {
+	cst, err := parser.ParseCST(name, r)
+	if err != nil {
+		return nil, err
+	}
+	f := &File{Name: name, cst: cst, parents: map[parser.Node]parser.Node{}}
+	var stack []parser.Node
+	err = parser.Visit(cst.Proto, func(node parser.Node, next func() error) error {
+		if len(stack) > 0 {
+			f.parents[node] = stack[len(stack)-1]
+		}
+		stack = append(stack, node)
+		err := next()
+		stack = stack[:len(stack)-1]
+		return err
+	})
+	return f, err
+}
+
+// Proto returns the AST of the source as it was parsed.
+func (f *File) Proto() *parser.Proto { return f.cst.Proto }
+
+// String returns the source with all edits applied.
+func (f *File) String() string { return f.cst.String() }
+
+// Edits returns the text edits made to the original source.
+func (f *File) Edits() []parser.TextEdit { return f.cst.TextEdits() }
+
+// Modified returns true if any edits have been made to f.
+func (f *File) Modified() bool { return len(f.cst.TextEdits()) > 0 }
+
+// InsertField inserts a field, such as "string name = 2;", at the end of
+// the body of a *parser.Message, *parser.Group, *parser.OneOf or
+// *parser.Extend.
+func (f *File) InsertField(parent parser.Node, field string) error {
+	switch parent.(type) {
+	case *parser.Message, *parser.Group, *parser.OneOf, *parser.Extend:
+	default:
+		return fmt.Errorf("cannot insert a field into %T", parent)
+	}
+	field = strings.TrimSpace(field)
+	if !strings.HasSuffix(field, ";") {
+		field += ";"
+	}
+	if _, err := parser.ParseString("field.proto", "message M {\n"+field+"\n}"); err != nil {
+		return fmt.Errorf("invalid field %q: %w", field, err)
+	}
+	open, closing, err := f.body(parent)
+	if err != nil {
+		return err
+	}
+	return f.insertAtEnd(open, closing, field)
+}
+
+// InsertReserved inserts a reserved statement, such as
+// "reserved 4, 8 to 10;" or `reserved "name";`, into a *parser.Message,
+// *parser.Group or *parser.Enum: after its last reserved statement, or
+// else at the start of its body after its options.
+func (f *File) InsertReserved(parent parser.Node, reserved string) error {
+	reserved = strings.TrimSpace(reserved)
+	if !strings.HasSuffix(reserved, ";") {
+		reserved += ";"
+	}
+	if _, err := parser.ParseString("reserved.proto", "message M {\n"+reserved+"\n}"); err != nil {
+		return fmt.Errorf("invalid reserved statement %q: %w", reserved, err)
+	}
+	var after parser.Node
+	switch parent := parent.(type) {
+	case *parser.Message:
+		after = lastReservedOrOption(parent.Entries)
+	case *parser.Group:
+		after = lastReservedOrOption(parent.Entries)
+	case *parser.Enum:
+		var option parser.Node
+		for _, e := range parent.Values {
+			switch {
+			case e.Reserved != nil:
+				after = e
+			case e.Option != nil:
+				option = e
+			}
+		}
+		if after == nil {
+			after = option
+		}
+	default:
+		return fmt.Errorf("cannot insert a reserved statement into %T", parent)
+	}
+	if after != nil {
+		s, err := f.span(after)
+		if err != nil {
+			return err
+		}
+		return f.cst.Replace(s.Stop, s.Stop, f.indentation(s.Begin)+reserved+"\n")
+	}
+	open, closing, err := f.body(parent)
+	if err != nil {
+		return err
+	}
+	return f.insertAtStart(open, closing, reserved)
+}
+
+// lastReservedOrOption returns the last reserved statement of a message,
+// or else its last option statement, or nil.
+func lastReservedOrOption(entries []*parser.MessageEntry) parser.Node {
+	var reserved, option parser.Node
+	for _, e := range entries {
+		switch {
+		case e.Reserved != nil:
+			reserved = e
+		case e.Option != nil:
+			option = e
+		}
+	}
+	if reserved != nil {
+		return reserved
+	}
+	return option
+}
+
+// RemoveEntry removes the declaration or statement enclosing node, along
+// with its comments. Options in brackets, such as "[deprecated = true]",
+// are removed from the option list, and the brackets with them if the
+// option is the last one.
+func (f *File) RemoveEntry(node parser.Node) error {
+	if opt, ok := node.(*parser.Option); ok {
+		switch parent := f.parents[opt].(type) {
+		case *parser.Direct:
+			return f.removeCompactOption(parent.Options, opt)
+		case *parser.EnumValue:
+			return f.removeCompactOption(parent.Options, opt)
+		}
+	}
+	for n := node; n != nil; n = f.parents[n] {
+		if f.isEntry(n) {
+			return f.cst.RemoveNode(n)
+		}
+	}
+	return fmt.Errorf("%T is not within an entry", node)
+}
+
+// isEntry returns true if node is a statement or declaration within the
+// body of a file, message, enum, service, method, oneof or extend.
+func (f *File) isEntry(node parser.Node) bool {
+	switch node.(type) {
+	case *parser.Entry, *parser.MessageEntry, *parser.EnumEntry, *parser.ServiceEntry, *parser.MethodEntry, *parser.OneOfEntry:
+		return true
+	case *parser.Field:
+		_, ok := f.parents[node].(*parser.Extend)
+		return ok
+	}
+	return false
+}
+
+func (f *File) removeCompactOption(opts parser.Options, opt *parser.Option) error {
+	i := 0
+	for i < len(opts) && opts[i] != opt {
+		i++
+	}
+	if i == len(opts) {
+		return fmt.Errorf("option %s not found", optionName(opt))
+	}
+	s, err := f.span(opt)
+	if err != nil {
+		return err
+	}
+	switch {
+	case len(opts) == 1:
+		// Remove the brackets and the whitespace preceding them.
+		start := f.prevToken(s.Begin, "[")
+		for start > 0 && parser.IsTrivia(f.cst.Tokens[start-1]) && !endsLine(f.cst.Tokens[start-1]) {
+			start--
+		}
+		end := f.nextToken(s.End, "]")
+		return f.cst.Replace(start, end+1, "")
+	case i < len(opts)-1:
+		next, err := f.span(opts[i+1])
+		if err != nil {
+			return err
+		}
+		return f.cst.Replace(s.Begin, next.Begin, "")
+	default:
+		prev, err := f.span(opts[i-1])
+		if err != nil {
+			return err
+		}
+		return f.cst.Replace(prev.End, s.End, "")
+	}
+}
+
+// SetOption sets an option of a declaration to value, which is written
+// as is, such as `true`, `"value"` or `{ key: 1 }`. The value of an
+// existing option is replaced. Options of a *parser.Proto,
+// *parser.Message, *parser.Enum, *parser.Service, *parser.Method or
+// *parser.OneOf are set with an option statement, those of a
+// *parser.Field or *parser.EnumValue in brackets.
+func (f *File) SetOption(node parser.Node, name, value string) error {
+	name = strings.Join(strings.Fields(name), "")
+	value = strings.TrimSpace(value)
+	statement := "option " + name + " = " + value + ";"
+	if _, err := parser.ParseString("option.proto", statement); err != nil {
+		return fmt.Errorf("invalid option %s = %s: %w", name, value, err)
+	}
+	switch node := node.(type) {
+	case *parser.Field:
+		if node.Direct == nil {
+			return fmt.Errorf("cannot set options of a group field")
+		}
+		return f.setCompactOption(node.Direct, node.Direct.Options, name, value)
+	case *parser.Direct:
+		return f.setCompactOption(node, node.Options, name, value)
+	case *parser.EnumValue:
+		return f.setCompactOption(node, node.Options, name, value)
+	case *parser.Proto:
+		return f.setFileOption(node, name, value, statement)
+	}
+	opts, entries, err := statementOptions(node)
+	if err != nil {
+		return err
+	}
+	for _, opt := range opts {
+		if optionName(opt) == name {
+			return f.cst.ReplaceNode(opt.Value, value)
+		}
+	}
+	if len(entries) > 0 {
+		last := entries[len(entries)-1]
+		s, err := f.span(last)
+		if err != nil {
+			return err
+		}
+		return f.cst.Replace(s.Stop, s.Stop, f.indentation(s.Begin)+statement+"\n")
+	}
+	if method, ok := node.(*parser.Method); ok && !method.HasEntries {
+		return f.addMethodBody(method, statement)
+	}
+	open, closing, err := f.body(node)
+	if err != nil {
+		return err
+	}
+	return f.insertAtStart(open, closing, statement)
+}
+
+// statementOptions returns the option statements of a declaration and
+// the entries containing them.
+func statementOptions(node parser.Node) (opts []*parser.Option, entries []parser.Node, err error) {
+	add := func(opt *parser.Option, entry parser.Node) {
+		if opt != nil {
+			opts = append(opts, opt)
+			entries = append(entries, entry)
+		}
+	}
+	switch node := node.(type) {
+	case *parser.Message:
+		for _, e := range node.Entries {
+			add(e.Option, e)
+		}
+	case *parser.Group:
+		for _, e := range node.Entries {
+			add(e.Option, e)
+		}
+	case *parser.Enum:
+		for _, e := range node.Values {
+			add(e.Option, e)
+		}
+	case *parser.Service:
+		for _, e := range node.Entries {
+			add(e.Option, e)
+		}
+	case *parser.Method:
+		for _, e := range node.Entries {
+			add(e.Option, e)
+		}
+	case *parser.OneOf:
+		for _, e := range node.Entries {
+			add(e.Option, e)
+		}
+	default:
+		return nil, nil, fmt.Errorf("cannot set options of %T", node)
+	}
+	return opts, entries, nil
+}
+
+func (f *File) setFileOption(proto *parser.Proto, name, value, statement string) error {
+	var last *parser.Entry
+	for _, e := range proto.Entries {
+		if e.Option != nil && optionName(e.Option) == name {
+			return f.cst.ReplaceNode(e.Option.Value, value)
+		}
+		if e.Option != nil || e.Package != "" || e.Import != nil {
+			last = e
+		}
+	}
+	if last == nil {
+		// Insert after the syntax statement, if any.
+		i := 0
+		if proto.Syntax != "" {
+			i = f.nextToken(0, ";") + 1
+			i = f.lineEnd(i)
+		}
+		return f.cst.Replace(i, i, statement+"\n")
+	}
+	s, err := f.span(last)
+	if err != nil {
+		return err
+	}
+	if last.Option == nil {
+		// Separate the first option from the package and imports.
+		statement = "\n" + statement
+	}
+	return f.cst.Replace(s.Stop, s.Stop, statement+"\n")
+}
+
+func (f *File) setCompactOption(node parser.Node, opts parser.Options, name, value string) error {
+	for _, opt := range opts {
+		if optionName(opt) == name {
+			return f.cst.ReplaceNode(opt.Value, value)
+		}
+	}
+	if len(opts) > 0 {
+		s, err := f.span(opts[len(opts)-1])
+		if err != nil {
+			return err
+		}
+		return f.cst.Replace(s.End, s.End, ", "+name+" = "+value)
+	}
+	s, err := f.span(node)
+	if err != nil {
+		return err
+	}
+	end := s.End
+	if f.cst.Tokens[end-1].Value == ";" {
+		end--
+		for end > s.Begin && parser.IsTrivia(f.cst.Tokens[end-1]) {
+			end--
+		}
+	}
+	return f.cst.Replace(end, end, " ["+name+" = "+value+"]")
+}
+
+// addMethodBody replaces the ";" terminating a method without a body
+// with a body containing statement.
+func (f *File) addMethodBody(method *parser.Method, statement string) error {
+	s, err := f.span(method)
+	if err != nil {
+		return err
+	}
+	end := s.End
+	if entry, ok := f.parents[method].(*parser.ServiceEntry); ok {
+		if es, err := f.span(entry); err == nil {
+			end = es.End
+		}
+	}
+	indent := f.indentation(s.Begin)
+	return f.cst.Replace(s.End, end, " {\n"+indent+"  "+statement+"\n"+indent+"}")
+}
+
+// optionName returns the name of an option as it would be written
+// without whitespace, such as "(foo.bar).baz".
+func optionName(opt *parser.Option) string {
+	parts := make([]string, len(opt.Name))
+	for i, n := range opt.Name {
+		parts[i] = n.Name
+	}
+	name := strings.Join(parts, ".")
+	if opt.Attr != nil {
+		name += *opt.Attr
+	}
+	return name
+}
+
+// body returns the indices of the tokens "{" and "}" enclosing the body
+// of node.
+func (f *File) body(node parser.Node) (open, closing int, err error) {
+	s, err := f.span(node)
+	if err != nil {
+		return 0, 0, err
+	}
+	depth := 0
+	for i := s.Begin; i < s.End; i++ {
+		switch f.cst.Tokens[i].Value {
+		case "[":
+			depth++
+		case "]":
+			depth--
+		case "{":
+			if depth == 0 && f.cst.Tokens[s.End-1].Value == "}" {
+				return i, s.End - 1, nil
+			}
+		}
+	}
+	return 0, 0, fmt.Errorf("%T has no body", node)
+}
+
+// insertAtStart inserts statement at the start of the body enclosed by
+// the tokens open and closing.
+func (f *File) insertAtStart(open, closing int, statement string) error {
+	i := open + 1
+	for ; i < closing && parser.IsTrivia(f.cst.Tokens[i]); i++ {
+		if endsLine(f.cst.Tokens[i]) {
+			return f.cst.Replace(i+1, i+1, f.bodyIndentation(open, closing)+statement+"\n")
+		}
+	}
+	if open+1 == closing {
+		statement += " "
+	}
+	return f.cst.Replace(open+1, open+1, " "+statement)
+}
+
+// insertAtEnd inserts statement at the end of the body enclosed by the
+// tokens open and closing.
+func (f *File) insertAtEnd(open, closing int, statement string) error {
+	start := f.lineStart(closing)
+	if start > open && f.onlyTrivia(start, closing) {
+		return f.cst.Replace(start, start, f.bodyIndentation(open, closing)+statement+"\n")
+	}
+	if parser.IsTrivia(f.cst.Tokens[closing-1]) {
+		return f.cst.Replace(closing, closing, statement+" ")
+	}
+	return f.cst.Replace(closing, closing, " "+statement+" ")
+}
+
+// bodyIndentation returns the indentation of the entries of a body,
+// that of its first entry or else two spaces more than its opening line.
+func (f *File) bodyIndentation(open, closing int) string {
+	i := open + 1
+	for i < closing && parser.IsTrivia(f.cst.Tokens[i]) && !parser.IsComment(f.cst.Tokens[i]) {
+		i++
+	}
+	if i < closing && f.onlyTrivia(f.lineStart(i), i) && f.lineStart(i) > open {
+		return f.indentation(i)
+	}
+	return f.indentation(open) + "  "
+}
+
+// indentation returns the whitespace at the start of the line of token i.
+func (f *File) indentation(i int) string {
+	start := f.lineStart(i)
+	if t := f.cst.Tokens[start]; start < i && parser.IsTrivia(t) && !parser.IsComment(t) {
+		return t.Value
+	}
+	return ""
+}
+
+// lineStart returns the index of the first token of the line of token i.
+func (f *File) lineStart(i int) int {
+	for i > 0 && !endsLine(f.cst.Tokens[i-1]) {
+		i--
+	}
+	return i
+}
+
+// lineEnd returns the index after the token ending the line of token i,
+// if the rest of the line is trivia.
+func (f *File) lineEnd(i int) int {
+	for j := i; j < len(f.cst.Tokens) && parser.IsTrivia(f.cst.Tokens[j]); j++ {
+		if endsLine(f.cst.Tokens[j]) {
+			return j + 1
+		}
+	}
+	return i
+}
+
+func (f *File) onlyTrivia(start, end int) bool {
+	for _, t := range f.cst.Tokens[start:end] {
+		if !parser.IsTrivia(t) {
+			return false
+		}
+	}
+	return true
+}
+
+// prevToken returns the index of the last token with value before i.
+func (f *File) prevToken(i int, value string) int {
+	for i--; i > 0 && f.cst.Tokens[i].Value != value; i-- {
+	}
+	return i
+}
+
+// nextToken returns the index of the first token with value at or after i.
+func (f *File) nextToken(i int, value string) int {
+	for ; i < len(f.cst.Tokens)-1 && f.cst.Tokens[i].Value != value; i++ {
+	}
+	return i
+}
+
+func (f *File) span(node parser.Node) (parser.Span, error) {
+	s, ok := f.cst.Span(node)
+	if !ok {
+		return parser.Span{}, fmt.Errorf("%T has no span", node)
+	}
+	return s, nil
+}
+
+func endsLine(t lexer.Token) bool {
+	return strings.HasSuffix(t.Value, "\n")
+}
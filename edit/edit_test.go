@@ -0,0 +1,343 @@
+package edit
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/alecthomas/protobuf/compiler"
+	"github.com/alecthomas/protobuf/parser"
+)
+
+const source = `syntax = "proto3";
+
+package test;
+
+// A message.
+message Msg {
+  option deprecated = true;
+
+  // The name.
+  string name = 1; // Trailing.
+  int32 count = 2 [deprecated = true, json_name = "n"];
+  oneof kind {
+    string text = 3;
+  }
+}
+
+message Empty {}
+
+enum Kind {
+  KIND_UNSPECIFIED = 0;
+  KIND_OTHER = 1 [deprecated = true];
+}
+
+service Svc {
+  rpc Get(Msg) returns (Msg);
+}
+`
+
+func parse(t *testing.T) (*File, *parser.Proto) {
+	t.Helper()
+	f, err := Parse("test.proto", strings.NewReader(source))
+	require.NoError(t, err)
+	return f, f.Proto()
+}
+
+func TestInsertField(t *testing.T) {
+	f, proto := parse(t)
+	msg, empty := proto.Entries[1].Message, proto.Entries[2].Message
+	require.NoError(t, f.InsertField(msg, "bool ok = 4"))
+	require.NoError(t, f.InsertField(msg.Entries[4].Oneof, "int64 id = 5;"))
+	require.NoError(t, f.InsertField(empty, "string name = 1;"))
+	require.Error(t, f.InsertField(msg, "not a field"))
+	require.Error(t, f.InsertField(proto.Entries[3].Enum, "string name = 1;"))
+	require.Equal(t, `syntax = "proto3";
+
+package test;
+
+// A message.
+message Msg {
+  option deprecated = true;
+
+  // The name.
+  string name = 1; // Trailing.
+  int32 count = 2 [deprecated = true, json_name = "n"];
+  oneof kind {
+    string text = 3;
+    int64 id = 5;
+  }
+  bool ok = 4;
+}
+
+message Empty { string name = 1; }
+
+enum Kind {
+  KIND_UNSPECIFIED = 0;
+  KIND_OTHER = 1 [deprecated = true];
+}
+
+service Svc {
+  rpc Get(Msg) returns (Msg);
+}
+`, f.String())
+}
+
+func TestInsertReserved(t *testing.T) {
+	f, proto := parse(t)
+	msg, empty, enum := proto.Entries[1].Message, proto.Entries[2].Message, proto.Entries[3].Enum
+	require.NoError(t, f.InsertReserved(msg, "reserved 4, 6 to 8"))
+	require.NoError(t, f.InsertReserved(msg, `reserved "old";`))
+	require.NoError(t, f.InsertReserved(empty, "reserved 1;"))
+	require.NoError(t, f.InsertReserved(enum, "reserved 2;"))
+	require.Error(t, f.InsertReserved(msg, "reserved foo;"))
+	require.Equal(t, `syntax = "proto3";
+
+package test;
+
+// A message.
+message Msg {
+  option deprecated = true;
+  reserved 4, 6 to 8;
+  reserved "old";
+
+  // The name.
+  string name = 1; // Trailing.
+  int32 count = 2 [deprecated = true, json_name = "n"];
+  oneof kind {
+    string text = 3;
+  }
+}
+
+message Empty { reserved 1; }
+
+enum Kind {
+  reserved 2;
+  KIND_UNSPECIFIED = 0;
+  KIND_OTHER = 1 [deprecated = true];
+}
+
+service Svc {
+  rpc Get(Msg) returns (Msg);
+}
+`, f.String())
+}
+
+func TestRemoveEntry(t *testing.T) {
+	f, proto := parse(t)
+	msg, enum := proto.Entries[1].Message, proto.Entries[3].Enum
+	require.NoError(t, f.RemoveEntry(msg.Entries[0].Option))
+	require.NoError(t, f.RemoveEntry(msg.Entries[2].Field.Direct.Type))
+	require.NoError(t, f.RemoveEntry(msg.Entries[3].Field.Direct.Options[0]))
+	require.NoError(t, f.RemoveEntry(enum.Values[1].Value.Options[0]))
+	require.NoError(t, f.RemoveEntry(proto.Entries[4].Service.Entries[0].Method))
+	require.Error(t, f.RemoveEntry(proto))
+	require.Equal(t, `syntax = "proto3";
+
+package test;
+
+// A message.
+message Msg {
+
+  int32 count = 2 [json_name = "n"];
+  oneof kind {
+    string text = 3;
+  }
+}
+
+message Empty {}
+
+enum Kind {
+  KIND_UNSPECIFIED = 0;
+  KIND_OTHER = 1;
+}
+
+service Svc {
+}
+`, f.String())
+}
+
+func TestRemoveLastCompactOption(t *testing.T) {
+	f, proto := parse(t)
+	count := proto.Entries[1].Message.Entries[3].Field
+	require.NoError(t, f.RemoveEntry(count.Direct.Options[1]))
+	require.Contains(t, f.String(), `int32 count = 2 [deprecated = true];`)
+}
+
+func TestSetOption(t *testing.T) {
+	f, proto := parse(t)
+	msg, empty, enum := proto.Entries[1].Message, proto.Entries[2].Message, proto.Entries[3].Enum
+	require.NoError(t, f.SetOption(proto, "go_package", `"example.com/test"`))
+	require.NoError(t, f.SetOption(msg, "deprecated", "false"))
+	require.NoError(t, f.SetOption(msg, "(my.option)", "{ a: 1 }"))
+	require.NoError(t, f.SetOption(msg.Entries[2].Field, "json_name", `"n"`))
+	require.NoError(t, f.SetOption(msg.Entries[3].Field, "json_name", `"c"`))
+	require.NoError(t, f.SetOption(msg.Entries[4].Oneof, "(my.oneof)", "1"))
+	require.NoError(t, f.SetOption(empty, "deprecated", "true"))
+	require.NoError(t, f.SetOption(enum, "allow_alias", "true"))
+	require.NoError(t, f.SetOption(enum.Values[0].Value, "deprecated", "true"))
+	require.NoError(t, f.SetOption(proto.Entries[4].Service.Entries[0].Method, "idempotency_level", "NO_SIDE_EFFECTS"))
+	require.Error(t, f.SetOption(msg, "deprecated", "= true"))
+	require.Error(t, f.SetOption(msg.Entries[2].Field.Direct.Type, "deprecated", "true"))
+	require.Equal(t, `syntax = "proto3";
+
+package test;
+
+option go_package = "example.com/test";
+
+// A message.
+message Msg {
+  option deprecated = false;
+  option (my.option) = { a: 1 };
+
+  // The name.
+  string name = 1 [json_name = "n"]; // Trailing.
+  int32 count = 2 [deprecated = true, json_name = "c"];
+  oneof kind {
+    option (my.oneof) = 1;
+    string text = 3;
+  }
+}
+
+message Empty { option deprecated = true; }
+
+enum Kind {
+  option allow_alias = true;
+  KIND_UNSPECIFIED = 0 [deprecated = true];
+  KIND_OTHER = 1 [deprecated = true];
+}
+
+service Svc {
+  rpc Get(Msg) returns (Msg) {
+    option idempotency_level = NO_SIDE_EFFECTS;
+  }
+}
+`, f.String())
+}
+
+func TestEdits(t *testing.T) {
+	f, proto := parse(t)
+	require.False(t, f.Modified())
+	require.Error(t, f.SetOption(proto.Entries[3].Enum.Values[1].Value.Options[0], "deprecated", "false"))
+	require.NoError(t, f.SetOption(proto.Entries[3].Enum.Values[1].Value, "deprecated", "false"))
+	require.True(t, f.Modified())
+	edits := f.Edits()
+	require.Len(t, edits, 1)
+	require.Equal(t, "true", source[edits[0].Start:edits[0].End])
+	require.Equal(t, "false", edits[0].Text)
+}
+
+func TestRenameType(t *testing.T) {
+	set, err := Load([]string{"service.proto"}, []string{"testdata", "../testdata/conformance"})
+	require.NoError(t, err)
+	require.NoError(t, set.RenameType("pkg.User", "pkg.Account"))
+	require.Error(t, set.RenameType("pkg.Role", "pkg.Account.Role"))
+	require.Error(t, set.RenameType("pkg.Missing", "pkg.Other"))
+	require.Error(t, set.RenameType("pkg.Role", "pkg.User"))
+
+	modified := set.Modified()
+	require.Len(t, modified, 2)
+	require.Equal(t, "pkg/types.proto", modified[0].Name)
+	require.Equal(t, `syntax = "proto3";
+
+package pkg;
+
+import "google/protobuf/descriptor.proto";
+
+// A user.
+message Account {
+  string name = 1;
+
+  message Address {
+    string street = 1;
+  }
+  Address address = 2;
+
+  extend google.protobuf.FieldOptions {
+    string label = 50000;
+  }
+}
+
+enum Role {
+  ROLE_UNSPECIFIED = 0;
+}
+`, modified[0].String())
+	require.Equal(t, "service.proto", modified[1].Name)
+	require.Equal(t, `syntax = "proto3";
+
+package svc;
+
+import "pkg/types.proto";
+
+message GetUserResponse {
+  pkg.Account user = 1;
+  .pkg.Account.Address address = 2 [(pkg.Account.label) = "home"];
+  repeated pkg.Role roles = 3;
+}
+
+service Users {
+  // Get a user.
+  rpc GetUser(pkg.Account) returns (GetUserResponse);
+}
+`, modified[1].String())
+}
+
+func TestRenameNestedType(t *testing.T) {
+	set, err := Load([]string{"service.proto"}, []string{"testdata", "../testdata/conformance"})
+	require.NoError(t, err)
+	require.NoError(t, set.RenameType("pkg.User.Address", "pkg.User.Location"))
+	f, _ := set.Declaration("pkg.User")
+	require.Contains(t, f.String(), "  message Location {\n")
+	require.Contains(t, f.String(), "  Location address = 2;\n")
+	require.Contains(t, set.File("service.proto").String(), ".pkg.User.Location address = 2")
+}
+
+func TestRenameTypeShadowed(t *testing.T) {
+	set, err := Load([]string{"shadow.proto"}, []string{"testdata", "../testdata/conformance"})
+	require.NoError(t, err)
+	require.Error(t, set.RenameType("shadow.Old", "shadow.Holder", Tombstone()))
+	require.NoError(t, set.RenameType("shadow.Old", "shadow.New", Tombstone()))
+	require.Len(t, set.Modified(), 1)
+	require.Equal(t, `syntax = "proto3";
+
+package shadow;
+
+import "google/protobuf/any.proto";
+import "google/protobuf/descriptor.proto";
+
+message New {
+  string name = 1;
+}
+
+// Deprecated: renamed to shadow.New.
+message Old {
+  option deprecated = true;
+  reserved 1 to max;
+}
+
+message Holder {
+  message New {}
+
+  shadow.New old = 1;
+  New inner = 2;
+}
+
+extend google.protobuf.MessageOptions {
+  google.protobuf.Any any = 50001;
+}
+
+message Annotated {
+  option (any) = {
+    [type.googleapis.com/shadow.New] { name: "x" }
+  };
+}
+`, set.File("shadow.proto").String())
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "shadow.proto"), []byte(set.File("shadow.proto").String()), 0o600))
+	_, err = compiler.Compile([]string{"shadow.proto"}, []string{dir, "../testdata/conformance"}, false)
+	require.NoError(t, err)
+}
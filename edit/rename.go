@@ -0,0 +1,396 @@
+package edit
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/alecthomas/participle/v2/lexer"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	pb "google.golang.org/protobuf/types/descriptorpb"
+
+	"github.com/alecthomas/protobuf/parser"
+)
+
+// RenameOption configures RenameType.
+type RenameOption func(*renameConfig)
+
+type renameConfig struct {
+	tombstone bool
+}
+
+// Tombstone leaves a deprecated message with the old name after a
+// renamed message, with all field numbers reserved, so that the old name
+// is not reused for something else.
+func Tombstone() RenameOption {
+	return func(c *renameConfig) { c.tombstone = true }
+}
+
+// RenameType renames the message or enum with the full name from to the
+// full name to, which must be in the same scope, and updates every
+// reference to it or to the declarations nested within it in all files
+// of s: field, method and extend types, extensions in option names and
+// aggregate values, and the type URLs of Any values.
+//
+// References are updated in place where possible. A reference that would
+// no longer resolve to its declaration under the scoping rules of
+// protobuf, because the new name is shadowed or shadows another
+// declaration, is replaced with the shortest name that does, qualified
+// at least as much as the original reference.
+func (s *Set) RenameType(from, to protoreflect.FullName, options ...RenameOption) error {
+	cfg := &renameConfig{}
+	for _, option := range options {
+		option(cfg)
+	}
+	if !to.IsValid() {
+		return fmt.Errorf("invalid name %q", to)
+	}
+	if from.Parent() != to.Parent() {
+		return fmt.Errorf("cannot rename %s to %s: types can only be renamed within the same scope", from, to)
+	}
+	d, ok := s.decls[from]
+	if !ok {
+		return fmt.Errorf("unknown message or enum %s", from)
+	}
+	if _, ok := s.symbols[string(to)]; ok {
+		return fmt.Errorf("cannot rename %s to %s: %s is already declared", from, to, to)
+	}
+	switch d.node.(type) {
+	case *parser.Message:
+	case *parser.Enum:
+		if cfg.tombstone {
+			return fmt.Errorf("cannot leave a tombstone for enum %s", from)
+		}
+	case *parser.Group:
+		return fmt.Errorf("cannot rename group %s, rename its field instead", from)
+	default:
+		return fmt.Errorf("%s is not a message or enum", from)
+	}
+	rename := func(name string) string {
+		if name == string(from) || strings.HasPrefix(name, string(from)+".") {
+			return string(to) + name[len(from):]
+		}
+		return name
+	}
+	symbols := s.symbols.rename(rename)
+	if cfg.tombstone {
+		symbols[string(from)] = symbolMessage
+	}
+
+	name := string(to.Name())
+	if err := d.file.replaceName(d.node, name); err != nil {
+		return err
+	}
+	if cfg.tombstone {
+		if err := d.file.insertTombstone(d.node, from, to); err != nil {
+			return err
+		}
+	}
+	// The index of the renamed component in full names.
+	component := strings.Count(string(from), ".")
+	for _, f := range s.files {
+		var nodes []parser.Node
+		_ = parser.Visit(f.Proto(), func(node parser.Node, next func() error) error {
+			nodes = append(nodes, node)
+			return next()
+		})
+		for _, node := range nodes {
+			resolved, ok := s.resolved[node]
+			if !ok {
+				continue
+			}
+			ref := f.reference(node)
+			if len(ref.idents) == 0 {
+				continue
+			}
+			target := rename(string(resolved))
+			parts := ref.parts(f)
+			if target != string(resolved) {
+				// The reference names the last components of the resolved name.
+				if i := component - (strings.Count(string(resolved), ".") + 1 - len(parts)); i >= 0 {
+					parts[i] = name
+				}
+			}
+			candidate := strings.Join(parts, ".")
+			if ref.absolute {
+				candidate = "." + candidate
+			}
+			if !ref.typeURL {
+				scope := rename(f.scope(node))
+				if got, ok := symbols.resolve(scope, candidate, ref.types); !ok || got != target {
+					candidate = symbols.shortest(scope, target, len(parts), ref.types)
+				}
+			}
+			if err := f.replaceReference(ref, candidate); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// reference is the tokens of a name referring to a declaration.
+type reference struct {
+	// idents are the indices of the identifier tokens of the name.
+	idents []int
+	// absolute is true if the name starts with a ".".
+	absolute bool
+	// types is true if the name refers to a message or enum, which
+	// skips other declarations when resolving it.
+	types bool
+	// typeURL is true if the name is the full name of a type URL.
+	typeURL bool
+}
+
+func (r reference) parts(f *File) []string {
+	parts := make([]string, len(r.idents))
+	for i, t := range r.idents {
+		parts[i] = f.cst.Tokens[t].Value
+	}
+	return parts
+}
+
+// reference returns the tokens of the name a node refers to a
+// declaration by.
+func (f *File) reference(node parser.Node) reference {
+	s, ok := f.cst.Span(node)
+	if !ok {
+		return reference{}
+	}
+	ref := reference{}
+	start, end := s.Begin, s.End
+	switch node := node.(type) {
+	case *parser.Type:
+		ref.types = true
+	case *parser.Extend:
+		// extend <name> {
+		ref.types = true
+		start++
+		end = f.nextToken(start, "{")
+	case *parser.ProtoTextField:
+		// [<name>] or [<prefix>/<name>]
+		end = f.nextToken(start, "]")
+		start = end
+		for start > s.Begin && f.cst.Tokens[start-1].Value != "/" {
+			start--
+		}
+		ref.typeURL = strings.Contains(node.Type, "/")
+	}
+	for i := start; i < end; i++ {
+		if isIdent(f.cst.Tokens[i]) {
+			ref.idents = append(ref.idents, i)
+		}
+	}
+	if len(ref.idents) > 0 {
+		first := ref.idents[0]
+		ref.absolute = first > start && f.cst.Tokens[first-1].Value == "."
+	}
+	return ref
+}
+
+// replaceReference replaces the tokens of a reference with name. Only
+// the identifiers that differ are replaced if name has the same form.
+func (f *File) replaceReference(ref reference, name string) error {
+	absolute := strings.HasPrefix(name, ".")
+	parts := strings.Split(strings.TrimPrefix(name, "."), ".")
+	if absolute == ref.absolute && len(parts) == len(ref.idents) {
+		for i, t := range ref.idents {
+			if f.cst.Tokens[t].Value == parts[i] {
+				continue
+			}
+			if err := f.cst.Replace(t, t+1, parts[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	start, end := ref.idents[0], ref.idents[len(ref.idents)-1]+1
+	if ref.absolute {
+		start--
+	}
+	return f.cst.Replace(start, end, name)
+}
+
+// scope returns the full name of the scope that the references of node
+// are resolved in: the package of f and the messages and services
+// enclosing node.
+func (f *File) scope(node parser.Node) string {
+	var names []string
+	for n := f.parents[node]; n != nil; n = f.parents[n] {
+		switch n := n.(type) {
+		case *parser.Message:
+			names = append(names, n.Name)
+		case *parser.Group:
+			names = append(names, n.Name)
+		case *parser.Service:
+			names = append(names, n.Name)
+		}
+	}
+	for _, e := range f.Proto().Entries {
+		if e.Package != "" {
+			names = append(names, e.Package)
+		}
+	}
+	for i, j := 0, len(names)-1; i < j; i, j = i+1, j-1 {
+		names[i], names[j] = names[j], names[i]
+	}
+	return strings.Join(names, ".")
+}
+
+// replaceName replaces the name following the keyword of a declaration.
+func (f *File) replaceName(node parser.Node, name string) error {
+	s, err := f.span(node)
+	if err != nil {
+		return err
+	}
+	for i := s.Begin + 1; i < s.End; i++ {
+		if isIdent(f.cst.Tokens[i]) {
+			return f.cst.Replace(i, i+1, name)
+		}
+	}
+	return fmt.Errorf("%T has no name", node)
+}
+
+// insertTombstone inserts a deprecated message named from after the
+// message renamed to to.
+func (f *File) insertTombstone(node parser.Node, from, to protoreflect.FullName) error {
+	s, err := f.span(node)
+	if err != nil {
+		return err
+	}
+	indent := f.indentation(s.Begin)
+	text := "\n" +
+		indent + "// Deprecated: renamed to " + string(to) + ".\n" +
+		indent + "message " + string(from.Name()) + " {\n" +
+		indent + "  option deprecated = true;\n" +
+		indent + "  reserved 1 to max;\n" +
+		indent + "}\n"
+	if !endsLine(f.cst.Tokens[s.Stop-1]) {
+		text = "\n" + text
+	}
+	return f.cst.Replace(s.Stop, s.Stop, text)
+}
+
+func isIdent(t lexer.Token) bool {
+	if parser.IsTrivia(t) || t.Value == "" {
+		return false
+	}
+	c := t.Value[0]
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+type symbol int
+
+const (
+	symbolPackage symbol = iota
+	symbolMessage
+	symbolEnum
+	symbolService
+	symbolExtension
+)
+
+// symbols maps the full names of the packages, messages, enums,
+// services and extensions of a set of files to their kind, which is all
+// that is needed to resolve the references to types and extensions.
+type symbols map[string]symbol
+
+func newSymbols(fds *pb.FileDescriptorSet) symbols {
+	s := symbols{}
+	for _, fd := range fds.File {
+		prefix := fd.GetPackage()
+		if prefix != "" {
+			parts := strings.Split(prefix, ".")
+			for i := range parts {
+				s[strings.Join(parts[:i+1], ".")] = symbolPackage
+			}
+		}
+		for _, md := range fd.GetMessageType() {
+			s.addMessage(md, prefix)
+		}
+		for _, ed := range fd.GetEnumType() {
+			s[qualify(prefix, ed.GetName())] = symbolEnum
+		}
+		for _, sd := range fd.GetService() {
+			s[qualify(prefix, sd.GetName())] = symbolService
+		}
+		for _, ext := range fd.GetExtension() {
+			s[qualify(prefix, ext.GetName())] = symbolExtension
+		}
+	}
+	return s
+}
+
+func (s symbols) addMessage(md *pb.DescriptorProto, prefix string) {
+	name := qualify(prefix, md.GetName())
+	s[name] = symbolMessage
+	for _, nested := range md.GetNestedType() {
+		s.addMessage(nested, name)
+	}
+	for _, ed := range md.GetEnumType() {
+		s[qualify(name, ed.GetName())] = symbolEnum
+	}
+	for _, ext := range md.GetExtension() {
+		s[qualify(name, ext.GetName())] = symbolExtension
+	}
+}
+
+// rename returns a copy of s with every name renamed by fn.
+func (s symbols) rename(fn func(string) string) symbols {
+	out := make(symbols, len(s))
+	for name, sym := range s {
+		out[fn(name)] = sym
+	}
+	return out
+}
+
+// resolve returns the full name a reference resolves to in scope, the
+// way protoc does: the first component of a relative name is looked up
+// in scope and then in each enclosing scope, skipping declarations
+// that cannot contain the rest of the name, or that are not types if
+// types is set and the name has one component.
+func (s symbols) resolve(scope, name string, types bool) (string, bool) {
+	if strings.HasPrefix(name, ".") {
+		_, ok := s[name[1:]]
+		return name[1:], ok
+	}
+	first := name
+	if i := strings.IndexByte(name, '.'); i >= 0 {
+		first = name[:i]
+	}
+	for {
+		if sym, ok := s[qualify(scope, first)]; ok {
+			if first != name {
+				if sym != symbolExtension {
+					full := qualify(scope, name)
+					_, ok := s[full]
+					return full, ok
+				}
+			} else if !types || sym == symbolMessage || sym == symbolEnum {
+				return qualify(scope, name), true
+			}
+		}
+		if scope == "" {
+			return "", false
+		}
+		scope = string(protoreflect.FullName(scope).Parent())
+	}
+}
+
+// shortest returns the shortest name of at least least components that
+// resolves to target in scope, or the fully qualified name of target.
+func (s symbols) shortest(scope, target string, least int, types bool) string {
+	parts := strings.Split(target, ".")
+	for n := least; n <= len(parts); n++ {
+		name := strings.Join(parts[len(parts)-n:], ".")
+		if got, ok := s.resolve(scope, name, types); ok && got == target {
+			return name
+		}
+	}
+	return "." + target
+}
+
+func qualify(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return prefix + "." + name
+}
@@ -0,0 +1,183 @@
+package edit
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+
+	"github.com/alecthomas/protobuf/compiler"
+	"github.com/alecthomas/protobuf/parser"
+)
+
+// Set is a compiled set of files being edited, along with their imports.
+// References between the files are resolved by the compiler, so that
+// edits such as RenameType can update every reference to a declaration.
+type Set struct {
+	files  []*File
+	byName map[string]*File
+	// resolved maps the references in the files to the full names of
+	// the declarations they resolve to.
+	resolved map[parser.Node]protoreflect.FullName
+	decls    map[protoreflect.FullName]declaration
+	symbols  symbols
+}
+
+type declaration struct {
+	file *File
+	node parser.Node
+}
+
+// Load compiles files and their imports found on importPaths for editing.
+func Load(files, importPaths []string) (*Set, error) {
+	result, err := compiler.Build(files, importPaths, true)
+	if err != nil {
+		return nil, err
+	}
+	s := &Set{
+		byName:   map[string]*File{},
+		resolved: map[parser.Node]protoreflect.FullName{},
+		decls:    map[protoreflect.FullName]declaration{},
+		symbols:  newSymbols(result.FileDescriptorSet),
+	}
+	for _, fd := range result.FileDescriptorSet.File {
+		name := fd.GetName()
+		path, err := search(name, importPaths)
+		if err != nil {
+			return nil, err
+		}
+		r, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		f, err := Parse(name, r)
+		r.Close()
+		if err != nil {
+			return nil, err
+		}
+		f.Path = path
+		s.files = append(s.files, f)
+		s.byName[name] = f
+		if err := s.resolve(f, result, result.ASTs[name]); err != nil {
+			return nil, err
+		}
+		s.declare(f)
+	}
+	return s, nil
+}
+
+func search(file string, importPaths []string) (string, error) {
+	for _, path := range importPaths {
+		fname := filepath.Join(path, file)
+		_, err := os.Stat(fname)
+		if err == nil {
+			return fname, nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return "", fmt.Errorf("unexpected error trying to open %q: %w", file, err)
+		}
+	}
+	return "", fmt.Errorf("cannot find %q on import paths", file)
+}
+
+// resolve records the resolution of the references of the compiled AST
+// of f for the corresponding nodes of f. Both are parsed from the same
+// source, so their nodes are visited in the same order.
+func (s *Set) resolve(f *File, result *compiler.Result, ast *parser.Proto) error {
+	var nodes []parser.Node
+	_ = parser.Visit(ast, func(node parser.Node, next func() error) error {
+		nodes = append(nodes, node)
+		return next()
+	})
+	i := 0
+	err := parser.Visit(f.Proto(), func(node parser.Node, next func() error) error {
+		if i >= len(nodes) {
+			return fmt.Errorf("%s: source changed since it was compiled", f.Name)
+		}
+		if name, ok := result.Resolve(nodes[i]); ok {
+			s.resolved[node] = name
+		}
+		i++
+		return next()
+	})
+	if err == nil && i != len(nodes) {
+		err = fmt.Errorf("%s: source changed since it was compiled", f.Name)
+	}
+	return err
+}
+
+// declare records the messages, groups and enums declared in f.
+func (s *Set) declare(f *File) {
+	var pkg string
+	for _, e := range f.Proto().Entries {
+		if e.Package != "" {
+			pkg = e.Package
+		}
+	}
+	var declareEntries func(scope string, entries []*parser.MessageEntry)
+	add := func(scope, name string, node parser.Node) string {
+		full := name
+		if scope != "" {
+			full = scope + "." + name
+		}
+		s.decls[protoreflect.FullName(full)] = declaration{file: f, node: node}
+		return full
+	}
+	declareEntries = func(scope string, entries []*parser.MessageEntry) {
+		for _, e := range entries {
+			switch {
+			case e.Message != nil:
+				declareEntries(add(scope, e.Message.Name, e.Message), e.Message.Entries)
+			case e.Enum != nil:
+				add(scope, e.Enum.Name, e.Enum)
+			case e.Field != nil && e.Field.Group != nil:
+				declareEntries(add(scope, e.Field.Group.Name, e.Field.Group), e.Field.Group.Entries)
+			case e.Oneof != nil:
+				for _, oe := range e.Oneof.Entries {
+					if oe.Field != nil && oe.Field.Group != nil {
+						declareEntries(add(scope, oe.Field.Group.Name, oe.Field.Group), oe.Field.Group.Entries)
+					}
+				}
+			}
+		}
+	}
+	for _, e := range f.Proto().Entries {
+		switch {
+		case e.Message != nil:
+			declareEntries(add(pkg, e.Message.Name, e.Message), e.Message.Entries)
+		case e.Enum != nil:
+			add(pkg, e.Enum.Name, e.Enum)
+		case e.Service != nil:
+			add(pkg, e.Service.Name, e.Service)
+		}
+	}
+}
+
+// Files returns the files of s, imports before the files importing them.
+func (s *Set) Files() []*File { return s.files }
+
+// File returns the file of s with name, or nil.
+func (s *Set) File(name string) *File { return s.byName[name] }
+
+// Declaration returns the file and node of the message, enum or service
+// declared with the full name, or nil.
+func (s *Set) Declaration(name protoreflect.FullName) (*File, parser.Node) {
+	d, ok := s.decls[name]
+	if !ok {
+		return nil, nil
+	}
+	return d.file, d.node
+}
+
+// Modified returns the files of s that have been edited.
+func (s *Set) Modified() []*File {
+	var out []*File
+	for _, f := range s.files {
+		if f.Modified() {
+			out = append(out, f)
+		}
+	}
+	return out
+}
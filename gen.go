@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protodelim"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+
+	"github.com/alecthomas/protobuf/compiler"
+	"github.com/alecthomas/protobuf/gen"
+)
+
+type GenConfig struct {
+	ProtoPath   []string `short:"I" help:"Search paths for proto imports."`
+	Type        string   `required:"" help:"Full name of the message to generate."`
+	Count       int      `short:"n" default:"1" help:"Number of messages to generate."`
+	Seed        *int64   `help:"Seed of the random messages, which are the same for the same seed. By default a random seed is used and printed to stderr."`
+	Format      string   `enum:"binary,json,text" default:"binary" help:"Format of the messages (binary, json or text)."`
+	Output      string   `short:"o" help:"Directory to write each message to as a file, such as 1.bin, for the corpus of a fuzzer. By default messages are written to stdout, binary messages delimited by their length as a varint and others one per line."`
+	MaxDepth    int      `default:"4" help:"Maximum depth of nested messages."`
+	MaxElements int      `default:"3" help:"Maximum number of elements of repeated fields and maps."`
+	MaxLength   int      `default:"16" help:"Maximum length of strings and bytes."`
+	Files       []string `arg:"" help:"Proto files declaring the message or importing the file that does."`
+}
+
+func (c *GenConfig) Run() error {
+	result, err := compiler.Build(c.Files, c.ProtoPath, true)
+	if err != nil {
+		return err
+	}
+	reg := result.Registry()
+	desc, err := reg.FindDescriptorByName(protoreflect.FullName(c.Type))
+	if err != nil {
+		return fmt.Errorf("unknown message %s", c.Type)
+	}
+	md, ok := desc.(protoreflect.MessageDescriptor)
+	if !ok {
+		return fmt.Errorf("%s is not a message", c.Type)
+	}
+	seed := time.Now().UnixNano()
+	if c.Seed != nil {
+		seed = *c.Seed
+	} else {
+		fmt.Fprintf(os.Stderr, "seed %d\n", seed)
+	}
+	g := gen.New(seed, gen.MaxDepth(c.MaxDepth), gen.MaxElements(c.MaxElements), gen.MaxLength(c.MaxLength))
+	marshal, ext := proto.Marshal, "bin"
+	switch c.Format {
+	case "json":
+		marshal, ext = protojson.MarshalOptions{Resolver: reg}.Marshal, "json"
+	case "text":
+		marshal, ext = prototext.MarshalOptions{Resolver: reg}.Marshal, "txtpb"
+	}
+	if c.Output != "" {
+		if err := os.MkdirAll(c.Output, 0o750); err != nil {
+			return err
+		}
+	}
+	w := bufio.NewWriter(os.Stdout)
+	for i := 1; i <= c.Count; i++ {
+		m, err := g.Message(md)
+		if err != nil {
+			return err
+		}
+		if c.Output == "" && c.Format == "binary" {
+			if _, err := protodelim.MarshalTo(w, m); err != nil {
+				return err
+			}
+			continue
+		}
+		b, err := marshal(m)
+		if err != nil {
+			return err
+		}
+		if c.Output != "" {
+			if err := os.WriteFile(filepath.Join(c.Output, fmt.Sprintf("%d.%s", i, ext)), b, 0o600); err != nil {
+				return err
+			}
+			continue
+		}
+		if _, err := fmt.Fprintf(w, "%s\n", b); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+func (c *GenConfig) AfterApply() error {
+	if len(c.Files) == 0 {
+		return fmt.Errorf(`missing .proto input file(s)`)
+	}
+	return nil
+}
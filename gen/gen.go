@@ -0,0 +1,317 @@
+// Package gen generates random messages of message descriptors, such as
+// those of a compiler.Registry, for seeding fuzzers and property tests.
+//
+// Generated messages are valid: they can be marshalled in the binary,
+// JSON and text formats and unmarshalled again. At most one field of each
+// oneof is set, the required fields of proto2 messages are always set,
+// closed enums only have declared values, strings are valid UTF-8, and
+// well-known types such as google.protobuf.Timestamp are in their ranges.
+// Extensions are not set.
+package gen
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+	"unicode/utf8"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// Ranges of google.protobuf.Timestamp and google.protobuf.Duration.
+const (
+	minTimestamp = -62135596800 // 0001-01-01T00:00:00Z
+	maxTimestamp = 253402300799 // 9999-12-31T23:59:59Z
+	maxDuration  = 315576000000 // 10000 years
+	maxNanos     = 999999999
+)
+
+// Generator generates random messages. The same sequence of messages is
+// generated for the same seed, options and descriptors. A Generator is not
+// safe for concurrent use.
+type Generator struct {
+	rand        *rand.Rand
+	maxDepth    int
+	maxElements int
+	maxLength   int
+}
+
+// Option configures a Generator.
+type Option func(*Generator)
+
+// MaxDepth limits messages to n levels of nested messages, counting the
+// message itself, 4 by default. Messages at the deepest level only have
+// message fields set if they are required.
+func MaxDepth(n int) Option {
+	return func(g *Generator) {
+		g.maxDepth = n
+	}
+}
+
+// MaxElements limits repeated fields and maps to n elements, 3 by
+// default.
+func MaxElements(n int) Option {
+	return func(g *Generator) {
+		g.maxElements = n
+	}
+}
+
+// MaxLength limits strings to n runes and bytes fields to n bytes, 16 by
+// default.
+func MaxLength(n int) Option {
+	return func(g *Generator) {
+		g.maxLength = n
+	}
+}
+
+// New returns a Generator seeded with seed.
+func New(seed int64, options ...Option) *Generator {
+	g := &Generator{
+		rand:        rand.New(rand.NewSource(seed)), //nolint:gosec // reproducible rather than secure
+		maxDepth:    4,
+		maxElements: 3,
+		maxLength:   16,
+	}
+	for _, option := range options {
+		option(g)
+	}
+	return g
+}
+
+// Message returns a random message of md. It fails if md has required
+// fields that are recursive, as such messages cannot be generated.
+func (g *Generator) Message(md protoreflect.MessageDescriptor) (*dynamicpb.Message, error) {
+	m := dynamicpb.NewMessage(md)
+	if err := g.fill(m, 1, map[protoreflect.FullName]bool{}); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// fill sets random values to the fields of m, which is at the given depth.
+// Messages deeper than maxDepth are only set by required fields; required
+// holds the types of those being filled, to detect recursion.
+func (g *Generator) fill(m protoreflect.Message, depth int, required map[protoreflect.FullName]bool) error {
+	md := m.Descriptor()
+	if depth > g.maxDepth {
+		if required[md.FullName()] {
+			return fmt.Errorf("%s cannot be generated as its required fields are recursive", md.FullName())
+		}
+		required[md.FullName()] = true
+		defer delete(required, md.FullName())
+	}
+	if g.wellKnown(m) {
+		return nil
+	}
+	nested := depth < g.maxDepth
+	oneofs := md.Oneofs()
+	for i := 0; i < oneofs.Len(); i++ {
+		if od := oneofs.Get(i); !od.IsSynthetic() {
+			if err := g.oneof(m, od, depth, required); err != nil {
+				return err
+			}
+		}
+	}
+	fields := md.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		switch {
+		case fd.ContainingOneof() != nil && !fd.ContainingOneof().IsSynthetic():
+			continue
+		case fd.Cardinality() == protoreflect.Required:
+		case !nested && (fd.Message() != nil || fd.IsMap() && fd.MapValue().Message() != nil):
+			continue
+		case fd.HasPresence() && g.rand.Intn(2) == 0:
+			continue
+		}
+		if err := g.set(m, fd, depth, required); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// oneof sets one of the fields of od or none of them. The kind of a
+// google.protobuf.Value is always set, as it cannot be empty in JSON.
+func (g *Generator) oneof(m protoreflect.Message, od protoreflect.OneofDescriptor, depth int, required map[protoreflect.FullName]bool) error {
+	var fields []protoreflect.FieldDescriptor
+	for i := 0; i < od.Fields().Len(); i++ {
+		if fd := od.Fields().Get(i); fd.Message() == nil || depth < g.maxDepth {
+			fields = append(fields, fd)
+		}
+	}
+	n := len(fields) + 1
+	if m.Descriptor().FullName() == "google.protobuf.Value" {
+		n--
+	}
+	if i := g.rand.Intn(n); i < len(fields) {
+		return g.set(m, fields[i], depth, required)
+	}
+	return nil
+}
+
+func (g *Generator) set(m protoreflect.Message, fd protoreflect.FieldDescriptor, depth int, required map[protoreflect.FullName]bool) error {
+	switch {
+	case fd.IsMap():
+		mp := m.Mutable(fd).Map()
+		for n := g.rand.Intn(g.maxElements + 1); n > 0; n-- {
+			v, err := g.value(fd.MapValue(), mp.NewValue, depth, required)
+			if err != nil {
+				return err
+			}
+			mp.Set(g.scalar(fd.MapKey()).MapKey(), v)
+		}
+	case fd.IsList():
+		list := m.Mutable(fd).List()
+		for n := g.rand.Intn(g.maxElements + 1); n > 0; n-- {
+			v, err := g.value(fd, list.NewElement, depth, required)
+			if err != nil {
+				return err
+			}
+			list.Append(v)
+		}
+	default:
+		v, err := g.value(fd, func() protoreflect.Value { return m.NewField(fd) }, depth, required)
+		if err != nil {
+			return err
+		}
+		m.Set(fd, v)
+	}
+	return nil
+}
+
+// value returns a random value of fd, creating messages with newMessage.
+func (g *Generator) value(fd protoreflect.FieldDescriptor, newMessage func() protoreflect.Value, depth int, required map[protoreflect.FullName]bool) (protoreflect.Value, error) {
+	if fd.Message() == nil {
+		return g.scalar(fd), nil
+	}
+	v := newMessage()
+	return v, g.fill(v.Message(), depth+1, required)
+}
+
+// wellKnown sets the fields of well-known types whose fields have
+// constrained values, and reports whether m is one of them.
+func (g *Generator) wellKnown(m protoreflect.Message) bool {
+	fields := m.Descriptor().Fields()
+	switch m.Descriptor().FullName() {
+	case "google.protobuf.Timestamp":
+		m.Set(fields.ByName("seconds"), protoreflect.ValueOfInt64(minTimestamp+g.rand.Int63n(maxTimestamp-minTimestamp+1)))
+		m.Set(fields.ByName("nanos"), protoreflect.ValueOfInt32(g.rand.Int31n(maxNanos+1)))
+	case "google.protobuf.Duration":
+		seconds := g.rand.Int63n(2*maxDuration+1) - maxDuration
+		nanos := g.rand.Int31n(maxNanos + 1)
+		// The signs of seconds and nanos are the same.
+		if seconds < 0 || seconds == 0 && g.rand.Intn(2) == 0 {
+			nanos = -nanos
+		}
+		m.Set(fields.ByName("seconds"), protoreflect.ValueOfInt64(seconds))
+		m.Set(fields.ByName("nanos"), protoreflect.ValueOfInt32(nanos))
+	case "google.protobuf.FieldMask":
+		// Paths are converted to lowerCamelCase in JSON, so words of
+		// them are lower case.
+		paths := m.Mutable(fields.ByName("paths")).List()
+		for n := g.rand.Intn(g.maxElements + 1); n > 0; n-- {
+			words := make([]string, 1+g.rand.Intn(3))
+			for i := range words {
+				words[i] = g.word()
+			}
+			paths.Append(protoreflect.ValueOfString(strings.Join(words, "_")))
+		}
+	case "google.protobuf.Any":
+		// The type of the value of an Any must be resolvable.
+	default:
+		return false
+	}
+	return true
+}
+
+func (g *Generator) scalar(fd protoreflect.FieldDescriptor) protoreflect.Value {
+	switch fd.Kind() { //nolint:exhaustive
+	case protoreflect.BoolKind:
+		return protoreflect.ValueOfBool(g.rand.Intn(2) == 0)
+	case protoreflect.EnumKind:
+		return protoreflect.ValueOfEnum(g.enum(fd.Enum()))
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		return protoreflect.ValueOfInt32(int32(g.bits(32)))
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return protoreflect.ValueOfInt64(int64(g.bits(64)))
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		return protoreflect.ValueOfUint32(uint32(g.bits(32)))
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return protoreflect.ValueOfUint64(g.bits(64))
+	case protoreflect.FloatKind:
+		return protoreflect.ValueOfFloat32(float32(g.float(fd)))
+	case protoreflect.DoubleKind:
+		return protoreflect.ValueOfFloat64(g.float(fd))
+	case protoreflect.StringKind:
+		return protoreflect.ValueOfString(g.string())
+	case protoreflect.BytesKind:
+		b := make([]byte, g.rand.Intn(g.maxLength+1))
+		_, _ = g.rand.Read(b)
+		return protoreflect.ValueOfBytes(b)
+	}
+	return fd.Default()
+}
+
+// bits returns n random bits, or often an edge case such as the minimum
+// or maximum of an integer of n bits.
+func (g *Generator) bits(n uint) uint64 {
+	mask := uint64(1)<<n - 1
+	if g.rand.Intn(4) == 0 {
+		edges := []uint64{0, 1, mask, 1 << (n - 1), 1<<(n-1) - 1}
+		return edges[g.rand.Intn(len(edges))]
+	}
+	return g.rand.Uint64() & mask
+}
+
+// float returns a random number, or often an edge case such as infinity.
+// Numbers of a google.protobuf.Value are finite, as JSON requires.
+func (g *Generator) float(fd protoreflect.FieldDescriptor) float64 {
+	if g.rand.Intn(4) == 0 {
+		edges := []float64{0, math.Copysign(0, -1), 1, -1, math.MaxFloat64, math.SmallestNonzeroFloat64, math.Inf(1), math.Inf(-1), math.NaN()}
+		if fd.ContainingMessage().FullName() == "google.protobuf.Value" {
+			edges = edges[:6]
+		}
+		return edges[g.rand.Intn(len(edges))]
+	}
+	return g.rand.NormFloat64() * math.Pow(10, float64(g.rand.Intn(10)))
+}
+
+// enum returns a declared value of ed, or sometimes an undeclared one if
+// ed is open. A google.protobuf.NullValue is always null, as in JSON.
+func (g *Generator) enum(ed protoreflect.EnumDescriptor) protoreflect.EnumNumber {
+	closed := ed.ParentFile().Syntax() == protoreflect.Proto2
+	if !closed && ed.FullName() != "google.protobuf.NullValue" && g.rand.Intn(8) == 0 {
+		return protoreflect.EnumNumber(int32(g.bits(32)))
+	}
+	return ed.Values().Get(g.rand.Intn(ed.Values().Len())).Number()
+}
+
+// string returns a random string of valid UTF-8, half of whose runes are
+// printable ASCII.
+func (g *Generator) string() string {
+	var b strings.Builder
+	for n := g.rand.Intn(g.maxLength + 1); n > 0; n-- {
+		if g.rand.Intn(2) == 0 {
+			b.WriteByte(byte(' ' + g.rand.Intn('~'-' '+1)))
+			continue
+		}
+		r := rune(g.rand.Int31n(utf8.MaxRune + 1))
+		for !utf8.ValidRune(r) {
+			r = rune(g.rand.Int31n(utf8.MaxRune + 1))
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// word returns a random word of lower case letters.
+func (g *Generator) word() string {
+	b := make([]byte, 1+g.rand.Intn(8))
+	for i := range b {
+		b[i] = byte('a' + g.rand.Intn(26))
+	}
+	return string(b)
+}
@@ -0,0 +1,182 @@
+package gen
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+
+	"github.com/alecthomas/protobuf/compiler"
+)
+
+func TestMessagesRoundTrip(t *testing.T) {
+	files := []string{
+		"test_messages_proto2.pb",
+		"test_messages_proto3.pb",
+		"unittest.pb",
+		"unittest_proto3_optional.pb",
+		"unittest_well_known_types.pb",
+		"map_unittest.pb",
+	}
+	for _, file := range files {
+		t.Run(file, func(t *testing.T) {
+			reg := loadRegistry(t, file)
+			g := New(1)
+			for _, md := range messages(reg) {
+				for i := 0; i < 20; i++ {
+					m, err := g.Message(md)
+					require.NoError(t, err, "%s", md.FullName())
+					requireRoundTrip(t, reg, m)
+				}
+			}
+		})
+	}
+}
+
+func TestSeed(t *testing.T) {
+	reg := loadRegistry(t, "test_messages_proto3.pb")
+	md, err := reg.FindDescriptorByName("protobuf_test_messages.proto3.TestAllTypesProto3")
+	require.NoError(t, err)
+	generate := func(seed int64) [][]byte {
+		g := New(seed)
+		var all [][]byte
+		for i := 0; i < 10; i++ {
+			m, err := g.Message(md.(protoreflect.MessageDescriptor))
+			require.NoError(t, err)
+			b, err := proto.MarshalOptions{Deterministic: true}.Marshal(m)
+			require.NoError(t, err)
+			all = append(all, b)
+		}
+		return all
+	}
+	require.Equal(t, generate(7), generate(7))
+	require.NotEqual(t, generate(7), generate(8))
+}
+
+func TestMaxDepth(t *testing.T) {
+	reg := loadRegistry(t, "test_messages_proto3.pb")
+	md, err := reg.FindDescriptorByName("protobuf_test_messages.proto3.TestAllTypesProto3")
+	require.NoError(t, err)
+	g := New(1, MaxDepth(1))
+	for i := 0; i < 20; i++ {
+		m, err := g.Message(md.(protoreflect.MessageDescriptor))
+		require.NoError(t, err)
+		m.Range(func(fd protoreflect.FieldDescriptor, _ protoreflect.Value) bool {
+			if fd.IsMap() {
+				fd = fd.MapValue()
+			}
+			require.Nil(t, fd.Message(), "%s", fd.FullName())
+			return true
+		})
+	}
+}
+
+func TestMaxElements(t *testing.T) {
+	reg := loadRegistry(t, "test_messages_proto3.pb")
+	md, err := reg.FindDescriptorByName("protobuf_test_messages.proto3.TestAllTypesProto3")
+	require.NoError(t, err)
+	g := New(1, MaxElements(0), MaxLength(0))
+	for i := 0; i < 20; i++ {
+		m, err := g.Message(md.(protoreflect.MessageDescriptor))
+		require.NoError(t, err)
+		m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+			require.False(t, fd.IsList() || fd.IsMap(), "%s", fd.FullName())
+			if fd.Kind() == protoreflect.StringKind || fd.Kind() == protoreflect.BytesKind {
+				require.Empty(t, v.Interface(), "%s", fd.FullName())
+			}
+			return true
+		})
+	}
+}
+
+func TestRequired(t *testing.T) {
+	result, err := compiler.Build([]string{"required.proto"}, []string{"testdata"}, false)
+	require.NoError(t, err)
+	reg := result.Registry()
+	tests := []struct {
+		name string
+		err  string
+	}{
+		{name: "required.Node"},
+		{name: "required.Loop", err: "required.Loop cannot be generated as its required fields are recursive"},
+		{name: "required.IndirectLoop", err: "required.IndirectLoop cannot be generated as its required fields are recursive"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			md, err := reg.FindDescriptorByName(protoreflect.FullName(test.name))
+			require.NoError(t, err)
+			g := New(1)
+			for i := 0; i < 20; i++ {
+				m, err := g.Message(md.(protoreflect.MessageDescriptor))
+				if test.err != "" {
+					require.EqualError(t, err, test.err)
+					return
+				}
+				require.NoError(t, err)
+				require.NoError(t, proto.CheckInitialized(m))
+			}
+		})
+	}
+}
+
+// requireRoundTrip requires that m is unchanged by marshalling and
+// unmarshalling it in the binary, JSON and text formats.
+func requireRoundTrip(t *testing.T, reg *compiler.Registry, m *dynamicpb.Message) {
+	t.Helper()
+	name := m.Descriptor().FullName()
+	b, err := proto.Marshal(m)
+	require.NoError(t, err, "%s", name)
+	got := m.New().Interface()
+	require.NoError(t, proto.Unmarshal(b, got), "%s", name)
+	require.True(t, proto.Equal(m, got), "%s: %v != %v", name, m, got)
+
+	b, err = protojson.MarshalOptions{Resolver: reg}.Marshal(m)
+	require.NoError(t, err, "%s: %v", name, m)
+	got = m.New().Interface()
+	require.NoError(t, protojson.UnmarshalOptions{Resolver: reg}.Unmarshal(b, got), "%s: %s", name, b)
+	require.True(t, proto.Equal(m, got), "%s: %v != %v", name, m, got)
+
+	b, err = prototext.MarshalOptions{Resolver: reg}.Marshal(m)
+	require.NoError(t, err, "%s: %v", name, m)
+	got = m.New().Interface()
+	require.NoError(t, prototext.UnmarshalOptions{Resolver: reg}.Unmarshal(b, got), "%s: %s", name, b)
+	require.True(t, proto.Equal(m, got), "%s: %v != %v", name, m, got)
+}
+
+func loadRegistry(t *testing.T, file string) *compiler.Registry {
+	t.Helper()
+	b, err := os.ReadFile("../testdata/conformance/pb/" + file)
+	require.NoError(t, err)
+	fds := &descriptorpb.FileDescriptorSet{}
+	require.NoError(t, proto.Unmarshal(b, fds))
+	reg, err := compiler.NewRegistry(fds)
+	require.NoError(t, err)
+	return reg
+}
+
+// messages returns the message types declared in the files of reg, other
+// than map entries.
+func messages(reg *compiler.Registry) []protoreflect.MessageDescriptor {
+	var all []protoreflect.MessageDescriptor
+	var add func(mds protoreflect.MessageDescriptors)
+	add = func(mds protoreflect.MessageDescriptors) {
+		for i := 0; i < mds.Len(); i++ {
+			md := mds.Get(i)
+			if !md.IsMapEntry() {
+				all = append(all, md)
+			}
+			add(md.Messages())
+		}
+	}
+	reg.RangeFiles(func(fd protoreflect.FileDescriptor) bool {
+		add(fd.Messages())
+		return true
+	})
+	return all
+}
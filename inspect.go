@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+
+	"github.com/alecthomas/protobuf/compiler"
+	"github.com/alecthomas/protobuf/inspect"
+)
+
+type InspectConfig struct {
+	ProtoPath []string `short:"I" help:"Search paths for proto imports."`
+	Type      string   `required:"" help:"Full name of the message in the input."`
+	Color     string   `enum:"auto,always,never" default:"auto" help:"Highlight problems in colour (auto, always or never)."`
+	Input     string   `arg:"" help:"File of the message in the binary format, or - for stdin."`
+	Files     []string `arg:"" help:"Proto files declaring the message or importing the file that does."`
+}
+
+func (c *InspectConfig) Run() error {
+	result, err := compiler.Build(c.Files, c.ProtoPath, true)
+	if err != nil {
+		return err
+	}
+	reg := result.Registry()
+	desc, err := reg.FindDescriptorByName(protoreflect.FullName(c.Type))
+	if err != nil {
+		return fmt.Errorf("unknown message %s", c.Type)
+	}
+	md, ok := desc.(protoreflect.MessageDescriptor)
+	if !ok {
+		return fmt.Errorf("%s is not a message", c.Type)
+	}
+	var b []byte
+	if c.Input == "-" {
+		b, err = io.ReadAll(os.Stdin)
+	} else {
+		b, err = os.ReadFile(c.Input)
+	}
+	if err != nil {
+		return err
+	}
+	var options []inspect.Option
+	if c.Color == "always" || c.Color == "auto" && isTerminal(os.Stdout) {
+		options = append(options, inspect.Color())
+	}
+	fields := inspect.Parse(b, md, reg)
+	if err := inspect.Write(os.Stdout, b, fields, options...); err != nil {
+		return err
+	}
+	if n := len(inspect.Problems(fields)); n > 0 {
+		return fmt.Errorf("%d problems found", n)
+	}
+	return nil
+}
+
+func (c *InspectConfig) AfterApply() error {
+	if len(c.Files) == 0 {
+		return fmt.Errorf(`missing .proto input file(s)`)
+	}
+	return nil
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
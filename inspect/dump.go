@@ -0,0 +1,253 @@
+package inspect
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// bytesPerLine is the number of bytes on each line of a dump.
+const bytesPerLine = 16
+
+// maxIndent is the deepest nesting of fields indented in annotations,
+// deeper fields are indented as much.
+const maxIndent = 32
+
+// maxQuoted is the number of bytes of string and bytes values quoted in
+// annotations.
+const maxQuoted = 48
+
+type config struct {
+	color bool
+}
+
+// Option configures Write.
+type Option func(*config)
+
+// Color highlights the fields with problems with ANSI escape codes.
+func Color() Option {
+	return func(c *config) {
+		c.color = true
+	}
+}
+
+// Write writes a hex dump of b to w, annotating the bytes of each of
+// fields, as returned by Parse for b, with the number, name, type and
+// value of the field. Fields of messages are indented, and the
+// annotations of fields with problems start with "!" and end with the
+// problem.
+func Write(w io.Writer, b []byte, fields []*Field, options ...Option) error {
+	c := &config{}
+	for _, option := range options {
+		option(c)
+	}
+	d := &dumper{w: bufio.NewWriter(w), b: b, color: c.color}
+	d.fields(fields, 0)
+	if d.err != nil {
+		return d.err
+	}
+	return d.w.Flush()
+}
+
+type dumper struct {
+	w     *bufio.Writer
+	b     []byte
+	color bool
+	err   error
+}
+
+func (d *dumper) fields(fields []*Field, depth int) {
+	for _, f := range fields {
+		if f.Fields == nil {
+			d.line(f, f.End, depth)
+			continue
+		}
+		// The tag and length of a message are followed by its fields.
+		d.line(f, f.ValueOffset, depth)
+		d.fields(f.Fields, depth+1)
+	}
+}
+
+// line writes the bytes of f up to end, wrapping them over several lines.
+func (d *dumper) line(f *Field, end, depth int) {
+	if depth > maxIndent {
+		depth = maxIndent
+	}
+	annotation := strings.Repeat("  ", depth) + d.annotation(f)
+	for off := f.Offset; off < end && d.err == nil; off += bytesPerLine {
+		next := off + bytesPerLine
+		if next > end {
+			next = end
+		}
+		hex := make([]string, 0, bytesPerLine)
+		for _, c := range d.b[off:next] {
+			hex = append(hex, fmt.Sprintf("%02x", c))
+		}
+		line := strings.TrimRight(fmt.Sprintf("%08x  %-*s  %s", off, bytesPerLine*3-1, strings.Join(hex, " "), annotation), " ")
+		if d.color && f.Problem != "" {
+			line = "\x1b[31m" + line + "\x1b[0m"
+		}
+		_, d.err = fmt.Fprintln(d.w, line)
+		annotation = ""
+	}
+}
+
+func (d *dumper) annotation(f *Field) string {
+	var text string
+	switch {
+	case f.Offset == f.ValueOffset:
+		// The tag could not be decoded.
+	case f.Type == protowire.EndGroupType:
+		text = fmt.Sprintf("end group %d", f.Number)
+	case f.Desc == nil || f.Problem != "" && f.Problem != invalidUTF8:
+		text = fmt.Sprintf("%d: %s", f.Number, wireTypeName(f.Type))
+		if value, ok := d.unknown(f); ok {
+			text += " = " + value
+		}
+	default:
+		text = fmt.Sprintf("%d %s: %s", f.Number, fieldName(f.Desc), typeName(f.Desc))
+		if f.Fields == nil {
+			text += " = " + d.value(f)
+		}
+	}
+	if f.Problem == "" {
+		return text
+	}
+	if text == "" {
+		return "! " + f.Problem
+	}
+	return "! " + text + " (" + f.Problem + ")"
+}
+
+// unknown formats the value of a field without a descriptor, unless it
+// could not be decoded or is decoded as a message.
+func (d *dumper) unknown(f *Field) (string, bool) {
+	if f.Problem == truncated || f.Fields != nil {
+		return "", false
+	}
+	b := d.b[f.ValueOffset:f.End]
+	switch f.Type {
+	case protowire.VarintType:
+		v, _ := protowire.ConsumeVarint(b)
+		return fmt.Sprint(v), true
+	case protowire.Fixed32Type:
+		v, _ := protowire.ConsumeFixed32(b)
+		return fmt.Sprintf("0x%08x", v), true
+	case protowire.Fixed64Type:
+		v, _ := protowire.ConsumeFixed64(b)
+		return fmt.Sprintf("0x%016x", v), true
+	case protowire.BytesType:
+		return quote(b), true
+	}
+	return "", false
+}
+
+// value formats the value of a field with a descriptor, which has the
+// wire type of the descriptor.
+func (d *dumper) value(f *Field) string {
+	b := d.b[f.ValueOffset:f.End]
+	typ := wireType(f.Desc)
+	if typ == protowire.BytesType {
+		return quote(b)
+	}
+	if f.Type != protowire.BytesType {
+		return scalar(f.Desc, typ, b)
+	}
+	// A packed repeated scalar.
+	var values []string
+	for len(b) > 0 {
+		var n int
+		switch typ {
+		case protowire.VarintType:
+			_, n = protowire.ConsumeVarint(b)
+		case protowire.Fixed32Type:
+			_, n = protowire.ConsumeFixed32(b)
+		default:
+			_, n = protowire.ConsumeFixed64(b)
+		}
+		if n < 0 {
+			values = append(values, "! "+problem(n))
+			break
+		}
+		values = append(values, scalar(f.Desc, typ, b[:n]))
+		b = b[n:]
+	}
+	return "[" + strings.Join(values, ", ") + "]"
+}
+
+// scalar formats a value of fd that is not length-delimited.
+func scalar(fd protoreflect.FieldDescriptor, typ protowire.Type, b []byte) string {
+	var v uint64
+	switch typ {
+	case protowire.VarintType:
+		v, _ = protowire.ConsumeVarint(b)
+	case protowire.Fixed32Type:
+		v32, _ := protowire.ConsumeFixed32(b)
+		v = uint64(v32)
+	default:
+		v, _ = protowire.ConsumeFixed64(b)
+	}
+	switch fd.Kind() { //nolint:exhaustive
+	case protoreflect.BoolKind:
+		return fmt.Sprint(v != 0)
+	case protoreflect.EnumKind:
+		if ev := fd.Enum().Values().ByNumber(protoreflect.EnumNumber(int32(v))); ev != nil {
+			return string(ev.Name())
+		}
+		return fmt.Sprint(int32(v))
+	case protoreflect.Int32Kind, protoreflect.Sfixed32Kind:
+		return fmt.Sprint(int32(v))
+	case protoreflect.Int64Kind, protoreflect.Sfixed64Kind:
+		return fmt.Sprint(int64(v))
+	case protoreflect.Sint32Kind:
+		return fmt.Sprint(int32(protowire.DecodeZigZag(v & math.MaxUint32)))
+	case protoreflect.Sint64Kind:
+		return fmt.Sprint(protowire.DecodeZigZag(v))
+	case protoreflect.FloatKind:
+		return fmt.Sprint(math.Float32frombits(uint32(v)))
+	case protoreflect.DoubleKind:
+		return fmt.Sprint(math.Float64frombits(v))
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		return fmt.Sprint(uint32(v))
+	}
+	return fmt.Sprint(v)
+}
+
+// quote quotes the start of b.
+func quote(b []byte) string {
+	if len(b) > maxQuoted {
+		return fmt.Sprintf("%q...", b[:maxQuoted])
+	}
+	return fmt.Sprintf("%q", b)
+}
+
+func fieldName(fd protoreflect.FieldDescriptor) string {
+	if fd.IsExtension() {
+		return "[" + string(fd.FullName()) + "]"
+	}
+	return string(fd.Name())
+}
+
+func typeName(fd protoreflect.FieldDescriptor) string {
+	if fd.IsMap() {
+		return fmt.Sprintf("map<%s, %s>", typeName(fd.MapKey()), typeName(fd.MapValue()))
+	}
+	var name string
+	switch {
+	case fd.Message() != nil:
+		name = string(fd.Message().FullName())
+	case fd.Enum() != nil:
+		name = string(fd.Enum().FullName())
+	default:
+		name = fd.Kind().String()
+	}
+	if fd.IsList() {
+		return "repeated " + name
+	}
+	return name
+}
@@ -0,0 +1,244 @@
+// Package inspect decodes messages in the protobuf wire format field by
+// field with the descriptors of a compiler.Registry, keeping the offsets
+// of tags and values and the problems of bytes that do not match the
+// schema, to debug corrupted payloads.
+package inspect
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/reflect/protoreflect"
+
+	"github.com/alecthomas/protobuf/compiler"
+)
+
+// Field is a field decoded from the wire format. Offsets are from the
+// start of the outermost message.
+type Field struct {
+	// Offset of the tag.
+	Offset int
+	// ValueOffset is the offset of the value, after the tag and the
+	// length of length-delimited values.
+	ValueOffset int
+	// End is the offset after the value, or after the end tag of a group.
+	End int
+
+	Number protowire.Number
+	Type   protowire.Type
+	// Desc is the descriptor of the field, or nil if the field is unknown.
+	Desc protoreflect.FieldDescriptor
+	// Fields of a message or group value, or nil if the value was not
+	// decoded as a message. The last field of a group is its end tag.
+	Fields []*Field
+	// Problem is why the field does not match the schema, such as an
+	// unknown field, or why the bytes from Offset to End could not be
+	// decoded.
+	Problem string
+}
+
+// Parse decodes b as a message of md, resolving extensions and the
+// messages in google.protobuf.Any values with reg. Bytes that cannot be
+// decoded are returned as a last field with a Problem.
+func Parse(b []byte, md protoreflect.MessageDescriptor, reg *compiler.Registry) []*Field {
+	p := &parser{b: b, reg: reg}
+	fields, _ := p.message(0, len(b), md, 0, 0)
+	return fields
+}
+
+// Problems returns the fields with problems in fields and the fields of
+// their messages.
+func Problems(fields []*Field) []*Field {
+	var problems []*Field
+	for _, f := range fields {
+		if f.Problem != "" {
+			problems = append(problems, f)
+		}
+		problems = append(problems, Problems(f.Fields)...)
+	}
+	return problems
+}
+
+// Problems of fields that the annotations of Write depend on.
+const (
+	truncated   = "truncated"
+	invalidUTF8 = "invalid UTF-8"
+)
+
+// maxDepth is the deepest nesting of messages and groups that is decoded,
+// as in protobuf-go.
+const maxDepth = protowire.DefaultRecursionLimit
+
+type parser struct {
+	b   []byte
+	reg *compiler.Registry
+}
+
+// message decodes the fields of a message of md from start to end, nested
+// in depth messages. The fields of a group end with the end tag of group,
+// which is returned with the offset after it. Beyond maxDepth, the bytes
+// up to end are returned as a single field with a problem.
+func (p *parser) message(start, end int, md protoreflect.MessageDescriptor, group protowire.Number, depth int) ([]*Field, int) {
+	if depth > maxDepth {
+		return []*Field{{Offset: start, ValueOffset: start, End: end, Problem: "exceeds maximum nesting depth"}}, end
+	}
+	fields := []*Field{}
+	off := start
+	for off < end {
+		num, typ, n := protowire.ConsumeTag(p.b[off:end])
+		if n < 0 {
+			return append(fields, &Field{Offset: off, ValueOffset: off, End: end, Problem: problem(n)}), end
+		}
+		f := &Field{Offset: off, ValueOffset: off + n, Number: num, Type: typ, Desc: p.field(md, num)}
+		fields = append(fields, f)
+		if md != nil && f.Desc == nil && typ != protowire.EndGroupType {
+			f.Problem = "unknown field"
+		}
+		if typ == protowire.EndGroupType {
+			f.End = f.ValueOffset
+			if num == group {
+				return fields, f.End
+			}
+			f.Desc = nil
+			f.Problem = "end group without a start group"
+			off = f.End
+			continue
+		}
+		if f.Desc != nil && !wireTypeOK(f.Desc, typ) {
+			f.Problem = fmt.Sprintf("wire type %s, expected %s", wireTypeName(typ), wireTypeName(wireType(f.Desc)))
+		}
+		var desc protoreflect.MessageDescriptor
+		if f.Problem == "" && f.Desc != nil {
+			desc = f.Desc.Message()
+		}
+		switch typ {
+		case protowire.StartGroupType:
+			f.Fields, f.End = p.message(f.ValueOffset, end, desc, num, depth+1)
+			if len(f.Fields) == 0 || f.Fields[len(f.Fields)-1].Type != protowire.EndGroupType || f.Fields[len(f.Fields)-1].Number != num {
+				f.Problem = fmt.Sprintf("missing end group %d", num)
+			}
+		case protowire.BytesType:
+			v, m := protowire.ConsumeBytes(p.b[f.ValueOffset:end])
+			if m < 0 {
+				f.End = end
+				f.Problem = problem(m)
+				return fields, end
+			}
+			f.ValueOffset, f.End = f.ValueOffset+m-len(v), f.ValueOffset+m
+			if desc != nil {
+				f.Fields, _ = p.message(f.ValueOffset, f.End, desc, 0, depth+1)
+			}
+		default:
+			m := protowire.ConsumeFieldValue(num, typ, p.b[f.ValueOffset:end])
+			if m < 0 {
+				f.End = end
+				f.Problem = problem(m)
+				return fields, end
+			}
+			f.End = f.ValueOffset + m
+		}
+		if f.Problem == "" && f.Desc != nil && f.Desc.Kind() == protoreflect.StringKind && f.Desc.Syntax() == protoreflect.Proto3 {
+			if !utf8.Valid(p.b[f.ValueOffset:f.End]) {
+				f.Problem = invalidUTF8
+			}
+		}
+		off = f.End
+	}
+	if md != nil && md.FullName() == "google.protobuf.Any" {
+		p.any(fields, depth)
+	}
+	return fields, off
+}
+
+// any decodes the value of a google.protobuf.Any, nested in depth
+// messages, as a message of the type of its URL.
+func (p *parser) any(fields []*Field, depth int) {
+	var url string
+	for _, f := range fields {
+		if f.Number == 1 && f.Type == protowire.BytesType && f.Problem == "" {
+			url = string(p.b[f.ValueOffset:f.End])
+		}
+	}
+	mt, err := p.reg.FindMessageByURL(url)
+	if err != nil {
+		return
+	}
+	for _, f := range fields {
+		if f.Number == 2 && f.Type == protowire.BytesType && f.Problem == "" {
+			f.Fields, _ = p.message(f.ValueOffset, f.End, mt.Descriptor(), 0, depth+1)
+		}
+	}
+}
+
+// field returns the field or extension of md with the number num, or nil.
+func (p *parser) field(md protoreflect.MessageDescriptor, num protowire.Number) protoreflect.FieldDescriptor {
+	if md == nil {
+		return nil
+	}
+	if fd := md.Fields().ByNumber(num); fd != nil {
+		return fd
+	}
+	if md.ExtensionRanges().Has(num) {
+		if xt, err := p.reg.FindExtensionByNumber(md.FullName(), num); err == nil {
+			return xt.TypeDescriptor()
+		}
+	}
+	return nil
+}
+
+// problem returns the problem of a negative result of protowire.
+func problem(n int) string {
+	err := protowire.ParseError(n)
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		return truncated
+	}
+	// The space after the prefix of protobuf errors varies.
+	return strings.TrimSpace(strings.TrimPrefix(err.Error(), "proto:"))
+}
+
+// wireType returns the wire type of fd when it is not packed.
+func wireType(fd protoreflect.FieldDescriptor) protowire.Type {
+	switch fd.Kind() {
+	case protoreflect.BoolKind, protoreflect.EnumKind,
+		protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Uint32Kind,
+		protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Uint64Kind:
+		return protowire.VarintType
+	case protoreflect.Fixed32Kind, protoreflect.Sfixed32Kind, protoreflect.FloatKind:
+		return protowire.Fixed32Type
+	case protoreflect.Fixed64Kind, protoreflect.Sfixed64Kind, protoreflect.DoubleKind:
+		return protowire.Fixed64Type
+	case protoreflect.GroupKind:
+		return protowire.StartGroupType
+	default:
+		return protowire.BytesType
+	}
+}
+
+// wireTypeOK reports whether typ is a wire type of fd. Repeated scalars
+// may be packed or not, whether they are declared packed or not.
+func wireTypeOK(fd protoreflect.FieldDescriptor, typ protowire.Type) bool {
+	want := wireType(fd)
+	return typ == want || typ == protowire.BytesType && fd.IsList() && want != protowire.BytesType && want != protowire.StartGroupType
+}
+
+func wireTypeName(typ protowire.Type) string {
+	switch typ {
+	case protowire.VarintType:
+		return "varint"
+	case protowire.Fixed32Type:
+		return "fixed32"
+	case protowire.Fixed64Type:
+		return "fixed64"
+	case protowire.BytesType:
+		return "bytes"
+	case protowire.StartGroupType:
+		return "start group"
+	case protowire.EndGroupType:
+		return "end group"
+	}
+	return fmt.Sprint(int8(typ))
+}
@@ -0,0 +1,182 @@
+package inspect
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+
+	"github.com/alecthomas/protobuf/compiler"
+)
+
+func TestWrite(t *testing.T) {
+	reg := newRegistry(t)
+	mt, err := reg.FindMessageByName("inspect.Person")
+	require.NoError(t, err)
+	m := mt.New().Interface()
+	require.NoError(t, protojson.UnmarshalOptions{Resolver: reg}.Unmarshal([]byte(`{
+		"id": 150,
+		"name": "Ada",
+		"scores": [1, 300, -1],
+		"address": {"city": "Wellington"},
+		"kind": "KIND_ADMIN",
+		"delta": "-2",
+		"ratio": 0.5,
+		"extra": {"code": 7},
+		"counts": {"a": 1},
+		"detail": {"@type": "type.googleapis.com/inspect.Address", "city": "Auckland"},
+		"[inspect.vip]": true
+	}`), m))
+	b, err := proto.MarshalOptions{Deterministic: true}.Marshal(m)
+	require.NoError(t, err)
+	fields := Parse(b, mt.Descriptor(), reg)
+	require.Empty(t, Problems(fields))
+	want := `
+00000000  a0 06 01                                         100 [inspect.vip]: bool = true
+00000003  08 96 01                                         1 id: int32 = 150
+00000006  12 03 41 64 61                                   2 name: string = "Ada"
+0000000b  1a 0d 01 ac 02 ff ff ff ff ff ff ff ff ff 01     3 scores: repeated int32 = [1, 300, -1]
+0000001a  22 0c                                            4 address: inspect.Address
+0000001c  0a 0a 57 65 6c 6c 69 6e 67 74 6f 6e                1 city: string = "Wellington"
+00000028  28 01                                            5 kind: inspect.Kind = KIND_ADMIN
+0000002a  30 03                                            6 delta: sint64 = -2
+0000002c  39 00 00 00 00 00 00 e0 3f                       7 ratio: double = 0.5
+00000035  43                                               8 extra: inspect.Person.Extra
+00000036  4d 07 00 00 00                                     9 code: fixed32 = 7
+0000003b  44                                                 end group 8
+0000003c  52 05                                            10 counts: map<string, int32>
+0000003e  0a 01 61                                           1 key: string = "a"
+00000041  10 01                                              2 value: int32 = 1
+00000043  5a 31                                            11 detail: google.protobuf.Any
+00000045  0a 23 74 79 70 65 2e 67 6f 6f 67 6c 65 61 70 69    1 type_url: string = "type.googleapis.com/inspect.Address"
+00000055  73 2e 63 6f 6d 2f 69 6e 73 70 65 63 74 2e 41 64
+00000065  64 72 65 73 73
+0000006a  12 0a                                              2 value: bytes
+0000006c  0a 08 41 75 63 6b 6c 61 6e 64                        1 city: string = "Auckland"
+`
+	requireDump(t, want, b, fields)
+}
+
+func TestProblems(t *testing.T) {
+	tests := []struct {
+		name     string
+		message  protoreflect.FullName
+		b        string
+		want     string
+		problems int
+	}{
+		{name: "UnknownField", message: "inspect.Person", b: "\x98\x06\x01", problems: 1, want: `
+00000000  98 06 01                                         ! 99: varint = 1 (unknown field)
+`},
+		{name: "UnknownExtension", message: "inspect.Person", b: "\xa8\x06\x01", problems: 1, want: `
+00000000  a8 06 01                                         ! 101: varint = 1 (unknown field)
+`},
+		{name: "WireTypeMismatch", message: "inspect.Person", b: "\x0a\x01A", problems: 1, want: `
+00000000  0a 01 41                                         ! 1: bytes = "A" (wire type bytes, expected varint)
+`},
+		{name: "UnpackedRepeated", message: "inspect.Person", b: "\x18\x01\x18\x02", want: `
+00000000  18 01                                            3 scores: repeated int32 = 1
+00000002  18 02                                            3 scores: repeated int32 = 2
+`},
+		{name: "MessageMismatch", message: "inspect.Person", b: "\x25\x01\x02\x03\x04", problems: 1, want: `
+00000000  25 01 02 03 04                                   ! 4: fixed32 = 0x04030201 (wire type fixed32, expected bytes)
+`},
+		{name: "TruncatedVarint", message: "inspect.Person", b: "\x08\x96", problems: 1, want: `
+00000000  08 96                                            ! 1: varint (truncated)
+`},
+		{name: "TruncatedBytes", message: "inspect.Person", b: "\x08\x01\x12\x05Ada", problems: 1, want: `
+00000000  08 01                                            1 id: int32 = 1
+00000002  12 05 41 64 61                                   ! 2: bytes (truncated)
+`},
+		{name: "TruncatedMessage", message: "inspect.Person", b: "\x22\x03\x0a\x05W", problems: 1, want: `
+00000000  22 03                                            4 address: inspect.Address
+00000002  0a 05 57                                           ! 1: bytes (truncated)
+`},
+		{name: "TruncatedPacked", message: "inspect.Person", b: "\x1a\x02\x01\x96", want: `
+00000000  1a 02 01 96                                      3 scores: repeated int32 = [1, ! truncated]
+`},
+		{name: "InvalidTag", message: "inspect.Person", b: "\x08\x01\x00\x01", problems: 1, want: `
+00000000  08 01                                            1 id: int32 = 1
+00000002  00 01                                            ! invalid field number
+`},
+		{name: "ReservedWireType", message: "inspect.Person", b: "\x0e\x01", problems: 1, want: `
+00000000  0e 01                                            ! 1: 6 (cannot parse reserved wire type)
+`},
+		{name: "MissingEndGroup", message: "inspect.Person", b: "\x43\x4d\x07\x00\x00\x00", problems: 1, want: `
+00000000  43                                               ! 8: start group (missing end group 8)
+00000001  4d 07 00 00 00                                     9 code: fixed32 = 7
+`},
+		{name: "EndGroupWithoutStart", message: "inspect.Person", b: "\x44", problems: 1, want: `
+00000000  44                                               ! end group 8 (end group without a start group)
+`},
+		{name: "InvalidUTF8", message: "inspect.Note", b: "\x0a\x02\xff\xfe", problems: 1, want: `
+00000000  0a 02 ff fe                                      ! 1 text: string = "\xff\xfe" (invalid UTF-8)
+`},
+		{name: "AnyOfUnknownType", message: "google.protobuf.Any", b: "\x0a\x01x\x12\x02\x08\x01", want: `
+00000000  0a 01 78                                         1 type_url: string = "x"
+00000003  12 02 08 01                                      2 value: bytes = "\b\x01"
+`},
+	}
+	reg := newRegistry(t)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			md, err := reg.FindDescriptorByName(test.message)
+			require.NoError(t, err)
+			fields := Parse([]byte(test.b), md.(protoreflect.MessageDescriptor), reg)
+			requireDump(t, test.want, []byte(test.b), fields)
+			require.Len(t, Problems(fields), test.problems)
+		})
+	}
+}
+
+func TestNestingDepth(t *testing.T) {
+	b := []byte(strings.Repeat("\x0b", maxDepth+3))
+	fields := Parse(b, nil, newRegistry(t))
+	depth := 0
+	for len(fields) == 1 && fields[0].Fields != nil {
+		require.Equal(t, "missing end group 1", fields[0].Problem)
+		fields = fields[0].Fields
+		depth++
+	}
+	require.Equal(t, maxDepth+1, depth)
+	require.Len(t, fields, 1)
+	require.Equal(t, &Field{Offset: maxDepth + 1, ValueOffset: maxDepth + 1, End: len(b), Problem: "exceeds maximum nesting depth"}, fields[0])
+
+	w := &strings.Builder{}
+	require.NoError(t, Write(w, b, Parse(b, nil, newRegistry(t))))
+	lines := strings.Split(strings.TrimSuffix(w.String(), "\n"), "\n")
+	// The indentation of the deepest fields is capped.
+	want := fmt.Sprintf("%08x  %-*s  %s! exceeds maximum nesting depth", maxDepth+1, bytesPerLine*3-1, "0b 0b", strings.Repeat("  ", maxIndent))
+	require.Equal(t, want, lines[len(lines)-1])
+}
+
+func TestColor(t *testing.T) {
+	reg := newRegistry(t)
+	md, err := reg.FindDescriptorByName("inspect.Person")
+	require.NoError(t, err)
+	b := []byte("\x08\x01\x98\x06\x01")
+	w := &strings.Builder{}
+	require.NoError(t, Write(w, b, Parse(b, md.(protoreflect.MessageDescriptor), reg), Color()))
+	require.Equal(t, ""+
+		"00000000  08 01                                            1 id: int32 = 1\n"+
+		"\x1b[31m00000002  98 06 01                                         ! 99: varint = 1 (unknown field)\x1b[0m\n",
+		w.String())
+}
+
+func requireDump(t *testing.T, want string, b []byte, fields []*Field) {
+	t.Helper()
+	w := &strings.Builder{}
+	require.NoError(t, Write(w, b, fields))
+	require.Equal(t, strings.TrimPrefix(want, "\n"), w.String())
+}
+
+func newRegistry(t *testing.T) *compiler.Registry {
+	t.Helper()
+	result, err := compiler.Build([]string{"person.proto", "note.proto"}, []string{"testdata", "../testdata/conformance"}, true)
+	require.NoError(t, err)
+	return result.Registry()
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	pb "google.golang.org/protobuf/types/descriptorpb"
+
+	"github.com/alecthomas/protobuf/edit"
+	"github.com/alecthomas/protobuf/lint"
+	"github.com/alecthomas/protobuf/parser"
+)
+
+type NextNumberConfig struct {
+	ProtoPath []string `short:"I" help:"Search paths for proto imports."`
+	Message   string   `arg:"" help:"Full name of the message."`
+	Files     []string `arg:"" help:"Proto files declaring the message or importing the file that does."`
+}
+
+func (c *NextNumberConfig) Run() error {
+	set, err := edit.Load(c.Files, c.ProtoPath)
+	if err != nil {
+		return err
+	}
+	var numbers *lint.Numbers
+	_, node := set.Declaration(protoreflect.FullName(c.Message))
+	switch node := node.(type) {
+	case *parser.Message:
+		numbers = lint.MessageNumbers(node.Entries)
+	case *parser.Group:
+		numbers = lint.MessageNumbers(node.Entries)
+	default:
+		return fmt.Errorf("unknown message %s", c.Message)
+	}
+	next, ok := numbers.Next()
+	if !ok {
+		return fmt.Errorf("%s has no free field numbers", c.Message)
+	}
+	fmt.Println(next)
+	return nil
+}
+
+type LintConfig struct {
+	ProtoPath []string `short:"I" help:"Search paths for proto imports."`
+	Old       string   `help:"FileDescriptorSet of a previous version of the files, to find deleted fields that are not reserved." type:"existingfile"`
+	Fix       bool     `help:"Fix issues that can be fixed automatically, rewriting the files."`
+	Files     []string `arg:"" help:"Proto files to check."`
+}
+
+func (c *LintConfig) Run() error {
+	set, err := edit.Load(c.Files, c.ProtoPath)
+	if err != nil {
+		return err
+	}
+	var old *pb.FileDescriptorSet
+	if c.Old != "" {
+		b, err := os.ReadFile(c.Old)
+		if err != nil {
+			return err
+		}
+		old = &pb.FileDescriptorSet{}
+		if err := proto.Unmarshal(b, old); err != nil {
+			return fmt.Errorf("%s: %w", c.Old, err)
+		}
+	}
+	files := make([]*edit.File, len(c.Files))
+	for i, name := range c.Files {
+		files[i] = set.File(name)
+	}
+	remaining := 0
+	for _, issue := range lint.FieldNumbers(files, old) {
+		if c.Fix && issue.CanFix() {
+			if err := issue.Fix(); err != nil {
+				return err
+			}
+			continue
+		}
+		fmt.Println(issue)
+		remaining++
+	}
+	for _, f := range set.Modified() {
+		info, err := os.Stat(f.Path)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(f.Path, []byte(f.String()), info.Mode().Perm()); err != nil {
+			return err
+		}
+	}
+	if remaining > 0 {
+		return fmt.Errorf("%d issues found", remaining)
+	}
+	return nil
+}
+
+func (c *LintConfig) AfterApply() error {
+	if len(c.Files) == 0 {
+		return fmt.Errorf(`missing .proto input file(s)`)
+	}
+	return nil
+}
@@ -0,0 +1,200 @@
+// Package lint checks protobuf source files for problems, some of which
+// can be fixed automatically.
+package lint
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/alecthomas/participle/v2/lexer"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	pb "google.golang.org/protobuf/types/descriptorpb"
+
+	"github.com/alecthomas/protobuf/edit"
+	"github.com/alecthomas/protobuf/parser"
+)
+
+// Issue is a problem found in a file.
+type Issue struct {
+	File    *edit.File
+	Pos     lexer.Position
+	Message string
+
+	fix func() error
+}
+
+func (i *Issue) String() string { return fmt.Sprintf("%s: %s", i.Pos, i.Message) }
+
+// CanFix returns true if the issue can be fixed automatically.
+func (i *Issue) CanFix() bool { return i.fix != nil }
+
+// Fix the issue by editing i.File.
+func (i *Issue) Fix() error {
+	if i.fix == nil {
+		return fmt.Errorf("%s: issue cannot be fixed automatically", i.Pos)
+	}
+	return i.fix()
+}
+
+// Message declared in a file.
+type Message struct {
+	File     *edit.File
+	FullName protoreflect.FullName
+	// Node is a *parser.Message or a *parser.Group.
+	Node parser.Node
+	// Entries of the message.
+	Entries []*parser.MessageEntry
+	Pos     lexer.Position
+}
+
+// Messages returns the messages declared in f, including nested messages
+// and groups, in the order they are declared.
+func Messages(f *edit.File) []*Message {
+	var pkg string
+	for _, e := range f.Proto().Entries {
+		if e.Package != "" {
+			pkg = e.Package
+		}
+	}
+	var out []*Message
+	var add func(scope protoreflect.FullName, name string, node parser.Node, entries []*parser.MessageEntry, pos lexer.Position)
+	addField := func(scope protoreflect.FullName, field *parser.Field) {
+		if field != nil && field.Group != nil {
+			add(scope, field.Group.Name, field.Group, field.Group.Entries, field.Group.Pos)
+		}
+	}
+	add = func(scope protoreflect.FullName, name string, node parser.Node, entries []*parser.MessageEntry, pos lexer.Position) {
+		full := protoreflect.FullName(name)
+		if scope != "" {
+			full = scope.Append(protoreflect.Name(name))
+		}
+		out = append(out, &Message{File: f, FullName: full, Node: node, Entries: entries, Pos: pos})
+		for _, e := range entries {
+			switch {
+			case e.Message != nil:
+				add(full, e.Message.Name, e.Message, e.Message.Entries, e.Message.Pos)
+			case e.Field != nil:
+				addField(full, e.Field)
+			case e.Oneof != nil:
+				for _, oe := range e.Oneof.Entries {
+					addField(full, oe.Field)
+				}
+			}
+		}
+	}
+	for _, e := range f.Proto().Entries {
+		if e.Message != nil {
+			add(protoreflect.FullName(pkg), e.Message.Name, e.Message, e.Message.Entries, e.Message.Pos)
+		}
+	}
+	return out
+}
+
+// FieldNumbers checks the field numbers of the messages of files:
+//
+//   - gaps of unused numbers below the highest field number of a message
+//     that are neither reserved nor in an extension range.
+//   - numbers and names of fields of the message in old, a previous
+//     version of the files, that are no longer used and are not
+//     reserved. These are fixed by inserting reserved statements.
+//   - numbers of fields in old that are now used by a different field.
+//
+// old may be nil to only check for gaps.
+func FieldNumbers(files []*edit.File, old *pb.FileDescriptorSet) []*Issue {
+	previous := map[protoreflect.FullName]*pb.DescriptorProto{}
+	if old != nil {
+		for _, fd := range old.File {
+			for _, md := range fd.GetMessageType() {
+				addDescriptor(previous, protoreflect.FullName(fd.GetPackage()), md)
+			}
+		}
+	}
+	var issues []*Issue
+	for _, f := range files {
+		for _, msg := range Messages(f) {
+			numbers := MessageNumbers(msg.Entries)
+			var deleted []*Issue
+			if md, ok := previous[msg.FullName]; ok {
+				var rs []Range
+				deleted, rs = deletedFields(msg, numbers, md)
+				// Deleted fields are reported as such rather than as gaps.
+				numbers.Reserved = append(numbers.Reserved, rs...)
+			}
+			for _, gap := range numbers.Gaps() {
+				message := fmt.Sprintf("%s: field numbers %s are unused but not reserved", msg.FullName, gap)
+				if gap.Start == gap.End {
+					message = fmt.Sprintf("%s: field number %s is unused but not reserved", msg.FullName, gap)
+				}
+				issues = append(issues, &Issue{File: f, Pos: msg.Pos, Message: message})
+			}
+			issues = append(issues, deleted...)
+		}
+	}
+	return issues
+}
+
+func addDescriptor(descriptors map[protoreflect.FullName]*pb.DescriptorProto, scope protoreflect.FullName, md *pb.DescriptorProto) {
+	name := protoreflect.FullName(md.GetName())
+	if scope != "" {
+		name = scope.Append(protoreflect.Name(md.GetName()))
+	}
+	descriptors[name] = md
+	for _, nested := range md.GetNestedType() {
+		addDescriptor(descriptors, name, nested)
+	}
+}
+
+// deletedFields returns the issues of the fields of the previous version
+// md of a message that have been deleted, and the ranges of the numbers
+// of deleted fields that are not reserved.
+func deletedFields(msg *Message, numbers *Numbers, md *pb.DescriptorProto) ([]*Issue, []Range) {
+	var issues []*Issue
+	deleted := map[int]string{}
+	for _, field := range md.GetField() {
+		num, name := int(field.GetNumber()), field.GetName()
+		current, ok := numbers.Fields[num]
+		switch {
+		case ok && current != name:
+			issues = append(issues, &Issue{
+				File:    msg.File,
+				Pos:     msg.Pos,
+				Message: fmt.Sprintf("%s: field number %d of deleted field %s is reused by %s", msg.FullName, num, name, current),
+			})
+		case !ok && !numbers.IsReserved(num):
+			deleted[num] = name
+		}
+	}
+	if len(deleted) == 0 {
+		return issues, nil
+	}
+	var descriptions, names []string
+	var nums []int
+	for _, num := range sortedNumbers(deleted) {
+		name := deleted[num]
+		descriptions = append(descriptions, fmt.Sprintf("%d (%s)", num, name))
+		nums = append(nums, num)
+		if !numbers.HasField(name) && !numbers.IsReservedName(name) {
+			names = append(names, strconv.Quote(name))
+		}
+	}
+	deletedRanges := ranges(nums)
+	var rs []string
+	for _, r := range deletedRanges {
+		rs = append(rs, r.String())
+	}
+	return append(issues, &Issue{
+		File:    msg.File,
+		Pos:     msg.Pos,
+		Message: fmt.Sprintf("%s: field numbers of deleted fields are not reserved: %s", msg.FullName, strings.Join(descriptions, ", ")),
+		fix: func() error {
+			if err := msg.File.InsertReserved(msg.Node, "reserved "+strings.Join(rs, ", ")+";"); err != nil {
+				return err
+			}
+			if len(names) == 0 {
+				return nil
+			}
+			return msg.File.InsertReserved(msg.Node, "reserved "+strings.Join(names, ", ")+";")
+		},
+	}), deletedRanges
+}
@@ -0,0 +1,106 @@
+package lint
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/alecthomas/protobuf/compiler"
+	"github.com/alecthomas/protobuf/edit"
+	"github.com/alecthomas/protobuf/parser"
+)
+
+func TestNumbers(t *testing.T) {
+	proto, err := parser.ParseString("test.proto", `
+message Msg {
+  reserved 2, 4 to 6;
+  extensions 100 to 199;
+  int32 a = 1;
+  oneof o {
+    int32 b = 3;
+  }
+  int32 c = 10;
+  int32 d = 99;
+}
+
+message Full {
+  reserved 1 to 18999, 20000 to max;
+}
+`)
+	require.NoError(t, err)
+	msg := MessageNumbers(proto.Entries[0].Message.Entries)
+	require.Equal(t, map[int]string{1: "a", 3: "b", 10: "c", 99: "d"}, msg.Fields)
+	next, ok := msg.Next()
+	require.True(t, ok)
+	require.Equal(t, 200, next)
+	require.Equal(t, []Range{{7, 9}, {11, 98}}, msg.Gaps())
+
+	full := MessageNumbers(proto.Entries[1].Message.Entries)
+	_, ok = full.Next()
+	require.False(t, ok)
+	require.Empty(t, full.Gaps())
+
+	empty := MessageNumbers(nil)
+	next, ok = empty.Next()
+	require.True(t, ok)
+	require.Equal(t, 1, next)
+
+	require.Equal(t, "4", Range{4, 4}.String())
+	require.Equal(t, "4 to 6", Range{4, 6}.String())
+	require.Equal(t, "4 to max", Range{4, MaxFieldNumber}.String())
+}
+
+func TestFieldNumbers(t *testing.T) {
+	old, err := compiler.Compile([]string{"numbers.proto"}, []string{"testdata/old"}, false)
+	require.NoError(t, err)
+	r, err := os.Open("testdata/numbers.proto")
+	require.NoError(t, err)
+	defer r.Close()
+	f, err := edit.Parse("numbers.proto", r)
+	require.NoError(t, err)
+
+	issues := FieldNumbers([]*edit.File{f}, old)
+	var messages []string
+	for _, issue := range issues {
+		messages = append(messages, issue.String())
+	}
+	require.Equal(t, []string{
+		"numbers.proto:5:1: numbers.User: field numbers 6 to 7 are unused but not reserved",
+		"numbers.proto:5:1: numbers.User: field number 9 is unused but not reserved",
+		"numbers.proto:5:1: numbers.User: field number 5 of deleted field nickname is reused by alias",
+		"numbers.proto:5:1: numbers.User: field numbers of deleted fields are not reserved: 2 (email), 4 (created)",
+		"numbers.proto:15:3: numbers.User.Settings: field numbers of deleted fields are not reserved: 2 (beta)",
+	}, messages)
+
+	for _, issue := range issues {
+		if issue.CanFix() {
+			require.NoError(t, issue.Fix())
+		} else {
+			require.Error(t, issue.Fix())
+		}
+	}
+	require.Equal(t, `syntax = "proto3";
+
+package numbers;
+
+message User {
+  reserved 3;
+  reserved 2, 4;
+  reserved "email", "created";
+
+  string name = 1;
+  string alias = 5;
+  repeated string tags = 8;
+  oneof contact {
+    string address = 10;
+  }
+
+  message Settings {
+    reserved 2;
+    reserved "beta";
+    bool dark = 1;
+  }
+}
+`, f.String())
+}
@@ -0,0 +1,224 @@
+package lint
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/alecthomas/protobuf/parser"
+)
+
+// MaxFieldNumber is the largest valid field number.
+const MaxFieldNumber = 1<<29 - 1
+
+// implementationReserved are the field numbers reserved for the
+// implementation of protobuf, which cannot be used by fields.
+var implementationReserved = Range{Start: 19000, End: 19999}
+
+// Range of field numbers, inclusive.
+type Range struct {
+	Start int
+	End   int
+}
+
+func newRange(r *parser.Range) Range {
+	start := int(r.Start.Int64())
+	switch {
+	case r.Max:
+		return Range{Start: start, End: MaxFieldNumber}
+	case r.End != nil:
+		return Range{Start: start, End: int(r.End.Int64())}
+	default:
+		return Range{Start: start, End: start}
+	}
+}
+
+func (r Range) contains(n int) bool { return r.Start <= n && n <= r.End }
+
+// String returns the range as it is written in a reserved statement,
+// such as "4", "6 to 8" or "100 to max".
+func (r Range) String() string {
+	switch {
+	case r.Start == r.End:
+		return fmt.Sprint(r.Start)
+	case r.End == MaxFieldNumber:
+		return fmt.Sprintf("%d to max", r.Start)
+	default:
+		return fmt.Sprintf("%d to %d", r.Start, r.End)
+	}
+}
+
+// ranges merges sorted numbers into ranges of consecutive numbers.
+func ranges(numbers []int) []Range {
+	var out []Range
+	for _, n := range numbers {
+		if len(out) > 0 && out[len(out)-1].End == n-1 {
+			out[len(out)-1].End = n
+			continue
+		}
+		out = append(out, Range{Start: n, End: n})
+	}
+	return out
+}
+
+// Numbers are the field numbers used and reserved by a message.
+type Numbers struct {
+	// Fields maps the numbers of the fields of the message, including
+	// those in oneofs, to their names.
+	Fields map[int]string
+	// Reserved numbers and names.
+	Reserved      []Range
+	ReservedNames []string
+	// Extensions are the extension ranges of the message.
+	Extensions []Range
+}
+
+// MessageNumbers returns the numbers of a message from the entries of a
+// *parser.Message or *parser.Group.
+func MessageNumbers(entries []*parser.MessageEntry) *Numbers {
+	n := &Numbers{Fields: map[int]string{}}
+	addField := func(f *parser.Field) {
+		switch {
+		case f.Direct != nil:
+			n.Fields[int(f.Direct.Tag.Int64())] = f.Direct.Name
+		case f.Group != nil:
+			n.Fields[int(f.Group.Tag.Int64())] = strings.ToLower(f.Group.Name)
+		}
+	}
+	for _, e := range entries {
+		switch {
+		case e.Field != nil:
+			addField(e.Field)
+		case e.Oneof != nil:
+			for _, oe := range e.Oneof.Entries {
+				if oe.Field != nil {
+					addField(oe.Field)
+				}
+			}
+		case e.Reserved != nil:
+			for _, r := range e.Reserved.Ranges {
+				n.Reserved = append(n.Reserved, newRange(r))
+			}
+			n.ReservedNames = append(n.ReservedNames, e.Reserved.FieldNames...)
+		case e.Extensions != nil:
+			for _, r := range e.Extensions.Extensions {
+				n.Extensions = append(n.Extensions, newRange(r))
+			}
+		}
+	}
+	return n
+}
+
+// Next returns the next free field number: the smallest number after the
+// highest field number that is not reserved or in an extension range, or
+// else the smallest free number. It returns false if there are no free
+// numbers.
+func (n *Numbers) Next() (int, bool) {
+	max := 0
+	for num := range n.Fields {
+		if num > max {
+			max = num
+		}
+	}
+	if num, ok := n.free(max + 1); ok {
+		return num, true
+	}
+	return n.free(1)
+}
+
+// Gaps returns the free numbers below the highest field number.
+func (n *Numbers) Gaps() []Range {
+	max := 0
+	for num := range n.Fields {
+		if num > max {
+			max = num
+		}
+	}
+	var gaps []Range
+	for num := 1; num < max; {
+		if r, ok := n.taken(num); ok {
+			num = r.End + 1
+			continue
+		}
+		start := num
+		for num < max {
+			if _, ok := n.taken(num); ok {
+				break
+			}
+			num++
+		}
+		gaps = append(gaps, Range{Start: start, End: num - 1})
+	}
+	return gaps
+}
+
+// IsReserved returns true if num is reserved.
+func (n *Numbers) IsReserved(num int) bool {
+	for _, r := range n.Reserved {
+		if r.contains(num) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsReservedName returns true if name is reserved.
+func (n *Numbers) IsReservedName(name string) bool {
+	for _, reserved := range n.ReservedNames {
+		if reserved == name {
+			return true
+		}
+	}
+	return false
+}
+
+// HasField returns true if a field of the message is named name.
+func (n *Numbers) HasField(name string) bool {
+	for _, field := range n.Fields {
+		if field == name {
+			return true
+		}
+	}
+	return false
+}
+
+// free returns the smallest free number from num.
+func (n *Numbers) free(num int) (int, bool) {
+	for num <= MaxFieldNumber {
+		r, ok := n.taken(num)
+		if !ok {
+			return num, true
+		}
+		num = r.End + 1
+	}
+	return 0, false
+}
+
+// taken returns the range of numbers containing num that cannot be used
+// by a new field.
+func (n *Numbers) taken(num int) (Range, bool) {
+	if _, ok := n.Fields[num]; ok {
+		return Range{Start: num, End: num}, true
+	}
+	if implementationReserved.contains(num) {
+		return implementationReserved, true
+	}
+	for _, ranges := range [][]Range{n.Reserved, n.Extensions} {
+		for _, r := range ranges {
+			if r.contains(num) {
+				return r, true
+			}
+		}
+	}
+	return Range{}, false
+}
+
+// sortedNumbers returns the keys of m, sorted.
+func sortedNumbers(m map[int]string) []int {
+	numbers := make([]int, 0, len(m))
+	for num := range m {
+		numbers = append(numbers, num)
+	}
+	sort.Ints(numbers)
+	return numbers
+}
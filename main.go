@@ -19,7 +19,15 @@ var (
 protobuf creates FileDescriptorSet files (.pb) from Proto source files (.proto).
 `
 	cli struct {
-		CompileConfig
+		Compile CompileConfig    `cmd:"" default:"withargs" help:"Compile .proto files to a FileDescriptorSet (default)."`
+		OpenAPI OpenAPIConfig    `cmd:"" name:"openapi" help:"Generate an OpenAPI v3 document from services annotated with google.api.http."`
+		Rename  RenameConfig     `cmd:"" help:"Rename a message or enum and update all references to it."`
+		Lint    LintConfig       `cmd:"" help:"Check field numbers for gaps and deleted fields that are not reserved."`
+		Next    NextNumberConfig `cmd:"" name:"next-number" help:"Print the next free field number of a message."`
+		Call    CallConfig       `cmd:"" help:"Call a gRPC method with JSON requests, printing the responses as JSON."`
+		Mock    MockConfig       `cmd:"" help:"Serve the services of .proto files with responses from fixtures or fake data."`
+		Gen     GenConfig        `cmd:"" help:"Generate random messages for seeding fuzzers."`
+		Inspect InspectConfig    `cmd:"" help:"Dump a message in the binary format annotated with its fields."`
 		Version kong.VersionFlag `help:"Show version."`
 	}
 )
@@ -28,6 +36,7 @@ type CompileConfig struct {
 	ProtoPath        []string `short:"I" help:"Search paths for proto imports."`
 	DescriptorSetOut string   `short:"o" required:"" help:"FileDescriptorSet output file"`
 	IncludeImports   bool     `help:"Include all dependencies of the input files so that the set is self-contained."`
+	RetainOptions    bool     `help:"Keep options with source retention in the FileDescriptorSet."`
 	Files            []string `arg:"" help:"Import proto files"`
 }
 
@@ -40,7 +49,11 @@ func main() {
 }
 
 func (c *CompileConfig) Run() error {
-	fds, err := compiler.Compile(c.Files, c.ProtoPath, c.IncludeImports)
+	var options []compiler.Option
+	if c.RetainOptions {
+		options = append(options, compiler.RetainOptions())
+	}
+	fds, err := compiler.Compile(c.Files, c.ProtoPath, c.IncludeImports, options...)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"google.golang.org/grpc"
+
+	"github.com/alecthomas/protobuf/compiler"
+	"github.com/alecthomas/protobuf/mock"
+	"github.com/alecthomas/protobuf/reflection"
+)
+
+type MockConfig struct {
+	ProtoPath []string `short:"I" help:"Search paths for proto imports."`
+	Fixtures  string   `type:"existingdir" help:"Directory of JSON fixtures named <pkg.Service>/<Method>.json, each holding a sequence of response messages."`
+	Listen    string   `default:"127.0.0.1:50051" help:"Address to serve gRPC on."`
+	HTTP      string   `name:"http" help:"Address to also serve gRPC-Web and the Connect protocol on, over HTTP/1.1."`
+	Files     []string `arg:"" help:"Proto files declaring the services to mock."`
+}
+
+func (c *MockConfig) Run() error {
+	result, err := compiler.Build(c.Files, c.ProtoPath, true)
+	if err != nil {
+		return err
+	}
+	reg := result.Registry()
+	var options []mock.Option
+	if c.Fixtures != "" {
+		options = append(options, mock.Fixtures(c.Fixtures))
+	}
+	m, err := mock.New(reg, options...)
+	if err != nil {
+		return err
+	}
+	lis, err := net.Listen("tcp", c.Listen)
+	if err != nil {
+		return err
+	}
+	gs := grpc.NewServer()
+	m.Register(gs)
+	reflection.Register(gs, reg)
+	errs := make(chan error, 2)
+	fmt.Fprintf(os.Stderr, "serving gRPC on %s\n", lis.Addr())
+	go func() { errs <- gs.Serve(lis) }()
+	if c.HTTP != "" {
+		hs := &http.Server{Addr: c.HTTP, Handler: m, ReadHeaderTimeout: 10 * time.Second}
+		fmt.Fprintf(os.Stderr, "serving gRPC-Web and Connect on %s\n", c.HTTP)
+		go func() {
+			if err := hs.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+				errs <- err
+			}
+		}()
+	}
+	return <-errs
+}
+
+func (c *MockConfig) AfterApply() error {
+	if len(c.Files) == 0 {
+		return fmt.Errorf(`missing .proto input file(s)`)
+	}
+	return nil
+}
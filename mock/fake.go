@@ -0,0 +1,197 @@
+package mock
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"time"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// fakeTime is the time of fake timestamps.
+var fakeTime = time.Date(2024, time.January, 2, 15, 4, 5, 0, time.UTC)
+
+var fakeNames = []string{"Ada Lovelace", "Alan Turing", "Grace Hopper"}
+
+// Fill sets the fields of m to fake values, which depend only on the
+// descriptors of the fields, so that m is the same every time. Values
+// are chosen by the kind and name of each field, such as an email
+// address for a string field named "email". Repeated fields and maps
+// have two elements, only the first field of a oneof is set, and fields
+// of a message type that is already being filled are left empty.
+func Fill(m protoreflect.Message) {
+	fill(m, map[protoreflect.FullName]bool{})
+}
+
+func fill(m protoreflect.Message, filling map[protoreflect.FullName]bool) {
+	md := m.Descriptor()
+	switch md.FullName() {
+	case "google.protobuf.Timestamp":
+		m.Set(md.Fields().ByName("seconds"), protoreflect.ValueOfInt64(fakeTime.Unix()))
+		return
+	case "google.protobuf.Duration":
+		m.Set(md.Fields().ByName("seconds"), protoreflect.ValueOfInt64(60))
+		return
+	case "google.protobuf.Any", "google.protobuf.FieldMask":
+		// Their JSON forms depend on the values of their fields.
+		return
+	}
+	filling[md.FullName()] = true
+	defer delete(filling, md.FullName())
+	fields := md.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		if od := fd.ContainingOneof(); od != nil && m.WhichOneof(od) != nil {
+			continue
+		}
+		value := fd
+		if fd.IsMap() {
+			value = fd.MapValue()
+		}
+		if value.Message() != nil && filling[value.Message().FullName()] {
+			continue
+		}
+		switch {
+		case fd.IsMap():
+			mp := m.Mutable(fd).Map()
+			for j := 0; j < 2; j++ {
+				mp.Set(fakeValue(fd.MapKey(), j).MapKey(), fakeElement(mp.NewValue, value, j, filling))
+			}
+		case fd.IsList():
+			list := m.Mutable(fd).List()
+			for j := 0; j < 2; j++ {
+				list.Append(fakeElement(list.NewElement, fd, j, filling))
+			}
+		case fd.Message() != nil:
+			fill(m.Mutable(fd).Message(), filling)
+		default:
+			m.Set(fd, fakeValue(fd, 0))
+		}
+	}
+}
+
+// fakeElement returns the ith fake element of a list or map, creating
+// messages with newElement.
+func fakeElement(newElement func() protoreflect.Value, fd protoreflect.FieldDescriptor, i int, filling map[protoreflect.FullName]bool) protoreflect.Value {
+	if fd.Message() == nil {
+		return fakeValue(fd, i)
+	}
+	v := newElement()
+	fill(v.Message(), filling)
+	return v
+}
+
+// fakeValue returns the ith fake value of a scalar or enum field.
+func fakeValue(fd protoreflect.FieldDescriptor, i int) protoreflect.Value {
+	name := strings.ToLower(string(fd.Name()))
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		return protoreflect.ValueOfBool(true)
+	case protoreflect.EnumKind:
+		return protoreflect.ValueOfEnum(fakeEnum(fd.Enum(), i))
+	case protoreflect.StringKind:
+		return protoreflect.ValueOfString(fakeString(name, i))
+	case protoreflect.BytesKind:
+		return protoreflect.ValueOfBytes([]byte(fakeString(name, i)))
+	case protoreflect.FloatKind:
+		return protoreflect.ValueOfFloat32(float32(fakeFloat(fd, name, i)))
+	case protoreflect.DoubleKind:
+		return protoreflect.ValueOfFloat64(fakeFloat(fd, name, i))
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		return protoreflect.ValueOfInt32(int32(fakeInt(fd, name, i)))
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return protoreflect.ValueOfInt64(fakeInt(fd, name, i))
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		return protoreflect.ValueOfUint32(uint32(fakeInt(fd, name, i)))
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return protoreflect.ValueOfUint64(uint64(fakeInt(fd, name, i)))
+	}
+	return fd.Default()
+}
+
+// fakeEnum returns the ith value of ed that is not zero, or zero if it
+// has no other values.
+func fakeEnum(ed protoreflect.EnumDescriptor, i int) protoreflect.EnumNumber {
+	var numbers []protoreflect.EnumNumber
+	for j := 0; j < ed.Values().Len(); j++ {
+		if n := ed.Values().Get(j).Number(); n != 0 {
+			numbers = append(numbers, n)
+		}
+	}
+	if len(numbers) == 0 {
+		return 0
+	}
+	return numbers[i%len(numbers)]
+}
+
+func fakeString(name string, i int) string {
+	n := i + 1
+	switch {
+	case strings.Contains(name, "email"):
+		return fmt.Sprintf("user%d@example.com", n)
+	case strings.Contains(name, "url"), strings.Contains(name, "uri"), strings.Contains(name, "link"), strings.Contains(name, "website"):
+		return fmt.Sprintf("https://example.com/%d", n)
+	case strings.Contains(name, "phone"):
+		return fmt.Sprintf("+1-555-010%d", n)
+	case strings.Contains(name, "uuid"):
+		return fmt.Sprintf("00000000-0000-4000-8000-00000000000%d", n)
+	case name == "id" || strings.HasSuffix(name, "_id"):
+		return fmt.Sprintf("%s_%d", strings.TrimSuffix(name, "_id"), n)
+	case strings.Contains(name, "first_name") || name == "firstname" || name == "given_name":
+		return strings.Fields(fakeNames[i%len(fakeNames)])[0]
+	case strings.Contains(name, "last_name") || name == "lastname" || name == "family_name":
+		return strings.Fields(fakeNames[i%len(fakeNames)])[1]
+	case strings.Contains(name, "name"):
+		return fakeNames[i%len(fakeNames)]
+	case strings.HasSuffix(name, "_at"), strings.Contains(name, "time"):
+		return fakeTime.Add(time.Duration(i) * time.Hour).Format(time.RFC3339)
+	case strings.Contains(name, "date"):
+		return fakeTime.AddDate(0, 0, i).Format("2006-01-02")
+	case strings.Contains(name, "currency"):
+		return "USD"
+	case strings.Contains(name, "country"):
+		return "NZ"
+	case strings.Contains(name, "city"):
+		return "Wellington"
+	case strings.Contains(name, "address"):
+		return fmt.Sprintf("%d Example Street", n)
+	case strings.Contains(name, "colo"):
+		return "#336699"
+	}
+	return fmt.Sprintf("%s %d", name, n)
+}
+
+func fakeInt(fd protoreflect.FieldDescriptor, name string, i int) int64 {
+	switch {
+	case strings.Contains(name, "year"):
+		return int64(fakeTime.Year() + i)
+	case strings.HasSuffix(name, "_at"), strings.Contains(name, "time"):
+		return fakeTime.Unix() + int64(i)*3600
+	case name == "age" || strings.HasSuffix(name, "_age"):
+		return int64(36 + i)
+	case strings.Contains(name, "port"):
+		return int64(8080 + i)
+	}
+	return hash(fd)%100 + 1 + int64(i)
+}
+
+func fakeFloat(fd protoreflect.FieldDescriptor, name string, i int) float64 {
+	switch {
+	case strings.HasPrefix(name, "lat"):
+		return -41.2865
+	case strings.HasPrefix(name, "lon") || strings.HasPrefix(name, "lng"):
+		return 174.7762
+	case strings.Contains(name, "price"), strings.Contains(name, "amount"), strings.Contains(name, "cost"):
+		return 9.99 + float64(i)*10
+	}
+	return float64(hash(fd)%100+1+int64(i)) + 0.5
+}
+
+// hash returns a number derived from the full name of fd, so that fields
+// that are not recognised by name have different values.
+func hash(fd protoreflect.FieldDescriptor) int64 {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(fd.FullName()))
+	return int64(h.Sum32())
+}
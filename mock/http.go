@@ -0,0 +1,269 @@
+package mock
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// Flags of the envelopes of messages in gRPC-Web and Connect streams.
+const (
+	flagCompressed  = 0x01
+	flagEndStream   = 0x02
+	flagGRPCTrailer = 0x80
+)
+
+// ServeHTTP serves the methods over HTTP/1.1 for browsers, with gRPC-Web
+// and the Connect protocol in the proto and JSON encodings. Requests are
+// allowed from any origin. Calls are half duplex, as HTTP/1.1 servers
+// cannot read requests after writing responses: all requests are read
+// before responses are sent.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Expose-Headers", "Grpc-Status, Grpc-Message")
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", r.Header.Get("Access-Control-Request-Headers"))
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST, OPTIONS")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	i := strings.LastIndexByte(path, '/')
+	if i < 0 {
+		http.NotFound(w, r)
+		return
+	}
+	md, err := s.method(path[:i] + "." + path[i+1:])
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch {
+	case contentType == "application/grpc-web" || strings.HasPrefix(contentType, "application/grpc-web+"):
+		if c, ok := s.codec(strings.TrimPrefix(strings.TrimPrefix(contentType, "application/grpc-web"), "+")); ok {
+			s.serveGRPCWeb(w, r, md, contentType, c)
+			return
+		}
+	case strings.HasPrefix(contentType, "application/connect+"):
+		if c, ok := s.codec(strings.TrimPrefix(contentType, "application/connect+")); ok {
+			s.serveConnectStream(w, r, md, contentType, c)
+			return
+		}
+	case strings.HasPrefix(contentType, "application/"):
+		// Unary methods are called without envelopes.
+		if c, ok := s.codec(strings.TrimPrefix(contentType, "application/")); ok && !md.IsStreamingClient() && !md.IsStreamingServer() {
+			s.serveConnectUnary(w, r, md, contentType, c)
+			return
+		}
+	}
+	http.Error(w, fmt.Sprintf("unsupported content type %q for %s", contentType, md.FullName()), http.StatusUnsupportedMediaType)
+}
+
+// serveConnectUnary serves a unary method with the Connect protocol.
+func (s *Server) serveConnectUnary(w http.ResponseWriter, r *http.Request, md protoreflect.MethodDescriptor, contentType string, c codec) {
+	if r.Header.Get("Content-Encoding") != "" && r.Header.Get("Content-Encoding") != "identity" {
+		writeConnectError(w, status.Errorf(codes.Unimplemented, "unsupported content encoding %q", r.Header.Get("Content-Encoding")))
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeConnectError(w, status.Error(codes.InvalidArgument, err.Error()))
+		return
+	}
+	received := false
+	var resp []byte
+	err = s.serve(md,
+		func(m proto.Message) error {
+			if received {
+				return io.EOF
+			}
+			received = true
+			if err := c.unmarshal(body, m); err != nil {
+				return status.Error(codes.InvalidArgument, err.Error())
+			}
+			return nil
+		},
+		func(m proto.Message) (err error) {
+			resp, err = c.marshal(m)
+			return err
+		})
+	if err != nil {
+		writeConnectError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	_, _ = w.Write(resp)
+}
+
+// serveConnectStream serves a streaming method with the Connect protocol.
+func (s *Server) serveConnectStream(w http.ResponseWriter, r *http.Request, md protoreflect.MethodDescriptor, contentType string, c codec) {
+	w.Header().Set("Content-Type", contentType)
+	body, err := io.ReadAll(r.Body)
+	if err == nil {
+		err = s.serve(md, recvEnvelope(bytes.NewReader(body), c), sendEnvelope(w, c))
+	}
+	end := map[string]interface{}{}
+	if err != nil {
+		end["error"] = connectError(err)
+	}
+	b, err := json.Marshal(end)
+	if err != nil {
+		return
+	}
+	_ = writeEnvelope(w, flagEndStream, b)
+}
+
+// serveGRPCWeb serves a method with gRPC-Web in its binary format.
+func (s *Server) serveGRPCWeb(w http.ResponseWriter, r *http.Request, md protoreflect.MethodDescriptor, contentType string, c codec) {
+	w.Header().Set("Content-Type", contentType)
+	body, err := io.ReadAll(r.Body)
+	if err == nil {
+		err = s.serve(md, recvEnvelope(bytes.NewReader(body), c), sendEnvelope(w, c))
+	}
+	st := status.Convert(err)
+	trailer := fmt.Sprintf("grpc-status: %d\r\ngrpc-message: %s\r\n", st.Code(), percentEncode(st.Message()))
+	_ = writeEnvelope(w, flagGRPCTrailer, []byte(trailer))
+}
+
+// codec encodes messages in the proto or JSON encoding.
+type codec struct {
+	marshal   func(proto.Message) ([]byte, error)
+	unmarshal func([]byte, proto.Message) error
+}
+
+func (s *Server) codec(name string) (codec, bool) {
+	switch name {
+	case "", "proto":
+		return codec{marshal: proto.Marshal, unmarshal: proto.Unmarshal}, true
+	case "json":
+		return codec{
+			marshal:   protojson.MarshalOptions{Resolver: s.reg}.Marshal,
+			unmarshal: protojson.UnmarshalOptions{Resolver: s.reg}.Unmarshal,
+		}, true
+	}
+	return codec{}, false
+}
+
+// recvEnvelope returns a function receiving messages from the envelopes
+// of a request body.
+func recvEnvelope(r *bytes.Reader, c codec) func(proto.Message) error {
+	return func(m proto.Message) error {
+		var prefix [5]byte
+		if _, err := io.ReadFull(r, prefix[:]); err != nil {
+			if errors.Is(err, io.EOF) {
+				return io.EOF
+			}
+			return status.Error(codes.InvalidArgument, err.Error())
+		}
+		if prefix[0]&flagCompressed != 0 {
+			return status.Error(codes.Unimplemented, "compressed messages are not supported")
+		}
+		// The length is checked against the rest of the body so that it
+		// does not make the server allocate more than the client sent.
+		n := binary.BigEndian.Uint32(prefix[1:])
+		if int64(n) > int64(r.Len()) {
+			return status.Error(codes.InvalidArgument, io.ErrUnexpectedEOF.Error())
+		}
+		b := make([]byte, n)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return status.Error(codes.InvalidArgument, io.ErrUnexpectedEOF.Error())
+		}
+		if err := c.unmarshal(b, m); err != nil {
+			return status.Error(codes.InvalidArgument, err.Error())
+		}
+		return nil
+	}
+}
+
+// sendEnvelope returns a function sending messages in envelopes of a
+// response body.
+func sendEnvelope(w http.ResponseWriter, c codec) func(proto.Message) error {
+	return func(m proto.Message) error {
+		b, err := c.marshal(m)
+		if err != nil {
+			return err
+		}
+		return writeEnvelope(w, 0, b)
+	}
+}
+
+func writeEnvelope(w http.ResponseWriter, flags byte, b []byte) error {
+	var buf bytes.Buffer
+	buf.WriteByte(flags)
+	_ = binary.Write(&buf, binary.BigEndian, uint32(len(b)))
+	buf.Write(b)
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return nil
+}
+
+// connectCodes are the names and HTTP statuses of gRPC codes in the
+// Connect protocol.
+var connectCodes = map[codes.Code]struct {
+	name   string
+	status int
+}{
+	codes.Canceled:           {"canceled", 499},
+	codes.Unknown:            {"unknown", http.StatusInternalServerError},
+	codes.InvalidArgument:    {"invalid_argument", http.StatusBadRequest},
+	codes.DeadlineExceeded:   {"deadline_exceeded", http.StatusGatewayTimeout},
+	codes.NotFound:           {"not_found", http.StatusNotFound},
+	codes.AlreadyExists:      {"already_exists", http.StatusConflict},
+	codes.PermissionDenied:   {"permission_denied", http.StatusForbidden},
+	codes.ResourceExhausted:  {"resource_exhausted", http.StatusTooManyRequests},
+	codes.FailedPrecondition: {"failed_precondition", http.StatusBadRequest},
+	codes.Aborted:            {"aborted", http.StatusConflict},
+	codes.OutOfRange:         {"out_of_range", http.StatusBadRequest},
+	codes.Unimplemented:      {"unimplemented", http.StatusNotImplemented},
+	codes.Internal:           {"internal", http.StatusInternalServerError},
+	codes.Unavailable:        {"unavailable", http.StatusServiceUnavailable},
+	codes.DataLoss:           {"data_loss", http.StatusInternalServerError},
+	codes.Unauthenticated:    {"unauthenticated", http.StatusUnauthorized},
+}
+
+// connectError returns the JSON object of err in the Connect protocol.
+func connectError(err error) map[string]string {
+	st := status.Convert(err)
+	return map[string]string{"code": connectCodes[st.Code()].name, "message": st.Message()}
+}
+
+func writeConnectError(w http.ResponseWriter, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(connectCodes[status.Code(err)].status)
+	_ = json.NewEncoder(w).Encode(connectError(err))
+}
+
+// percentEncode encodes a grpc-message trailer.
+func percentEncode(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c >= ' ' && c <= '~' && c != '%' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
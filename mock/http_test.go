@@ -0,0 +1,163 @@
+package mock
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestServeHTTP(t *testing.T) {
+	tests := []struct {
+		name        string
+		method      string
+		contentType string
+		body        []byte
+		status      int
+		// frames are the envelopes of the response, or its body if it
+		// has none.
+		frames []string
+	}{
+		{name: "ConnectUnaryJSON", method: "SayHello", contentType: "application/json",
+			body:   []byte(`{"name": "Ada"}`),
+			status: http.StatusOK, frames: []string{`{"message":"Hello from a fixture"}`}},
+		{name: "ConnectUnaryJSONCharset", method: "SayHello", contentType: "application/json; charset=utf-8",
+			body:   []byte(`{"name": "Ada"}`),
+			status: http.StatusOK, frames: []string{`{"message":"Hello from a fixture"}`}},
+		{name: "ConnectUnaryProto", method: "SayHello", contentType: "application/proto",
+			body:   []byte("\x0a\x03Ada"),
+			status: http.StatusOK, frames: []string{"\x0a\x14Hello from a fixture"}},
+		{name: "ConnectServerStream", method: "LotsOfReplies", contentType: "application/connect+json",
+			body:   envelope(0, `{"name": "Ada"}`),
+			status: http.StatusOK, frames: []string{`{"message":"one"}`, `{"message":"two"}`, `{"message":"three"}`, "\x02{}"}},
+		{name: "ConnectStreamInvalid", method: "LotsOfGreetings", contentType: "application/connect+json",
+			body:   envelope(0, `{"name": "Ada"}`)[:7],
+			status: http.StatusOK, frames: []string{"\x02" + `{"error":{"code":"invalid_argument","message":"unexpected EOF"}}`}},
+		{name: "GRPCWebBidi", method: "BidiHello", contentType: "application/grpc-web+proto",
+			body:   append(envelope(0, "\x0a\x03Ada"), envelope(0, "\x0a\x03Bob")...),
+			status: http.StatusOK, frames: []string{"\x0a\x04ping", "\x0a\x04pong", "\x80grpc-status: 0\r\ngrpc-message: \r\n"}},
+		{name: "GRPCWebLengthBeyondBody", method: "SayHello", contentType: "application/grpc-web",
+			body:   []byte("\x00\xff\xff\xff\xff\x0a\x03Ada"),
+			status: http.StatusOK, frames: []string{"\x80grpc-status: 3\r\ngrpc-message: unexpected EOF\r\n"}},
+		{name: "GRPCWebCompressed", method: "SayHello", contentType: "application/grpc-web",
+			body:   envelope(flagCompressed, ""),
+			status: http.StatusOK, frames: []string{"\x80grpc-status: 12\r\ngrpc-message: compressed messages are not supported\r\n"}},
+		{name: "UnaryContentTypeOfStream", method: "LotsOfReplies", contentType: "application/json",
+			status: http.StatusUnsupportedMediaType},
+		{name: "UnknownContentType", method: "SayHello", contentType: "text/plain",
+			status: http.StatusUnsupportedMediaType},
+		{name: "UnknownMethod", method: "Unknown", contentType: "application/json",
+			status: http.StatusNotFound},
+	}
+	s := httptest.NewServer(newServer(t, newRegistry(t), Fixtures("testdata/fixtures")))
+	t.Cleanup(s.Close)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp, err := http.Post(s.URL+"/greeter.v1.Greeter/"+test.method, test.contentType, bytes.NewReader(test.body))
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			require.Equal(t, test.status, resp.StatusCode, "%s", body)
+			require.Equal(t, "*", resp.Header.Get("Access-Control-Allow-Origin"))
+			if test.frames == nil {
+				return
+			}
+			require.Equal(t, strings.Split(test.contentType, ";")[0], resp.Header.Get("Content-Type"))
+			got := []string{compact(body)}
+			if strings.Contains(test.contentType, "+") || test.contentType == "application/grpc-web" {
+				got = frames(t, body)
+			}
+			require.Equal(t, test.frames, got)
+		})
+	}
+}
+
+func TestServeHTTPUnaryError(t *testing.T) {
+	s := httptest.NewServer(newServer(t, newRegistry(t)))
+	t.Cleanup(s.Close)
+	resp, err := http.Post(s.URL+"/greeter.v1.Greeter/SayHello", "application/json", bytes.NewReader([]byte(`{"name": 1}`)))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	require.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+	var connectErr struct{ Code, Message string }
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&connectErr))
+	// The text of protojson errors is unstable.
+	require.Equal(t, "invalid_argument", connectErr.Code)
+	require.NotEmpty(t, connectErr.Message)
+}
+
+func TestServeHTTPFake(t *testing.T) {
+	s := httptest.NewServer(newServer(t, newRegistry(t)))
+	t.Cleanup(s.Close)
+	resp, err := http.Post(s.URL+"/greeter.v1.Greeter/SayHello", "application/proto", bytes.NewReader(nil))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	reg := newRegistry(t)
+	mt, err := reg.FindMessageByName("greeter.v1.HelloReply")
+	require.NoError(t, err)
+	reply := mt.New().Interface()
+	require.NoError(t, proto.Unmarshal(body, reply))
+	require.Equal(t, "message 1", message(reply))
+}
+
+func TestServeHTTPPreflight(t *testing.T) {
+	s := httptest.NewServer(newServer(t, newRegistry(t)))
+	t.Cleanup(s.Close)
+	req, err := http.NewRequest(http.MethodOptions, s.URL+"/greeter.v1.Greeter/SayHello", nil)
+	require.NoError(t, err)
+	req.Header.Set("Origin", "http://localhost:3000")
+	req.Header.Set("Access-Control-Request-Method", "POST")
+	req.Header.Set("Access-Control-Request-Headers", "content-type,x-grpc-web")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+	require.Equal(t, "*", resp.Header.Get("Access-Control-Allow-Origin"))
+	require.Equal(t, "content-type,x-grpc-web", resp.Header.Get("Access-Control-Allow-Headers"))
+}
+
+func envelope(flags byte, data string) []byte {
+	b := []byte{flags, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(b[1:], uint32(len(data)))
+	return append(b, data...)
+}
+
+// frames returns the data of the envelopes in b, prefixed by their flags
+// unless they are zero.
+func frames(t *testing.T, b []byte) []string {
+	t.Helper()
+	var frames []string
+	for len(b) > 0 {
+		require.GreaterOrEqual(t, len(b), 5)
+		n := int(binary.BigEndian.Uint32(b[1:5]))
+		require.GreaterOrEqual(t, len(b), 5+n)
+		frame := compact(b[5 : 5+n])
+		if b[0] != 0 {
+			frame = string(b[:1]) + frame
+		}
+		frames = append(frames, frame)
+		b = b[5+n:]
+	}
+	return frames
+}
+
+// compact returns b without the spaces of JSON, which protojson adds at
+// random.
+func compact(b []byte) string {
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, b); err != nil {
+		return string(b)
+	}
+	return buf.String()
+}
@@ -0,0 +1,218 @@
+// Package mock serves the services declared in a compiler.Registry with
+// responses built from fixtures or fake data, for developing clients
+// against APIs that are not implemented yet.
+package mock
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+
+	"github.com/alecthomas/protobuf/compiler"
+)
+
+// Server answers calls of the methods of the services declared in the
+// files of a registry. Methods without a fixture respond with a message
+// filled with Fill.
+type Server struct {
+	reg      *compiler.Registry
+	fixtures map[protoreflect.FullName][]proto.Message
+}
+
+type config struct {
+	fixtures string
+}
+
+// Option configures a Server.
+type Option func(*config)
+
+// Fixtures reads the responses of methods from the JSON files in dir,
+// named <pkg.Service>/<Method>.json. Each file holds a sequence of
+// messages in the protobuf JSON format. Methods streaming responses send
+// every message of their fixture, bidirectional streaming methods send
+// the next one for each request, and other methods take a single message.
+func Fixtures(dir string) Option {
+	return func(c *config) {
+		c.fixtures = dir
+	}
+}
+
+// New returns a Server for the services declared in the files of reg.
+func New(reg *compiler.Registry, options ...Option) (*Server, error) {
+	c := &config{}
+	for _, option := range options {
+		option(c)
+	}
+	s := &Server{reg: reg, fixtures: map[protoreflect.FullName][]proto.Message{}}
+	if c.fixtures != "" {
+		if err := s.loadFixtures(c.fixtures); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func (s *Server) loadFixtures(dir string) error {
+	files, err := filepath.Glob(filepath.Join(dir, "*", "*.json"))
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		service := filepath.Base(filepath.Dir(file))
+		method := strings.TrimSuffix(filepath.Base(file), ".json")
+		md, err := s.method(service + "." + method)
+		if err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+		responses, err := s.readFixture(file, md)
+		if err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+		s.fixtures[md.FullName()] = responses
+	}
+	return nil
+}
+
+func (s *Server) readFixture(file string, md protoreflect.MethodDescriptor) ([]proto.Message, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	output, err := s.reg.FindMessageByName(md.Output().FullName())
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(f)
+	options := protojson.UnmarshalOptions{Resolver: s.reg}
+	var responses []proto.Message
+	for {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		resp := output.New().Interface()
+		if err := options.Unmarshal(raw, resp); err != nil {
+			return nil, err
+		}
+		responses = append(responses, resp)
+	}
+	switch {
+	case len(responses) == 0:
+		return nil, fmt.Errorf("no responses for %s", md.FullName())
+	case len(responses) > 1 && !md.IsStreamingServer():
+		return nil, fmt.Errorf("%s returns a single response message", md.FullName())
+	}
+	return responses, nil
+}
+
+// method returns the method with the given full name, as
+// "pkg.Service.Method".
+func (s *Server) method(name string) (protoreflect.MethodDescriptor, error) {
+	desc, err := s.reg.FindDescriptorByName(protoreflect.FullName(name))
+	if err != nil {
+		return nil, fmt.Errorf("unknown method %s", name)
+	}
+	md, ok := desc.(protoreflect.MethodDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a method", name)
+	}
+	return md, nil
+}
+
+// Register registers the services declared in the files of the registry
+// on s.
+func (s *Server) Register(gs grpc.ServiceRegistrar) {
+	s.reg.RangeFiles(func(fd protoreflect.FileDescriptor) bool {
+		for i := 0; i < fd.Services().Len(); i++ {
+			sd := fd.Services().Get(i)
+			desc := &grpc.ServiceDesc{ServiceName: string(sd.FullName()), HandlerType: (*interface{})(nil), Metadata: fd.Path()}
+			for j := 0; j < sd.Methods().Len(); j++ {
+				md := sd.Methods().Get(j)
+				// Every method is a stream, so that requests are received
+				// with the types of the registry.
+				desc.Streams = append(desc.Streams, grpc.StreamDesc{
+					StreamName:    string(md.Name()),
+					ServerStreams: true,
+					ClientStreams: true,
+					Handler: func(_ interface{}, stream grpc.ServerStream) error {
+						return s.serve(md,
+							func(m proto.Message) error { return stream.RecvMsg(m) },
+							func(m proto.Message) error { return stream.SendMsg(m) })
+					},
+				})
+			}
+			gs.RegisterService(desc, struct{}{})
+		}
+		return true
+	})
+}
+
+// serve answers a call of md, receiving requests with recv until it
+// returns io.EOF and sending responses with send.
+func (s *Server) serve(md protoreflect.MethodDescriptor, recv, send func(proto.Message) error) error {
+	input, err := s.reg.FindMessageByName(md.Input().FullName())
+	if err != nil {
+		return err
+	}
+	responses, err := s.responses(md)
+	if err != nil {
+		return err
+	}
+	bidi := md.IsStreamingClient() && md.IsStreamingServer()
+	n := 0
+	for {
+		if err := recv(input.New().Interface()); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return err
+		}
+		if bidi {
+			if err := send(responses[n%len(responses)]); err != nil {
+				return err
+			}
+		}
+		n++
+		if !md.IsStreamingClient() {
+			break
+		}
+	}
+	switch {
+	case bidi:
+		return nil
+	case md.IsStreamingServer():
+		for _, resp := range responses {
+			if err := send(resp); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return send(responses[0])
+	}
+}
+
+// responses returns the fixture of md, or a fake response.
+func (s *Server) responses(md protoreflect.MethodDescriptor) ([]proto.Message, error) {
+	if responses, ok := s.fixtures[md.FullName()]; ok {
+		return responses, nil
+	}
+	output, err := s.reg.FindMessageByName(md.Output().FullName())
+	if err != nil {
+		return nil, err
+	}
+	resp := output.New()
+	Fill(resp)
+	return []proto.Message{resp.Interface()}, nil
+}
@@ -0,0 +1,155 @@
+package mock
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+
+	"github.com/alecthomas/protobuf/call"
+	"github.com/alecthomas/protobuf/compiler"
+)
+
+func TestFill(t *testing.T) {
+	result, err := compiler.Build([]string{"fake.proto"}, []string{"testdata"}, true)
+	require.NoError(t, err)
+	mt, err := result.Registry().FindMessageByName("fake.v1.User")
+	require.NoError(t, err)
+	m := mt.New()
+	Fill(m)
+	b, err := protojson.Marshal(m.Interface())
+	require.NoError(t, err)
+	// The recursive manager field is left empty.
+	require.JSONEq(t, `{
+		"id": "id_1",
+		"email": "user1@example.com",
+		"name": "Ada Lovelace",
+		"age": 36,
+		"active": true,
+		"role": "ROLE_ADMIN",
+		"roles": ["ROLE_ADMIN", "ROLE_MEMBER"],
+		"tags": ["tags 1", "tags 2"],
+		"scores": {"key 1": "73", "key 2": "74"},
+		"createdAt": "2024-01-02T15:04:05Z",
+		"addresses": [
+			{"address": "1 Example Street", "city": "Wellington", "latitude": -41.2865, "longitude": 174.7762},
+			{"address": "1 Example Street", "city": "Wellington", "latitude": -41.2865, "longitude": 174.7762}
+		],
+		"phone": "+1-555-0101",
+		"avatar": "YXZhdGFyIDE=",
+		"balance": 19.5
+	}`, string(b))
+	again := mt.New()
+	Fill(again)
+	require.True(t, proto.Equal(m.Interface(), again.Interface()))
+}
+
+func TestServe(t *testing.T) {
+	tests := []struct {
+		method   string
+		data     string
+		fixtures bool
+		want     []string
+	}{
+		{method: "SayHello", data: `{"name": "Ada"}`, want: []string{"message 1"}},
+		{method: "SayHello", data: `{"name": "Ada"}`, fixtures: true, want: []string{"Hello from a fixture"}},
+		{method: "LotsOfReplies", data: `{"name": "Ada"}`, want: []string{"message 1"}},
+		{method: "LotsOfReplies", data: `{"name": "Ada"}`, fixtures: true, want: []string{"one", "two", "three"}},
+		{method: "LotsOfGreetings", data: `{"name": "Ada"} {"name": "Bob"}`, fixtures: true, want: []string{"message 1"}},
+		{method: "BidiHello", data: `{"name": "Ada"} {"name": "Bob"}`, want: []string{"message 1", "message 1"}},
+		{method: "BidiHello", data: `{"name": "Ada"} {"name": "Bob"} {"name": "Cy"}`, fixtures: true, want: []string{"ping", "pong", "ping"}},
+		{method: "BidiHello", fixtures: true},
+	}
+	reg := newRegistry(t)
+	conns := map[bool]*grpc.ClientConn{false: newConn(t, newServer(t, reg)), true: newConn(t, newServer(t, reg, Fixtures("testdata/fixtures")))}
+	for _, test := range tests {
+		t.Run(test.method+" "+test.data, func(t *testing.T) {
+			md, err := call.FindMethod(reg, "greeter.v1.Greeter/"+test.method)
+			require.NoError(t, err)
+			var got []string
+			err = call.Invoke(context.Background(), conns[test.fixtures], reg, md, call.JSONRequests(strings.NewReader(test.data), reg, md), func(resp proto.Message) error {
+				got = append(got, message(resp))
+				return nil
+			})
+			require.NoError(t, err)
+			require.Equal(t, test.want, got)
+		})
+	}
+}
+
+func TestFixtureErrors(t *testing.T) {
+	tests := []struct {
+		file    string
+		fixture string
+		err     string
+	}{
+		{file: "greeter.v1.Greeter/Unknown.json", fixture: `{}`,
+			err: "unknown method greeter.v1.Greeter.Unknown"},
+		{file: "greeter.v1.HelloRequest/name.json", fixture: `{}`,
+			err: "greeter.v1.HelloRequest.name is not a method"},
+		{file: "greeter.v1.Greeter/SayHello.json", fixture: `{"message": "a"} {"message": "b"}`,
+			err: "greeter.v1.Greeter.SayHello returns a single response message"},
+		{file: "greeter.v1.Greeter/LotsOfReplies.json", fixture: ``,
+			err: "no responses for greeter.v1.Greeter.LotsOfReplies"},
+		{file: "greeter.v1.Greeter/SayHello.json", fixture: `{"message": "a"`,
+			err: "unexpected EOF"},
+	}
+	reg := newRegistry(t)
+	for _, test := range tests {
+		t.Run(test.file, func(t *testing.T) {
+			dir := t.TempDir()
+			file := filepath.Join(dir, test.file)
+			require.NoError(t, os.MkdirAll(filepath.Dir(file), 0o700))
+			require.NoError(t, os.WriteFile(file, []byte(test.fixture), 0o600))
+			_, err := New(reg, Fixtures(dir))
+			require.EqualError(t, err, file+": "+test.err)
+		})
+	}
+}
+
+func newRegistry(t *testing.T) *compiler.Registry {
+	t.Helper()
+	result, err := compiler.Build([]string{"greeter.proto"}, []string{"../reflection/testdata", "../compiler/testdata"}, true)
+	require.NoError(t, err)
+	return result.Registry()
+}
+
+func newServer(t *testing.T, reg *compiler.Registry, options ...Option) *Server {
+	t.Helper()
+	s, err := New(reg, options...)
+	require.NoError(t, err)
+	return s
+}
+
+// newConn returns a client connection to a gRPC server with the services
+// of s.
+func newConn(t *testing.T, s *Server) *grpc.ClientConn {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	gs := grpc.NewServer()
+	s.Register(gs)
+	go func() { _ = gs.Serve(lis) }()
+	t.Cleanup(gs.Stop)
+	conn, err := grpc.DialContext(context.Background(), "bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	return conn
+}
+
+// message returns the message field of a HelloReply.
+func message(m proto.Message) string {
+	msg := m.ProtoReflect()
+	return msg.Get(msg.Descriptor().Fields().ByName(protoreflect.Name("message"))).String()
+}
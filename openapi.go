@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/alecthomas/protobuf/compiler"
+	"github.com/alecthomas/protobuf/openapi"
+)
+
+type OpenAPIConfig struct {
+	ProtoPath  []string `short:"I" help:"Search paths for proto imports."`
+	Output     string   `short:"o" help:"OpenAPI output file (default: stdout)."`
+	Title      string   `help:"Title of the API." default:"API"`
+	APIVersion string   `help:"Version of the API." default:"v1"`
+	Files      []string `arg:"" help:"Proto files containing services to document."`
+}
+
+func (c *OpenAPIConfig) Run() error {
+	fds, err := compiler.Compile(c.Files, c.ProtoPath, true, compiler.IncludeSourceInfo())
+	if err != nil {
+		return err
+	}
+	doc, err := openapi.Generate(fds, c.Files, openapi.Info{Title: c.Title, Version: c.APIVersion})
+	if err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	if c.Output == "" {
+		_, err = os.Stdout.Write(b)
+		return err
+	}
+	return os.WriteFile(c.Output, b, 0o600)
+}
+
+func (c *OpenAPIConfig) AfterApply() error {
+	if len(c.Files) == 0 {
+		return fmt.Errorf(`missing .proto input file(s)`)
+	}
+	return nil
+}
@@ -0,0 +1,446 @@
+package openapi
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/alecthomas/protobuf/compiler"
+	"google.golang.org/genproto/googleapis/api/annotations"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	pb "google.golang.org/protobuf/types/descriptorpb"
+)
+
+const (
+	jsonContentType = "application/json"
+	statusName      = "google.rpc.Status"
+	anyName         = "google.protobuf.Any"
+)
+
+// wellKnownSchemas maps well-known types with a special JSON mapping to
+// their schema.
+var wellKnownSchemas = map[protoreflect.FullName]Schema{
+	"google.protobuf.Timestamp":   {Type: "string", Format: "date-time"},
+	"google.protobuf.Duration":    {Type: "string", Pattern: `^-?[0-9]+(\.[0-9]+)?s$`},
+	"google.protobuf.FieldMask":   {Type: "string", Format: "field-mask"},
+	"google.protobuf.Struct":      {Type: "object", AdditionalProperties: &Schema{}},
+	"google.protobuf.Value":       {},
+	"google.protobuf.ListValue":   {Type: "array", Items: &Schema{}},
+	"google.protobuf.Empty":       {Type: "object"},
+	"google.protobuf.DoubleValue": {Type: "number", Format: "double", Nullable: true},
+	"google.protobuf.FloatValue":  {Type: "number", Format: "float", Nullable: true},
+	"google.protobuf.Int64Value":  {Type: "string", Format: "int64", Nullable: true},
+	"google.protobuf.UInt64Value": {Type: "string", Format: "uint64", Nullable: true},
+	"google.protobuf.Int32Value":  {Type: "integer", Format: "int32", Nullable: true},
+	"google.protobuf.UInt32Value": {Type: "integer", Format: "uint32", Nullable: true},
+	"google.protobuf.BoolValue":   {Type: "boolean", Nullable: true},
+	"google.protobuf.StringValue": {Type: "string", Nullable: true},
+	"google.protobuf.BytesValue":  {Type: "string", Format: "byte", Nullable: true},
+}
+
+// Generate an OpenAPI document for the services annotated with
+// google.api.http in the given files of fds. fds must contain all
+// dependencies of the files. If files is empty, the services of every
+// file in fds are included.
+//
+// Comments in the SourceCodeInfo of fds, such as produced by
+// compiler.IncludeSourceInfo, become descriptions in the document.
+func Generate(fds *pb.FileDescriptorSet, files []string, info Info) (*Document, error) {
+	reg, err := compiler.NewRegistry(fds)
+	if err != nil {
+		return nil, err
+	}
+	include := map[string]bool{}
+	for _, file := range files {
+		include[file] = true
+	}
+	g := &generator{
+		doc: &Document{
+			OpenAPI:    Version,
+			Info:       info,
+			Paths:      map[string]*PathItem{},
+			Components: &Components{Schemas: map[string]*Schema{}},
+		},
+		reg: reg,
+	}
+	for _, fdp := range fds.File {
+		if len(include) > 0 && !include[fdp.GetName()] {
+			continue
+		}
+		fd, err := reg.FindFileByPath(fdp.GetName())
+		if err != nil {
+			return nil, err
+		}
+		services := fd.Services()
+		for i := 0; i < services.Len(); i++ {
+			if err := g.addService(services.Get(i)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if len(g.doc.Components.Schemas) == 0 {
+		g.doc.Components = nil
+	}
+	return g.doc, nil
+}
+
+type generator struct {
+	doc *Document
+	reg *compiler.Registry
+}
+
+func (g *generator) addService(sd protoreflect.ServiceDescriptor) error {
+	bound := false
+	methods := sd.Methods()
+	for i := 0; i < methods.Len(); i++ {
+		md := methods.Get(i)
+		rule, err := httpRule(md)
+		if err != nil {
+			return fmt.Errorf("%s: %w", md.FullName(), err)
+		}
+		if rule == nil {
+			continue
+		}
+		bound = true
+		operationID := fmt.Sprintf("%s_%s", sd.Name(), md.Name())
+		if err := g.addOperation(md, rule, operationID); err != nil {
+			return fmt.Errorf("%s: %w", md.FullName(), err)
+		}
+		for j, binding := range rule.GetAdditionalBindings() {
+			if len(binding.GetAdditionalBindings()) > 0 {
+				return fmt.Errorf("%s: additional_bindings must not be nested", md.FullName())
+			}
+			if err := g.addOperation(md, binding, fmt.Sprintf("%s%d", operationID, j+2)); err != nil {
+				return fmt.Errorf("%s: %w", md.FullName(), err)
+			}
+		}
+	}
+	if bound {
+		g.doc.Tags = append(g.doc.Tags, &Tag{Name: string(sd.Name()), Description: description(sd)})
+	}
+	return nil
+}
+
+// httpRule returns the google.api.http option of a method, or nil if it
+// has none.
+func httpRule(md protoreflect.MethodDescriptor) (*annotations.HttpRule, error) {
+	// The option may be held by a dynamic message, so round trip the
+	// options through the wire format to decode it as an HttpRule.
+	b, err := proto.Marshal(md.Options())
+	if err != nil {
+		return nil, err
+	}
+	opts := &pb.MethodOptions{}
+	if err := proto.Unmarshal(b, opts); err != nil {
+		return nil, err
+	}
+	if !proto.HasExtension(opts, annotations.E_Http) {
+		return nil, nil
+	}
+	rule, _ := proto.GetExtension(opts, annotations.E_Http).(*annotations.HttpRule)
+	return rule, nil
+}
+
+func (g *generator) addOperation(md protoreflect.MethodDescriptor, rule *annotations.HttpRule, operationID string) error {
+	method, pattern := httpMethod(rule)
+	if method == "" {
+		return fmt.Errorf("HTTP rule has no pattern")
+	}
+	tmpl, err := parseTemplate(pattern)
+	if err != nil {
+		return err
+	}
+	item, ok := g.doc.Paths[tmpl.path]
+	if !ok {
+		item = &PathItem{}
+		g.doc.Paths[tmpl.path] = item
+	}
+	slot := item.operation(method)
+	if slot == nil {
+		return fmt.Errorf("HTTP method %q is not supported by OpenAPI", method)
+	}
+	if *slot != nil {
+		return fmt.Errorf("%s %s is already bound to %s", method, tmpl.path, (*slot).OperationID)
+	}
+	op := &Operation{
+		Tags:        []string{string(md.Parent().Name())},
+		Description: description(md),
+		OperationID: operationID,
+		Deprecated:  md.Options().(*pb.MethodOptions).GetDeprecated(),
+	}
+
+	input := md.Input()
+	exclude := map[string]bool{}
+	for _, v := range tmpl.variables {
+		param, err := g.pathParameter(input, v)
+		if err != nil {
+			return err
+		}
+		op.Parameters = append(op.Parameters, param)
+		exclude[v.fieldPath] = true
+	}
+	switch body := rule.GetBody(); body {
+	case "":
+		op.Parameters = append(op.Parameters, g.queryParameters(input, "", "", exclude, map[protoreflect.FullName]bool{})...)
+	case "*":
+		op.RequestBody = &RequestBody{Required: true, Content: jsonContent(g.messageSchema(input))}
+	default:
+		fd := input.Fields().ByName(protoreflect.Name(body))
+		if fd == nil {
+			return fmt.Errorf("body field %q not found in %s", body, input.FullName())
+		}
+		op.RequestBody = &RequestBody{Required: true, Content: jsonContent(g.fieldSchema(fd))}
+		exclude[body] = true
+		op.Parameters = append(op.Parameters, g.queryParameters(input, "", "", exclude, map[protoreflect.FullName]bool{})...)
+	}
+
+	output := md.Output()
+	response := g.messageSchema(output)
+	if name := rule.GetResponseBody(); name != "" {
+		fd := output.Fields().ByName(protoreflect.Name(name))
+		if fd == nil {
+			return fmt.Errorf("response_body field %q not found in %s", name, output.FullName())
+		}
+		response = g.fieldSchema(fd)
+	}
+	op.Responses = map[string]*Response{
+		"200":     {Description: "OK", Content: jsonContent(response)},
+		"default": {Description: "Default error response", Content: jsonContent(g.statusSchema())},
+	}
+	*slot = op
+	return nil
+}
+
+// httpMethod returns the upper case HTTP method and path template of a rule.
+func httpMethod(rule *annotations.HttpRule) (method, pattern string) {
+	switch p := rule.GetPattern().(type) {
+	case *annotations.HttpRule_Get:
+		return "GET", p.Get
+	case *annotations.HttpRule_Put:
+		return "PUT", p.Put
+	case *annotations.HttpRule_Post:
+		return "POST", p.Post
+	case *annotations.HttpRule_Delete:
+		return "DELETE", p.Delete
+	case *annotations.HttpRule_Patch:
+		return "PATCH", p.Patch
+	case *annotations.HttpRule_Custom:
+		return strings.ToUpper(p.Custom.GetKind()), p.Custom.GetPath()
+	}
+	return "", ""
+}
+
+func (g *generator) pathParameter(input protoreflect.MessageDescriptor, v *variable) (*Parameter, error) {
+	fd, err := findField(input, v.fieldPath)
+	if err != nil {
+		return nil, err
+	}
+	if fd.IsList() || fd.IsMap() || fd.Message() != nil {
+		return nil, fmt.Errorf("path variable %q must be a singular scalar field", v.fieldPath)
+	}
+	schema := g.fieldSchema(fd)
+	schema.Description = ""
+	if v.pattern != "" && schema.Ref == "" {
+		schema.Pattern = v.pattern
+	}
+	return &Parameter{
+		Name:        v.fieldPath,
+		In:          "path",
+		Description: description(fd),
+		Required:    true,
+		Schema:      schema,
+	}, nil
+}
+
+// queryParameters returns a parameter for every field of md that can be
+// encoded in a query string, flattening nested messages into dotted
+// names. Fields whose proto field paths are in exclude are skipped.
+func (g *generator) queryParameters(md protoreflect.MessageDescriptor, protoPrefix, jsonPrefix string, exclude map[string]bool, seen map[protoreflect.FullName]bool) []*Parameter {
+	seen[md.FullName()] = true
+	defer delete(seen, md.FullName())
+	var params []*Parameter
+	fields := md.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		protoPath := protoPrefix + string(fd.Name())
+		jsonPath := jsonPrefix + fd.JSONName()
+		if exclude[protoPath] || fd.IsMap() {
+			continue
+		}
+		if msg := fd.Message(); msg != nil {
+			if _, ok := wellKnownSchemas[msg.FullName()]; !ok {
+				if !fd.IsList() && !seen[msg.FullName()] {
+					params = append(params, g.queryParameters(msg, protoPath+".", jsonPath+".", exclude, seen)...)
+				}
+				continue
+			}
+		}
+		schema := g.fieldSchema(fd)
+		schema.Description = ""
+		params = append(params, &Parameter{
+			Name:        jsonPath,
+			In:          "query",
+			Description: description(fd),
+			Schema:      schema,
+		})
+	}
+	return params
+}
+
+// findField returns the field at a dotted field path in md.
+func findField(md protoreflect.MessageDescriptor, path string) (protoreflect.FieldDescriptor, error) {
+	parts := strings.Split(path, ".")
+	for i, part := range parts {
+		fd := md.Fields().ByName(protoreflect.Name(part))
+		if fd == nil {
+			return nil, fmt.Errorf("field %q not found in %s", part, md.FullName())
+		}
+		if i == len(parts)-1 {
+			return fd, nil
+		}
+		if fd.Message() == nil || fd.IsList() || fd.IsMap() {
+			return nil, fmt.Errorf("field %q of %s is not a singular message", part, md.FullName())
+		}
+		md = fd.Message()
+	}
+	return nil, fmt.Errorf("empty field path")
+}
+
+// fieldSchema returns the schema of a field's JSON value.
+func (g *generator) fieldSchema(fd protoreflect.FieldDescriptor) *Schema {
+	var s *Schema
+	switch {
+	case fd.IsMap():
+		s = &Schema{Type: "object", AdditionalProperties: g.singularSchema(fd.MapValue())}
+	case fd.IsList():
+		s = &Schema{Type: "array", Items: g.singularSchema(fd)}
+	default:
+		s = g.singularSchema(fd)
+	}
+	if s.Ref == "" {
+		s.Description = description(fd)
+		s.Deprecated = fd.Options().(*pb.FieldOptions).GetDeprecated()
+	}
+	return s
+}
+
+func (g *generator) singularSchema(fd protoreflect.FieldDescriptor) *Schema {
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		return &Schema{Type: "boolean"}
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		return &Schema{Type: "integer", Format: "int32"}
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		return &Schema{Type: "integer", Format: "uint32"}
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		// 64 bit integers are strings in the protobuf JSON mapping.
+		return &Schema{Type: "string", Format: "int64"}
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return &Schema{Type: "string", Format: "uint64"}
+	case protoreflect.FloatKind:
+		return &Schema{Type: "number", Format: "float"}
+	case protoreflect.DoubleKind:
+		return &Schema{Type: "number", Format: "double"}
+	case protoreflect.StringKind:
+		return &Schema{Type: "string"}
+	case protoreflect.BytesKind:
+		return &Schema{Type: "string", Format: "byte"}
+	case protoreflect.EnumKind:
+		return g.enumSchema(fd.Enum())
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		return g.messageSchema(fd.Message())
+	}
+	panic(fmt.Sprintf("unknown field kind %s", fd.Kind()))
+}
+
+// messageSchema returns the schema of a well-known type or a reference
+// to the component schema of a message, adding it if necessary.
+func (g *generator) messageSchema(md protoreflect.MessageDescriptor) *Schema {
+	if s, ok := wellKnownSchemas[md.FullName()]; ok {
+		return &s
+	}
+	if md.FullName() == anyName {
+		return g.anySchema()
+	}
+	name := string(md.FullName())
+	if _, ok := g.doc.Components.Schemas[name]; !ok {
+		s := &Schema{Type: "object", Description: description(md), Properties: map[string]*Schema{}}
+		// Add before the fields to terminate recursive messages.
+		g.doc.Components.Schemas[name] = s
+		fields := md.Fields()
+		for i := 0; i < fields.Len(); i++ {
+			fd := fields.Get(i)
+			s.Properties[fd.JSONName()] = g.fieldSchema(fd)
+		}
+		s.Deprecated = md.Options().(*pb.MessageOptions).GetDeprecated()
+	}
+	return ref(name)
+}
+
+func (g *generator) enumSchema(ed protoreflect.EnumDescriptor) *Schema {
+	name := string(ed.FullName())
+	if _, ok := g.doc.Components.Schemas[name]; !ok {
+		s := &Schema{Type: "string", Description: description(ed)}
+		values := ed.Values()
+		for i := 0; i < values.Len(); i++ {
+			s.Enum = append(s.Enum, string(values.Get(i).Name()))
+		}
+		g.doc.Components.Schemas[name] = s
+	}
+	return ref(name)
+}
+
+func (g *generator) anySchema() *Schema {
+	if _, ok := g.doc.Components.Schemas[anyName]; !ok {
+		g.doc.Components.Schemas[anyName] = &Schema{
+			Type:        "object",
+			Description: "Any contains an arbitrary message along with a @type that describes the type of the message.",
+			Properties: map[string]*Schema{
+				"@type": {Type: "string", Description: "The type of the message, as a URL."},
+			},
+			AdditionalProperties: &Schema{},
+		}
+	}
+	return ref(anyName)
+}
+
+// statusSchema returns the schema of errors, google.rpc.Status. It is
+// added from its descriptor if available, otherwise synthesized.
+func (g *generator) statusSchema() *Schema {
+	if md, err := g.reg.FindDescriptorByName(statusName); err == nil {
+		if md, ok := md.(protoreflect.MessageDescriptor); ok {
+			return g.messageSchema(md)
+		}
+	}
+	if _, ok := g.doc.Components.Schemas[statusName]; !ok {
+		g.doc.Components.Schemas[statusName] = &Schema{
+			Type:        "object",
+			Description: "The error model of gRPC and HTTP APIs.",
+			Properties: map[string]*Schema{
+				"code":    {Type: "integer", Format: "int32", Description: "The status code, an enum value of google.rpc.Code."},
+				"message": {Type: "string", Description: "A developer-facing error message."},
+				"details": {Type: "array", Items: g.anySchema(), Description: "Messages that carry the error details."},
+			},
+		}
+	}
+	return ref(statusName)
+}
+
+func ref(name string) *Schema {
+	return &Schema{Ref: "#/components/schemas/" + name}
+}
+
+func jsonContent(s *Schema) map[string]*MediaType {
+	return map[string]*MediaType{jsonContentType: {Schema: s}}
+}
+
+// description returns the leading comments of a descriptor with comment
+// indentation removed.
+func description(d protoreflect.Descriptor) string {
+	comments := d.ParentFile().SourceLocations().ByDescriptor(d).LeadingComments
+	lines := strings.Split(comments, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(strings.TrimPrefix(line, " "), " \t")
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
@@ -0,0 +1,24 @@
+package openapi
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/alecthomas/protobuf/compiler"
+)
+
+func TestGenerate(t *testing.T) {
+	importPaths := []string{"testdata", "../compiler/testdata", "../testdata/conformance"}
+	fds, err := compiler.Compile([]string{"library.proto"}, importPaths, true, compiler.IncludeSourceInfo())
+	require.NoError(t, err)
+	doc, err := Generate(fds, []string{"library.proto"}, Info{Title: "Library", Version: "v1"})
+	require.NoError(t, err)
+	got, err := json.MarshalIndent(doc, "", "  ")
+	require.NoError(t, err)
+	want, err := os.ReadFile("testdata/library.json")
+	require.NoError(t, err)
+	require.JSONEq(t, string(want), string(got))
+}
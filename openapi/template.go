@@ -0,0 +1,127 @@
+package openapi
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// template is a parsed google.api.http path template:
+//
+//	Template = "/" Segments [ Verb ] ;
+//	Segments = Segment { "/" Segment } ;
+//	Segment  = "*" | "**" | LITERAL | Variable ;
+//	Variable = "{" FieldPath [ "=" Segments ] "}" ;
+//	FieldPath = IDENT { "." IDENT } ;
+//	Verb     = ":" LITERAL ;
+type template struct {
+	// path is the OpenAPI path, with each variable replaced by
+	// "{field.path}".
+	path      string
+	variables []*variable
+}
+
+type variable struct {
+	fieldPath string
+	// pattern is a regular expression matching the segments captured by
+	// the variable, or empty if it captures a single segment.
+	pattern string
+}
+
+var fieldPathRe = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*(\.[a-zA-Z_][a-zA-Z0-9_]*)*$`)
+
+func parseTemplate(tmpl string) (*template, error) {
+	if !strings.HasPrefix(tmpl, "/") {
+		return nil, fmt.Errorf("path template %q must start with \"/\"", tmpl)
+	}
+	rest := tmpl[1:]
+	verb := ""
+	if i := strings.LastIndexByte(rest, ':'); i >= 0 && i > strings.LastIndexAny(rest, "/}") {
+		rest, verb = rest[:i], rest[i+1:]
+	}
+	segments, err := splitSegments(rest)
+	if err != nil {
+		return nil, fmt.Errorf("path template %q: %w", tmpl, err)
+	}
+	t := &template{}
+	paths := make([]string, len(segments))
+	for i, segment := range segments {
+		switch {
+		case strings.HasPrefix(segment, "{"):
+			v, err := parseVariable(segment[1 : len(segment)-1])
+			if err != nil {
+				return nil, fmt.Errorf("path template %q: %w", tmpl, err)
+			}
+			t.variables = append(t.variables, v)
+			paths[i] = "{" + v.fieldPath + "}"
+		case segment == "*" || segment == "**":
+			return nil, fmt.Errorf("path template %q: wildcard %q must be bound to a variable", tmpl, segment)
+		case segment == "":
+			return nil, fmt.Errorf("path template %q: empty segment", tmpl)
+		default:
+			paths[i] = segment
+		}
+	}
+	t.path = "/" + strings.Join(paths, "/")
+	if verb != "" {
+		t.path += ":" + verb
+	}
+	return t, nil
+}
+
+// splitSegments splits a template at "/", except inside variables.
+func splitSegments(s string) ([]string, error) {
+	var segments []string
+	start, depth := 0, 0
+	for i, r := range s {
+		switch r {
+		case '{':
+			if depth > 0 || i != start {
+				return nil, fmt.Errorf("unexpected \"{\" at offset %d", i)
+			}
+			depth++
+		case '}':
+			if depth == 0 {
+				return nil, fmt.Errorf("unexpected \"}\" at offset %d", i)
+			}
+			depth--
+		case '/':
+			if depth == 0 {
+				segments = append(segments, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	if depth > 0 {
+		return nil, fmt.Errorf("unterminated variable")
+	}
+	return append(segments, s[start:]), nil
+}
+
+func parseVariable(s string) (*variable, error) {
+	fieldPath, segments, hasSegments := strings.Cut(s, "=")
+	if !fieldPathRe.MatchString(fieldPath) {
+		return nil, fmt.Errorf("invalid field path %q", fieldPath)
+	}
+	v := &variable{fieldPath: fieldPath}
+	if !hasSegments {
+		return v, nil
+	}
+	parts := strings.Split(segments, "/")
+	for i, part := range parts {
+		switch part {
+		case "*":
+			parts[i] = "[^/]+"
+		case "**":
+			parts[i] = ".+"
+		case "":
+			return nil, fmt.Errorf("variable %q has an empty segment", fieldPath)
+		default:
+			parts[i] = regexp.QuoteMeta(part)
+		}
+	}
+	if len(parts) > 1 || parts[0] != "[^/]+" {
+		v.pattern = "^" + strings.Join(parts, "/") + "$"
+	}
+	return v, nil
+}
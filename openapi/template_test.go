@@ -0,0 +1,48 @@
+package openapi
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTemplate(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		want     *template
+		err      string
+	}{
+		{name: "Literal", template: "/v1/books",
+			want: &template{path: "/v1/books"}},
+		{name: "Variable", template: "/v1/books/{name}",
+			want: &template{path: "/v1/books/{name}", variables: []*variable{{fieldPath: "name"}}}},
+		{name: "VariableSegments", template: "/v1/{name=shelves/*/books/*}",
+			want: &template{path: "/v1/{name}", variables: []*variable{{fieldPath: "name", pattern: "^shelves/[^/]+/books/[^/]+$"}}}},
+		{name: "DoubleWildcard", template: "/v1/{path=files/**}",
+			want: &template{path: "/v1/{path}", variables: []*variable{{fieldPath: "path", pattern: "^files/.+$"}}}},
+		{name: "NestedFieldPath", template: "/v1/{book.name=shelves/*/books/*}",
+			want: &template{path: "/v1/{book.name}", variables: []*variable{{fieldPath: "book.name", pattern: "^shelves/[^/]+/books/[^/]+$"}}}},
+		{name: "MultipleVariables", template: "/v1/shelves/{shelf}/books/{book}",
+			want: &template{path: "/v1/shelves/{shelf}/books/{book}", variables: []*variable{{fieldPath: "shelf"}, {fieldPath: "book"}}}},
+		{name: "Verb", template: "/v1/{name=books/*}:move",
+			want: &template{path: "/v1/{name}:move", variables: []*variable{{fieldPath: "name", pattern: "^books/[^/]+$"}}}},
+		{name: "NoLeadingSlash", template: "v1/books", err: `must start with "/"`},
+		{name: "UnboundWildcard", template: "/v1/*", err: `wildcard "*" must be bound to a variable`},
+		{name: "EmptySegment", template: "/v1//books", err: "empty segment"},
+		{name: "Unterminated", template: "/v1/{name", err: "unterminated variable"},
+		{name: "InvalidFieldPath", template: "/v1/{1name}", err: `invalid field path "1name"`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := parseTemplate(test.template)
+			if test.err != "" {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), test.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, test.want, got)
+		})
+	}
+}
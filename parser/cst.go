@@ -0,0 +1,339 @@
+package parser
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/alecthomas/participle/v2/lexer"
+)
+
+// CST is a lossless concrete syntax tree of a protobuf source file: its
+// AST along with every token of the source, including the whitespace
+// and comments elided by Parse, and the span of tokens of each node.
+//
+// String reprints the source byte for byte. Edits replace, remove or
+// insert the tokens of nodes and are applied by String, leaving the rest
+// of the source untouched.
+type CST struct {
+	Proto *Proto
+	// Tokens of the source, with whitespace split after each newline so
+	// that every line ends in its own token.
+	Tokens []lexer.Token
+
+	spans map[Node]Span
+	edits []edit
+}
+
+// Span of the tokens of a node in CST.Tokens.
+//
+// The leading trivia of a node, Tokens[Start:Begin], is its indentation
+// and the comment lines directly preceding it, up to a blank line. The
+// trailing trivia, Tokens[End:Stop], is whitespace and comments following
+// the node up to and including the end of its last line.
+type Span struct {
+	Start int
+	Begin int
+	End   int
+	Stop  int
+}
+
+type edit struct {
+	start, end int
+	text       string
+}
+
+// TextEdit replaces the bytes [Start:End] of the original source with
+// Text.
+type TextEdit struct {
+	Start int
+	End   int
+	Text  string
+}
+
+// ParseCST parses protobuf source into a CST.
+func ParseCST(filename string, r io.Reader) (*CST, error) {
+	source, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	proto, err := ParseString(filename, string(source))
+	if err != nil {
+		return nil, err
+	}
+	tokens, err := Lex(filename, strings.NewReader(string(source)))
+	if err != nil {
+		return nil, err
+	}
+	c := &CST{Proto: proto, Tokens: splitLines(tokens), spans: map[Node]Span{}}
+	offsets := make(map[int]int, len(c.Tokens))
+	for i, t := range c.Tokens {
+		offsets[t.Pos.Offset] = i
+	}
+	err = Visit(proto, func(node Node, next func() error) error {
+		c.addSpan(node, offsets)
+		return next()
+	})
+	return c, err
+}
+
+// splitLines splits whitespace tokens after each newline.
+func splitLines(tokens []lexer.Token) []lexer.Token {
+	out := make([]lexer.Token, 0, len(tokens))
+	for _, t := range tokens {
+		for t.Type == whitespaceType {
+			i := strings.IndexByte(t.Value, '\n')
+			if i < 0 || i == len(t.Value)-1 {
+				break
+			}
+			line := t
+			line.Value = t.Value[:i+1]
+			out = append(out, line)
+			t.Value = t.Value[i+1:]
+			t.Pos.Offset += i + 1
+			t.Pos.Line++
+			t.Pos.Column = 1
+		}
+		out = append(out, t)
+	}
+	return out
+}
+
+// statement nodes are terminated by a ";" that is not part of the node
+// in the grammar but is included in its span.
+func isStatement(node Node) bool {
+	switch node.(type) {
+	case *Entry, *MessageEntry, *EnumEntry, *ServiceEntry, *MethodEntry, *OneOfEntry, *Field, *EnumValue:
+		return true
+	}
+	return false
+}
+
+func (c *CST) addSpan(node Node, offsets map[int]int) {
+	v := reflect.Indirect(reflect.ValueOf(node))
+	if v.Kind() != reflect.Struct {
+		return
+	}
+	pos, endPos := v.FieldByName("Pos"), v.FieldByName("EndPos")
+	if !pos.IsValid() || !endPos.IsValid() {
+		return
+	}
+	begin, ok := offsets[pos.Interface().(lexer.Position).Offset]
+	if !ok {
+		return
+	}
+	end, ok := offsets[endPos.Interface().(lexer.Position).Offset]
+	if !ok {
+		end = len(c.Tokens)
+	}
+	// EndPos is the position of the next token, so step back over the
+	// trivia preceding it.
+	for end > begin && IsTrivia(c.Tokens[end-1]) {
+		end--
+	}
+	if end <= begin {
+		return
+	}
+	if isStatement(node) {
+		if next := c.skipTrivia(end); next < len(c.Tokens) && c.Tokens[next].Value == ";" {
+			end = next + 1
+		}
+	}
+	c.spans[node] = Span{Start: c.leadingStart(begin), Begin: begin, End: end, Stop: c.trailingStop(end)}
+}
+
+func (c *CST) skipTrivia(i int) int {
+	for i < len(c.Tokens) && IsTrivia(c.Tokens[i]) {
+		i++
+	}
+	return i
+}
+
+func endsLine(t lexer.Token) bool {
+	return strings.HasSuffix(t.Value, "\n")
+}
+
+// leadingStart returns the index of the first token of the leading
+// trivia of a node beginning at token begin.
+func (c *CST) leadingStart(begin int) int {
+	start := begin
+	for start > 0 && IsTrivia(c.Tokens[start-1]) && !endsLine(c.Tokens[start-1]) {
+		start--
+	}
+	if start > 0 && !endsLine(c.Tokens[start-1]) {
+		// Another token precedes the node on its line.
+		return start
+	}
+	for start > 0 {
+		// Tokens[lineStart:start] is the previous line.
+		lineStart := start - 1
+		for lineStart > 0 && !endsLine(c.Tokens[lineStart-1]) {
+			lineStart--
+		}
+		hasComment := false
+		for _, t := range c.Tokens[lineStart:start] {
+			if !IsTrivia(t) {
+				return start
+			}
+			hasComment = hasComment || IsComment(t)
+		}
+		if !hasComment {
+			return start
+		}
+		start = lineStart
+	}
+	return start
+}
+
+// trailingStop returns the index after the trailing trivia of a node
+// ending before token end.
+func (c *CST) trailingStop(end int) int {
+	for i := end; i < len(c.Tokens) && IsTrivia(c.Tokens[i]); i++ {
+		if endsLine(c.Tokens[i]) {
+			return i + 1
+		}
+	}
+	if next := c.skipTrivia(end); next == len(c.Tokens) {
+		return next
+	}
+	return end
+}
+
+// Span returns the span of the tokens of node, which must be a node of
+// c.Proto. Nodes that do not have tokens of their own, such as comments,
+// have no span.
+func (c *CST) Span(node Node) (Span, bool) {
+	s, ok := c.spans[node]
+	return s, ok
+}
+
+// Text returns the source of Tokens[start:end], ignoring edits.
+func (c *CST) Text(start, end int) string {
+	var b strings.Builder
+	for _, t := range c.Tokens[start:end] {
+		b.WriteString(t.Value)
+	}
+	return b.String()
+}
+
+// Replace Tokens[start:end] with text. If start == end, text is
+// inserted before Tokens[start], after any earlier insertions there.
+// It is an error for an edit to overlap a previous one.
+func (c *CST) Replace(start, end int, text string) error {
+	if start < 0 || end < start || end > len(c.Tokens) {
+		return fmt.Errorf("invalid token range [%d:%d]", start, end)
+	}
+	for _, e := range c.edits {
+		if overlaps(start, end, e.start, e.end) {
+			return fmt.Errorf("edit of tokens [%d:%d] overlaps edit of tokens [%d:%d]", start, end, e.start, e.end)
+		}
+	}
+	c.edits = append(c.edits, edit{start: start, end: end, text: text})
+	return nil
+}
+
+// overlaps returns true if the token ranges [s1:e1] and [s2:e2] share a
+// token, or if one is an insertion strictly within the other.
+func overlaps(s1, e1, s2, e2 int) bool {
+	if s1 == e1 || s2 == e2 {
+		return (s2 < s1 && s1 < e2) || (s1 < s2 && s2 < e1)
+	}
+	return s1 < e2 && s2 < e1
+}
+
+// ReplaceNode replaces the tokens of node, excluding its leading and
+// trailing trivia, with text.
+func (c *CST) ReplaceNode(node Node, text string) error {
+	s, err := c.span(node)
+	if err != nil {
+		return err
+	}
+	return c.Replace(s.Begin, s.End, text)
+}
+
+// RemoveNode removes node along with its leading and trailing trivia.
+func (c *CST) RemoveNode(node Node) error {
+	s, err := c.span(node)
+	if err != nil {
+		return err
+	}
+	return c.Replace(s.Start, s.Stop, "")
+}
+
+// InsertBefore inserts text before node and its leading trivia.
+func (c *CST) InsertBefore(node Node, text string) error {
+	s, err := c.span(node)
+	if err != nil {
+		return err
+	}
+	return c.Replace(s.Start, s.Start, text)
+}
+
+// InsertAfter inserts text after node and its trailing trivia.
+func (c *CST) InsertAfter(node Node, text string) error {
+	s, err := c.span(node)
+	if err != nil {
+		return err
+	}
+	return c.Replace(s.Stop, s.Stop, text)
+}
+
+func (c *CST) span(node Node) (Span, error) {
+	s, ok := c.spans[node]
+	if !ok {
+		return Span{}, fmt.Errorf("%T has no span", node)
+	}
+	return s, nil
+}
+
+// String returns the source with all edits applied.
+func (c *CST) String() string {
+	var b strings.Builder
+	i := 0
+	for _, e := range c.sortedEdits() {
+		b.WriteString(c.Text(i, e.start))
+		b.WriteString(e.text)
+		i = e.end
+	}
+	b.WriteString(c.Text(i, len(c.Tokens)))
+	return b.String()
+}
+
+// TextEdits returns the edits made to c as byte offsets into the
+// original source, in the order String applies them.
+func (c *CST) TextEdits() []TextEdit {
+	edits := c.sortedEdits()
+	out := make([]TextEdit, len(edits))
+	for i, e := range edits {
+		out[i] = TextEdit{Start: c.offset(e.start), End: c.offset(e.end), Text: e.text}
+	}
+	return out
+}
+
+// offset returns the byte offset of Tokens[i] in the source.
+func (c *CST) offset(i int) int {
+	if i < len(c.Tokens) {
+		return c.Tokens[i].Pos.Offset
+	}
+	if len(c.Tokens) == 0 {
+		return 0
+	}
+	last := c.Tokens[len(c.Tokens)-1]
+	return last.Pos.Offset + len(last.Value)
+}
+
+func (c *CST) sortedEdits() []edit {
+	edits := make([]edit, len(c.edits))
+	copy(edits, c.edits)
+	// Insertions before replacements at the same token, otherwise in
+	// the order they were made.
+	sort.SliceStable(edits, func(i, j int) bool {
+		if edits[i].start != edits[j].start {
+			return edits[i].start < edits[j].start
+		}
+		return edits[i].start == edits[i].end && edits[j].start != edits[j].end
+	})
+	return edits
+}
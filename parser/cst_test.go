@@ -0,0 +1,130 @@
+package parser
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCSTRoundTrip(t *testing.T) {
+	files, err := filepath.Glob("../testdata/conformance/*.proto")
+	require.NoError(t, err)
+	for _, file := range files {
+		t.Run(file, func(t *testing.T) {
+			source, err := os.ReadFile(file)
+			require.NoError(t, err)
+			cst, err := ParseCST(file, strings.NewReader(string(source)))
+			require.NoError(t, err)
+			require.Equal(t, string(source), cst.String())
+		})
+	}
+}
+
+const cstSource = `syntax = "proto3";
+
+package test;
+
+// Detached.
+
+// A message.
+// More.
+message Msg {
+  // The name.
+  string name = 1; // Trailing.
+  int32 count = 2 [deprecated = true];
+
+  enum Kind {
+    KIND_UNSPECIFIED = 0;
+  }
+}
+`
+
+func parseCSTSource(t *testing.T) (*CST, *Message) {
+	t.Helper()
+	cst, err := ParseCST("test.proto", strings.NewReader(cstSource))
+	require.NoError(t, err)
+	for _, e := range cst.Proto.Entries {
+		if e.Message != nil {
+			return cst, e.Message
+		}
+	}
+	t.Fatal("no message")
+	return nil, nil
+}
+
+func TestCSTSpans(t *testing.T) {
+	cst, msg := parseCSTSource(t)
+	text := func(start, end int) string { return cst.Text(start, end) }
+
+	s, ok := cst.Span(msg)
+	require.True(t, ok)
+	require.Equal(t, "// A message.\n// More.\n", text(s.Start, s.Begin))
+	require.True(t, strings.HasPrefix(text(s.Begin, s.End), "message Msg {"))
+	require.True(t, strings.HasSuffix(text(s.Begin, s.End), "  }\n}"))
+	require.Equal(t, "\n", text(s.End, s.Stop))
+
+	name := msg.Entries[1].Field
+	s, ok = cst.Span(name)
+	require.True(t, ok)
+	require.Equal(t, "  // The name.\n  ", text(s.Start, s.Begin))
+	require.Equal(t, "string name = 1;", text(s.Begin, s.End))
+	require.Equal(t, " // Trailing.\n", text(s.End, s.Stop))
+
+	count := msg.Entries[2].Field
+	s, ok = cst.Span(count)
+	require.True(t, ok)
+	require.Equal(t, "  ", text(s.Start, s.Begin))
+	require.Equal(t, "int32 count = 2 [deprecated = true];", text(s.Begin, s.End))
+	s, ok = cst.Span(count.Direct.Options[0])
+	require.True(t, ok)
+	require.Equal(t, "deprecated = true", text(s.Begin, s.End))
+
+	value := msg.Entries[3].Enum.Values[0].Value
+	s, ok = cst.Span(value)
+	require.True(t, ok)
+	require.Equal(t, "KIND_UNSPECIFIED = 0;", text(s.Begin, s.End))
+}
+
+func TestCSTEdits(t *testing.T) {
+	cst, msg := parseCSTSource(t)
+	name, count := msg.Entries[1].Field, msg.Entries[2].Field
+	require.NoError(t, cst.ReplaceNode(name.Direct.Type, "bytes"))
+	require.NoError(t, cst.RemoveNode(count))
+	require.NoError(t, cst.InsertBefore(name, "  int64 id = 3;\n"))
+	require.NoError(t, cst.InsertAfter(name, "  string other = 4;\n"))
+	require.Error(t, cst.ReplaceNode(name, "string name = 1;"))
+	require.NoError(t, cst.Replace(0, 0, "// Edited.\n"))
+	require.Equal(t, `// Edited.
+syntax = "proto3";
+
+package test;
+
+// Detached.
+
+// A message.
+// More.
+message Msg {
+  int64 id = 3;
+  // The name.
+  bytes name = 1; // Trailing.
+  string other = 4;
+
+  enum Kind {
+    KIND_UNSPECIFIED = 0;
+  }
+}
+`, cst.String())
+
+	edits := cst.TextEdits()
+	require.Len(t, edits, 5)
+	require.Equal(t, TextEdit{Start: 0, End: 0, Text: "// Edited.\n"}, edits[0])
+	require.Equal(t, "string", cstSource[edits[2].Start:edits[2].End])
+	require.Equal(t, "bytes", edits[2].Text)
+
+	_, ok := cst.Span(&Field{})
+	require.False(t, ok)
+	require.Error(t, cst.RemoveNode(&Field{}))
+}
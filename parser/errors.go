@@ -0,0 +1,558 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/alecthomas/participle/v2"
+	"github.com/alecthomas/participle/v2/lexer"
+)
+
+// maxFieldNumber is the largest valid field number.
+const maxFieldNumber = 1<<29 - 1
+
+// Error is a syntax error in protobuf source. Common mistakes have a
+// hint on how to fix them.
+type Error struct {
+	Pos  lexer.Position
+	Msg  string
+	Hint string
+	// Excerpt is the line of source containing Pos, followed by a caret
+	// pointing at it.
+	Excerpt string
+}
+
+var _ participle.Error = (*Error)(nil)
+
+// Message returns the error without its position, excerpt or hint.
+func (e *Error) Message() string { return e.Msg }
+
+// Position of the error.
+func (e *Error) Position() lexer.Position { return e.Pos }
+
+func (e *Error) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s: %s", e.Pos, e.Msg)
+	if e.Excerpt != "" {
+		b.WriteString("\n" + e.Excerpt)
+	}
+	if e.Hint != "" {
+		b.WriteString("\nhint: " + e.Hint)
+	}
+	return b.String()
+}
+
+// diagnostics creates errors for a source file, using its tokens to
+// describe the context of mistakes.
+type diagnostics struct {
+	filename string
+	source   string
+	// tokens are the tokens of source up to any lexing error, excluding
+	// trivia. They are only lexed once a diagnostic needs them, as the
+	// checks of a valid file do not.
+	tokens []lexer.Token
+	lexed  bool
+}
+
+func newDiagnostics(filename, source string) *diagnostics {
+	return &diagnostics{filename: filename, source: source}
+}
+
+// lex lexes the tokens of the source if it has not been yet.
+func (d *diagnostics) lex() {
+	if d.lexed {
+		return
+	}
+	d.lexed = true
+	l, err := lex.Lex(d.filename, strings.NewReader(d.source))
+	if err != nil {
+		return
+	}
+	for {
+		t, err := l.Next()
+		if err != nil || t.EOF() {
+			return
+		}
+		if !IsTrivia(t) {
+			d.tokens = append(d.tokens, t)
+		}
+	}
+}
+
+func (d *diagnostics) errorf(pos lexer.Position, hint, format string, args ...any) *Error {
+	return &Error{Pos: pos, Msg: fmt.Sprintf(format, args...), Hint: hint, Excerpt: d.excerpt(pos)}
+}
+
+// excerpt returns the line of source containing pos, prefixed by its line
+// number, and a caret under pos.
+func (d *diagnostics) excerpt(pos lexer.Position) string {
+	if pos.Offset > len(d.source) || pos.Line == 0 {
+		return ""
+	}
+	start := strings.LastIndexByte(d.source[:pos.Offset], '\n') + 1
+	end := strings.IndexByte(d.source[pos.Offset:], '\n')
+	if end < 0 {
+		end = len(d.source)
+	} else {
+		end += pos.Offset
+	}
+	line := strings.TrimRight(d.source[start:end], "\r")
+	// Keep tabs so that the caret lines up with the source.
+	indent := strings.Map(func(r rune) rune {
+		if r == '\t' {
+			return r
+		}
+		return ' '
+	}, d.source[start:pos.Offset])
+	number := fmt.Sprint(pos.Line)
+	gutter := strings.Repeat(" ", len(number))
+	return fmt.Sprintf(" %s | %s\n %s | %s^", number, line, gutter, indent)
+}
+
+// index returns the index of the first token at or after offset.
+func (d *diagnostics) index(offset int) int {
+	d.lex()
+	return sort.Search(len(d.tokens), func(i int) bool { return d.tokens[i].Pos.Offset >= offset })
+}
+
+func (d *diagnostics) value(i int) string {
+	d.lex()
+	if i < 0 || i >= len(d.tokens) {
+		return ""
+	}
+	return d.tokens[i].Value
+}
+
+// after returns the position directly after token i.
+func (d *diagnostics) after(i int) lexer.Position {
+	t := d.tokens[i]
+	pos := t.Pos
+	pos.Offset += len(t.Value)
+	pos.Column += utf8.RuneCountInString(t.Value)
+	return pos
+}
+
+// diagnose converts an error from the parser into an *Error describing
+// the mistake, if it is a common one.
+func (d *diagnostics) diagnose(err error) error {
+	var perr participle.Error
+	if !errors.As(err, &perr) {
+		return err
+	}
+	pos := perr.Position()
+	var lerr *lexer.Error
+	if errors.As(err, &lerr) {
+		if pos.Offset < len(d.source) && (d.source[pos.Offset] == '"' || d.source[pos.Offset] == '\'') {
+			quote := d.source[pos.Offset : pos.Offset+1]
+			return d.errorf(pos, fmt.Sprintf("add a closing %s, strings cannot span multiple lines", quote), "unterminated string")
+		}
+		return d.errorf(pos, "", "%s", perr.Message())
+	}
+	i := d.index(pos.Offset)
+	start := d.statementStart(i)
+	if derr := d.diagnoseStatement(start); derr != nil {
+		return derr
+	}
+	var uerr *participle.UnexpectedTokenError
+	if errors.As(err, &uerr) && strings.HasSuffix(uerr.Message(), `(expected ";")`) && i > 0 {
+		return d.errorf(d.after(i-1), `add ";" at the end of the statement`, `missing ";"`)
+	}
+	return d.errorf(pos, "", "%s", perr.Message())
+}
+
+// statementStart returns the index of the first token of the statement
+// containing token i.
+func (d *diagnostics) statementStart(i int) int {
+	for i > 0 {
+		switch d.value(i - 1) {
+		case ";", "{", "}":
+			return i
+		}
+		i--
+	}
+	return i
+}
+
+// diagnoseStatement returns an error for the statement starting at token
+// start that the parser failed on, if it is a common mistake.
+func (d *diagnostics) diagnoseStatement(start int) *Error {
+	switch block := d.block(start); {
+	case d.value(start) == "enum" && d.value(start+2) == "=":
+		return d.errorf(d.tokens[start+2].Pos, fmt.Sprintf(`remove the "=", enums are declared as "enum %s { ... }"`, d.value(start+1)),
+			`unexpected "=" after enum name`)
+	case block == "enum":
+		return d.diagnoseEnumValue(start)
+	case block == "message" || block == "group" || block == "oneof" || block == "extend":
+		return d.diagnoseField(start)
+	}
+	return nil
+}
+
+// block returns the keyword of the declaration whose body contains token
+// i, or "" at the top level.
+func (d *diagnostics) block(i int) string {
+	depth := 0
+	for i--; i >= 0; i-- {
+		switch d.value(i) {
+		case "}":
+			depth++
+		case "{":
+			if depth > 0 {
+				depth--
+				continue
+			}
+			for j := d.statementStart(i); j < i; j++ {
+				switch v := d.value(j); v {
+				case "message", "enum", "oneof", "extend", "service", "rpc", "group", "option":
+					return v
+				}
+			}
+			return "{"
+		}
+	}
+	return ""
+}
+
+// diagnoseEnumValue checks that the statement starting at token start in
+// an enum is of the form NAME = NUMBER.
+func (d *diagnostics) diagnoseEnumValue(start int) *Error {
+	const hint = `enum values are declared as "NAME = NUMBER;"`
+	switch {
+	case d.value(start) == "=":
+		return d.errorf(d.tokens[start].Pos, hint, `missing enum value name before "="`)
+	case d.value(start+1) != "=":
+		return nil
+	}
+	i := start + 2
+	if d.value(i) == "-" {
+		i++
+	}
+	if d.value(i+1) == "=" {
+		return d.errorf(d.tokens[i+1].Pos, hint, `stray "=" in enum value %s`, d.value(start))
+	}
+	return nil
+}
+
+// diagnoseField checks that the field starting at token start has a
+// field number.
+func (d *diagnostics) diagnoseField(start int) *Error {
+	i := start
+	switch d.value(i) {
+	case "optional", "required", "repeated":
+		i++
+	}
+	switch d.value(i) {
+	case "message", "enum", "oneof", "extend", "option", "reserved", "extensions", "group", "":
+		return nil
+	case "map":
+		for i < len(d.tokens) && d.value(i) != ">" {
+			i++
+		}
+		i++
+	default:
+		if d.value(i) == "." {
+			i++
+		}
+		for i++; d.value(i) == "."; i += 2 {
+		}
+	}
+	if i >= len(d.tokens) || !isIdent(d.value(i)) || d.value(i+1) == "=" {
+		return nil
+	}
+	typ := d.source[d.tokens[start].Pos.Offset:d.after(i-1).Offset]
+	return d.errorf(d.after(i), fmt.Sprintf(`add a field number, for example "%s %s = 1;"`, typ, d.value(i)),
+		"missing field number for field %s", d.value(i))
+}
+
+func isIdent(s string) bool {
+	if s == "" {
+		return false
+	}
+	c := s[0]
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// check reports the mistakes in a parsed file that the grammar accepts,
+// other than those reported by unterminated.
+func (d *diagnostics) check(proto *Proto) error {
+	proto3 := proto.Syntax == "proto3"
+	return Visit(proto, func(node Node, next func() error) error {
+		var err *Error
+		switch node := node.(type) {
+		case *MessageEntry:
+			if node.Extensions != nil && proto3 {
+				err = d.errorf(d.tokenPos(node.Extensions.Pos), "extensions in proto3 are only allowed for defining options, use a google.protobuf.Any field instead",
+					"extension ranges are not allowed in proto3")
+			}
+			if err == nil && node.Reserved != nil {
+				err = d.checkRanges(node.Reserved.Ranges, fieldNumbers)
+			}
+			if err == nil && node.Extensions != nil {
+				err = d.checkRanges(node.Extensions.Extensions, fieldNumbers)
+			}
+		case *EnumEntry:
+			if node.Value != nil && !enumValues.contains(node.Value.Value) {
+				err = d.checkNumber(d.numberPos(node.Value.Pos), node.Value.Value, enumValues)
+			}
+			if err == nil && node.Reserved != nil {
+				err = d.checkRanges(node.Reserved.Ranges, enumValues)
+			}
+		case *OneOf:
+			err = d.checkOneof(node)
+		case *Field:
+			err = d.checkField(node, proto3)
+		case *Direct:
+			err = d.checkFieldNumber(node.Pos, node.Tag)
+		case *Group:
+			if proto3 {
+				err = d.errorf(d.tokenPos(node.Pos), "declare a message and a field of its type instead",
+					"groups are not allowed in proto3")
+			} else {
+				err = d.checkFieldNumber(node.Pos, node.Tag)
+			}
+		}
+		if err != nil {
+			return err
+		}
+		return next()
+	})
+}
+
+// setLiterals sets the Float and Literal of the numbers of a parsed file.
+// Numbers that are not integers are floating point literals, so only the
+// source of integers is lexed.
+func (d *diagnostics) setLiterals(proto *Proto) {
+	_ = Visit(proto, func(node Node, next func() error) error {
+		if v, ok := node.(*Value); ok && v.Number != nil {
+			if v.Number.IsInt() {
+				d.setIntegerLiteral(v)
+			} else {
+				v.Float = true
+			}
+		}
+		return next()
+	})
+}
+
+// setIntegerLiteral sets the Float and Literal of v, an integer, from the
+// tokens of its source.
+func (d *diagnostics) setIntegerLiteral(v *Value) {
+	l, err := lex.Lex(d.filename, strings.NewReader(d.source[v.Pos.Offset:v.EndPos.Offset]))
+	if err != nil {
+		return
+	}
+	tokens, err := lexer.ConsumeAll(l)
+	if err != nil {
+		return
+	}
+	var literal strings.Builder
+	for _, t := range tokens {
+		if IsTrivia(t) || t.EOF() {
+			continue
+		}
+		v.Float = v.Float || t.Type == floatType
+		literal.WriteString(t.Value)
+	}
+	if v.Float || !fitsInt64(v.Number) {
+		v.Literal = literal.String()
+	}
+}
+
+func fitsInt64(f *big.Float) bool {
+	if _, accuracy := f.Uint64(); accuracy == big.Exact {
+		return true
+	}
+	_, accuracy := f.Int64()
+	return accuracy == big.Exact
+}
+
+// unterminated returns a missing ";" error for each statement of a parsed
+// file that is not terminated by a ";", which the grammar accepts.
+func (d *diagnostics) unterminated(proto *Proto) []*Error {
+	var errs []*Error
+	add := func(end lexer.Position, what string) {
+		if err := d.terminated(end, what); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	_ = Visit(proto, func(node Node, next func() error) error {
+		switch node := node.(type) {
+		case *Entry:
+			if node.Package != "" || node.Import != nil || node.Option != nil {
+				add(node.EndPos, "statement")
+			}
+		case *MessageEntry:
+			if node.Option != nil || node.Reserved != nil || node.Extensions != nil {
+				add(node.EndPos, "statement")
+			}
+		case *EnumEntry:
+			if node.Value != nil || node.Option != nil || node.Reserved != nil {
+				add(node.EndPos, "statement")
+			}
+		case *ServiceEntry:
+			if node.Option != nil || (node.Method != nil && !node.Method.HasEntries) {
+				add(node.EndPos, "statement")
+			}
+		case *MethodEntry:
+			if node.Option != nil {
+				add(node.EndPos, "statement")
+			}
+		case *OneOfEntry:
+			if node.Option != nil {
+				add(node.EndPos, "statement")
+			}
+		case *Field:
+			if node.Direct != nil {
+				add(node.EndPos, "field")
+			}
+		}
+		return next()
+	})
+	return errs
+}
+
+// terminated checks that the statement ending at end is terminated by a
+// ";".
+func (d *diagnostics) terminated(end lexer.Position, what string) *Error {
+	last := d.index(end.Offset) - 1
+	if last < 0 || d.value(last) == ";" || d.value(last+1) == ";" {
+		return nil
+	}
+	return d.errorf(d.after(last), fmt.Sprintf(`add ";" at the end of the %s`, what), `missing ";"`)
+}
+
+// label returns the index of the label of a field, if it has one.
+func (d *diagnostics) label(f *Field) (int, bool) {
+	if !f.Optional && !f.Required && !f.Repeated {
+		return 0, false
+	}
+	i := d.index(f.Pos.Offset)
+	switch d.value(i) {
+	case "optional", "required", "repeated":
+		return i, true
+	}
+	return 0, false
+}
+
+func (d *diagnostics) checkField(f *Field, proto3 bool) *Error {
+	hasLabel := f.Optional || f.Required || f.Repeated
+	switch {
+	case hasLabel && f.Direct != nil && f.Direct.Type.Map != nil:
+		label, _ := d.label(f)
+		return d.errorf(d.tokens[label].Pos, fmt.Sprintf(`remove %q, map fields are always repeated`, d.value(label)),
+			"map fields cannot have labels")
+	case f.Required && proto3:
+		label, _ := d.label(f)
+		return d.errorf(d.tokens[label].Pos, `remove "required", fields are optional in proto3`,
+			"required fields are not allowed in proto3")
+	}
+	return nil
+}
+
+func (d *diagnostics) checkOneof(o *OneOf) *Error {
+	for _, e := range o.Entries {
+		f := e.Field
+		if f == nil {
+			continue
+		}
+		if label, ok := d.label(f); ok {
+			hint := fmt.Sprintf(`remove %q, fields in a oneof are always optional`, d.value(label))
+			if f.Repeated {
+				hint = "move the field out of the oneof, or into a message used by a field of the oneof"
+			}
+			return d.errorf(d.tokens[label].Pos, hint, "fields in oneofs cannot have labels")
+		}
+		if f.Direct != nil && f.Direct.Type.Map != nil {
+			return d.errorf(d.tokenPos(f.Direct.Pos), "move the map out of the oneof, or into a message used by a field of the oneof",
+				"map fields are not allowed in oneofs")
+		}
+	}
+	return nil
+}
+
+// numbers is a range of valid field numbers or enum values.
+type numbers struct {
+	what     string
+	min, max int64
+}
+
+var (
+	fieldNumbers = numbers{what: "field number", min: 1, max: maxFieldNumber}
+	enumValues   = numbers{what: "enum value", min: math.MinInt32, max: math.MaxInt32}
+)
+
+func (ns numbers) contains(n *big.Int) bool {
+	return n.IsInt64() && n.Int64() >= ns.min && n.Int64() <= ns.max
+}
+
+// checkNumber checks that the number n at pos is in the range of ns. The
+// numbers of the grammar are not limited, so that literals that do not
+// even fit in an int64 are reported here.
+func (d *diagnostics) checkNumber(pos lexer.Position, n *big.Int, ns numbers) *Error {
+	if !ns.contains(n) {
+		return d.errorf(pos, fmt.Sprintf("%ss must be between %d and %d", ns.what, ns.min, ns.max),
+			"%s %d is out of range", ns.what, n)
+	}
+	return nil
+}
+
+// checkFieldNumber checks the number of the field declared at pos, which
+// also cannot be one of the numbers reserved for the implementation of
+// protobuf.
+func (d *diagnostics) checkFieldNumber(pos lexer.Position, n *big.Int) *Error {
+	reserved := n.IsInt64() && n.Int64() >= 19000 && n.Int64() <= 19999
+	if fieldNumbers.contains(n) && !reserved {
+		return nil
+	}
+	pos = d.numberPos(pos)
+	if err := d.checkNumber(pos, n, fieldNumbers); err != nil {
+		return err
+	}
+	if reserved {
+		return d.errorf(pos, "field numbers 19000 to 19999 cannot be used by fields",
+			"field number %d is reserved for the protobuf implementation", n)
+	}
+	return nil
+}
+
+func (d *diagnostics) checkRanges(ranges []*Range, ns numbers) *Error {
+	for _, r := range ranges {
+		if err := d.checkNumber(r.Pos, r.Start, ns); err != nil {
+			return err
+		}
+		if r.End == nil || ns.contains(r.End) {
+			continue
+		}
+		i := d.index(r.Pos.Offset) + 2 // Skip the start and "to".
+		if i < len(d.tokens) {
+			if err := d.checkNumber(d.tokens[i].Pos, r.End, ns); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// numberPos returns the position of the number assigned by the
+// declaration at pos, which follows its first "=".
+func (d *diagnostics) numberPos(pos lexer.Position) lexer.Position {
+	for i := d.index(pos.Offset); i+1 < len(d.tokens); i++ {
+		if d.value(i) == "=" {
+			return d.tokens[i+1].Pos
+		}
+	}
+	return pos
+}
+
+// tokenPos returns the position of the first token at or after pos.
+func (d *diagnostics) tokenPos(pos lexer.Position) lexer.Position {
+	if i := d.index(pos.Offset); i < len(d.tokens) {
+		return d.tokens[i].Pos
+	}
+	return pos
+}
@@ -0,0 +1,240 @@
+package parser
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSyntaxErrors(t *testing.T) {
+	const proto3 = "syntax = \"proto3\";\n"
+	tests := []struct {
+		name   string
+		source string
+		err    string
+	}{
+		{name: "MissingSemicolonAfterSyntax",
+			source: "syntax = \"proto3\"\npackage p;\n",
+			err: `test.proto:1:18: missing ";"
+ 1 | syntax = "proto3"
+   |                  ^
+hint: add ";" at the end of the statement`},
+		{name: "MissingFieldNumber",
+			source: proto3 + "message M {\n  repeated string names;\n}\n",
+			err: `test.proto:3:24: missing field number for field names
+ 3 |   repeated string names;
+   |                        ^
+hint: add a field number, for example "repeated string names = 1;"`},
+		{name: "MissingMapFieldNumber",
+			source: proto3 + "message M {\n  map<string, int32> counts\n}\n",
+			err: `test.proto:3:28: missing field number for field counts
+ 3 |   map<string, int32> counts
+   |                            ^
+hint: add a field number, for example "map<string, int32> counts = 1;"`},
+		{name: "OptionalInOneof",
+			source: proto3 + "message M {\n  oneof kind {\n    optional string name = 1;\n  }\n}\n",
+			err: `test.proto:4:5: fields in oneofs cannot have labels
+ 4 |     optional string name = 1;
+   |     ^
+hint: remove "optional", fields in a oneof are always optional`},
+		{name: "MapInOneof",
+			source: proto3 + "message M {\n  oneof kind {\n    map<string, string> labels = 1;\n  }\n}\n",
+			err: `test.proto:4:5: map fields are not allowed in oneofs
+ 4 |     map<string, string> labels = 1;
+   |     ^
+hint: move the map out of the oneof, or into a message used by a field of the oneof`},
+		{name: "RepeatedMap",
+			source: proto3 + "message M {\n\trepeated map<string, string> labels = 1;\n}\n",
+			err: "test.proto:3:2: map fields cannot have labels\n" +
+				" 3 | \trepeated map<string, string> labels = 1;\n" +
+				"   | \t^\n" +
+				`hint: remove "repeated", map fields are always repeated`},
+		{name: "RequiredInProto3",
+			source: proto3 + "message M {\n  required string name = 1;\n}\n",
+			err: `test.proto:3:3: required fields are not allowed in proto3
+ 3 |   required string name = 1;
+   |   ^
+hint: remove "required", fields are optional in proto3`},
+		{name: "RepeatedInOneof",
+			source: "syntax = \"proto2\";\nmessage M {\n  oneof kind {\n    repeated string names = 1;\n  }\n}\n",
+			err: `test.proto:4:5: fields in oneofs cannot have labels
+ 4 |     repeated string names = 1;
+   |     ^
+hint: move the field out of the oneof, or into a message used by a field of the oneof`},
+		{name: "LabelledGroupInOneof",
+			source: "syntax = \"proto2\";\nmessage M {\n  oneof kind {\n    optional group G = 1 {}\n  }\n}\n",
+			err: `test.proto:4:5: fields in oneofs cannot have labels
+ 4 |     optional group G = 1 {}
+   |     ^
+hint: remove "optional", fields in a oneof are always optional`},
+		{name: "GroupInProto3",
+			source: proto3 + "message M {\n  repeated group Item = 1 {}\n}\n",
+			err: `test.proto:3:12: groups are not allowed in proto3
+ 3 |   repeated group Item = 1 {}
+   |            ^
+hint: declare a message and a field of its type instead`},
+		{name: "ExtensionsInProto3",
+			source: proto3 + "message M {\n  string name = 1;\n  extensions 100 to max;\n}\n",
+			err: `test.proto:4:3: extension ranges are not allowed in proto3
+ 4 |   extensions 100 to max;
+   |   ^
+hint: extensions in proto3 are only allowed for defining options, use a google.protobuf.Any field instead`},
+		{name: "UnterminatedString",
+			source: proto3 + "message M {\n  string name = 1 [json_name = \"n];\n}\n",
+			err: `test.proto:3:32: unterminated string
+ 3 |   string name = 1 [json_name = "n];
+   |                                ^
+hint: add a closing ", strings cannot span multiple lines`},
+		{name: "EqualsAfterEnumName",
+			source: proto3 + "enum E = {\n  A = 0;\n}\n",
+			err: `test.proto:2:8: unexpected "=" after enum name
+ 2 | enum E = {
+   |        ^
+hint: remove the "=", enums are declared as "enum E { ... }"`},
+		{name: "StrayEqualsInEnumValue",
+			source: proto3 + "enum E {\n  A = 0 = 1;\n}\n",
+			err: `test.proto:3:9: stray "=" in enum value A
+ 3 |   A = 0 = 1;
+   |         ^
+hint: enum values are declared as "NAME = NUMBER;"`},
+		{name: "MissingEnumValueName",
+			source: proto3 + "enum E {\n  A = 0;\n  = 1;\n}\n",
+			err: `test.proto:4:3: missing enum value name before "="
+ 4 |   = 1;
+   |   ^
+hint: enum values are declared as "NAME = NUMBER;"`},
+		{name: "FieldNumberZero",
+			source: proto3 + "message M {\n  string name = 0;\n}\n",
+			err: `test.proto:3:17: field number 0 is out of range
+ 3 |   string name = 0;
+   |                 ^
+hint: field numbers must be between 1 and 536870911`},
+		{name: "FieldNumberTooLarge",
+			source: proto3 + "message M {\n  map<string, int32> m = 0x20000000;\n}\n",
+			err: `test.proto:3:26: field number 536870912 is out of range
+ 3 |   map<string, int32> m = 0x20000000;
+   |                          ^
+hint: field numbers must be between 1 and 536870911`},
+		{name: "FieldNumberReserved",
+			source: proto3 + "message M {\n  string name = 19000 [json_name = \"n\"];\n}\n",
+			err: `test.proto:3:17: field number 19000 is reserved for the protobuf implementation
+ 3 |   string name = 19000 [json_name = "n"];
+   |                 ^
+hint: field numbers 19000 to 19999 cannot be used by fields`},
+		{name: "GroupNumber",
+			source: "syntax = \"proto2\";\nmessage M {\n  optional group G = -1 {}\n}\n",
+			err: `test.proto:3:22: field number -1 is out of range
+ 3 |   optional group G = -1 {}
+   |                      ^
+hint: field numbers must be between 1 and 536870911`},
+		{name: "ExtensionRange",
+			source: "syntax = \"proto2\";\nmessage M {\n  extensions 100 to 536870912;\n}\n",
+			err: `test.proto:3:21: field number 536870912 is out of range
+ 3 |   extensions 100 to 536870912;
+   |                     ^
+hint: field numbers must be between 1 and 536870911`},
+		{name: "ReservedRange",
+			source: proto3 + "message M {\n  reserved 1, 0 to 5;\n}\n",
+			err: `test.proto:3:15: field number 0 is out of range
+ 3 |   reserved 1, 0 to 5;
+   |               ^
+hint: field numbers must be between 1 and 536870911`},
+		{name: "EnumValue",
+			source: proto3 + "enum E {\n  A = 0;\n  B = 0x80000000;\n}\n",
+			err: `test.proto:4:7: enum value 2147483648 is out of range
+ 4 |   B = 0x80000000;
+   |       ^
+hint: enum values must be between -2147483648 and 2147483647`},
+		{name: "NegativeEnumValue",
+			source: proto3 + "enum E {\n  A = 0;\n  B = -2147483649;\n}\n",
+			err: `test.proto:4:7: enum value -2147483649 is out of range
+ 4 |   B = -2147483649;
+   |       ^
+hint: enum values must be between -2147483648 and 2147483647`},
+		{name: "EnumReservedRange",
+			source: proto3 + "enum E {\n  A = 0;\n  reserved -2147483648 to 2147483648;\n}\n",
+			err: `test.proto:4:27: enum value 2147483648 is out of range
+ 4 |   reserved -2147483648 to 2147483648;
+   |                           ^
+hint: enum values must be between -2147483648 and 2147483647`},
+		{name: "FieldNumberBeyondInt64",
+			source: proto3 + "message M {\n  string name = 99999999999999999999;\n}\n",
+			err: `test.proto:3:17: field number 99999999999999999999 is out of range
+ 3 |   string name = 99999999999999999999;
+   |                 ^
+hint: field numbers must be between 1 and 536870911`},
+		{name: "EnumValueBeyondInt64",
+			source: proto3 + "enum E {\n  A = 0;\n  B = -0x10000000000000000;\n}\n",
+			err: `test.proto:4:7: enum value -18446744073709551616 is out of range
+ 4 |   B = -0x10000000000000000;
+   |       ^
+hint: enum values must be between -2147483648 and 2147483647`},
+		{name: "ReservedBeyondInt64",
+			source: proto3 + "message M {\n  reserved 1 to 18446744073709551616;\n}\n",
+			err: `test.proto:3:17: field number 18446744073709551616 is out of range
+ 3 |   reserved 1 to 18446744073709551616;
+   |                 ^
+hint: field numbers must be between 1 and 536870911`},
+		{name: "Other",
+			source: proto3 + "message M {\n  string name = 1;\n}}\n",
+			err: `test.proto:4:2: unexpected token "}"
+ 4 | }}
+   |  ^`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := ParseString("test.proto", test.source)
+			require.EqualError(t, err, test.err)
+			var perr *Error
+			require.ErrorAs(t, err, &perr)
+		})
+	}
+}
+
+func TestNumberRanges(t *testing.T) {
+	_, err := ParseString("test.proto", `syntax = "proto2";
+message M {
+  optional string a = 1;
+  optional string b = 536870911;
+  optional group G = 18999 {}
+  reserved 19000 to 19999;
+  extensions 20000 to max;
+}
+enum E {
+  A = -2147483648;
+  B = 2147483647;
+  reserved -2147483648 to -1000, 1000 to max;
+}
+`)
+	require.NoError(t, err)
+}
+
+func TestDiagnose(t *testing.T) {
+	const proto3 = "syntax = \"proto3\";\n"
+	source := proto3 + "import \"a.proto\"\nmessage M {\n  string name = 1\n  int32 id = 2;\n}\n"
+	// The parser accepts statements without a ";".
+	_, err := ParseString("test.proto", source)
+	require.NoError(t, err)
+	errs, err := Diagnose("test.proto", source)
+	require.NoError(t, err)
+	var got []string
+	for _, err := range errs {
+		got = append(got, err.Error())
+	}
+	require.Equal(t, []string{
+		`test.proto:2:17: missing ";"
+ 2 | import "a.proto"
+   |                 ^
+hint: add ";" at the end of the statement`,
+		`test.proto:4:18: missing ";"
+ 4 |   string name = 1
+   |                  ^
+hint: add ";" at the end of the field`,
+	}, got)
+
+	_, err = Diagnose("test.proto", "syntax = \"proto3\"\npackage p;\n")
+	require.EqualError(t, err, `test.proto:1:18: missing ";"
+ 1 | syntax = "proto3"
+   |                  ^
+hint: add ";" at the end of the statement`)
+}
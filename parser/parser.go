@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"math/big"
+	"strconv"
 	"strings"
 
 	"github.com/alecthomas/participle/v2"
@@ -13,7 +14,8 @@ import (
 )
 
 type Proto struct {
-	Pos lexer.Position
+	Pos    lexer.Position
+	EndPos lexer.Position
 
 	Comments *Comments `@@?`
 
@@ -34,7 +36,8 @@ type Comment struct {
 }
 
 type Entry struct {
-	Pos lexer.Position
+	Pos    lexer.Position
+	EndPos lexer.Position
 
 	Comment *Comment `@@?`
 	Package string   `( "package" @(Ident { "." Ident })`
@@ -54,7 +57,8 @@ type Import struct {
 func (i *Import) children() (out []Node) { return nil }
 
 type Option struct {
-	Pos lexer.Position
+	Pos    lexer.Position
+	EndPos lexer.Position
 
 	Comments *Comments `@@?`
 
@@ -64,7 +68,8 @@ type Option struct {
 }
 
 type OptionName struct {
-	Pos lexer.Position
+	Pos    lexer.Position
+	EndPos lexer.Position
 
 	Name string `( @("."? "(" ("."? Ident { "." Ident }) ")") | @Ident ) "."?`
 }
@@ -72,9 +77,13 @@ type OptionName struct {
 func (o *OptionName) children() []Node { return nil }
 
 type Value struct {
-	Pos lexer.Position
+	Pos    lexer.Position
+	EndPos lexer.Position
 
-	String    *string    `( @String+`
+	String *string `( @String+`
+	// NaN is a signed nan, such as -nan. An unsigned nan, like inf, is a
+	// Reference as it is also an identifier.
+	NaN       *string    `  | @("-nan" | "+nan")`
 	Number    *big.Float `  | ("-" | "+")? (@Float | @Int)`
 	Bool      *Boolean   `  | @("true"|"false")`
 	Reference *string    `  | @("."? Ident { "." Ident })`
@@ -82,6 +91,14 @@ type Value struct {
 	Array     *Array     `  | @@ )`
 
 	TrailingComments *Comments `@@?`
+
+	// Float is true if Number is written as a floating point literal,
+	// such as 5.0, even if it is an integer.
+	Float bool
+	// Literal is the source text of a Number that is an integer written
+	// as a floating point literal, or out of the range of int64 and
+	// uint64, which Number does not represent as written.
+	Literal string
 }
 
 type Boolean bool
@@ -89,7 +106,8 @@ type Boolean bool
 func (b *Boolean) Capture(v []string) error { *b = v[0] == "true"; return nil }
 
 type ProtoText struct {
-	Pos lexer.Position
+	Pos    lexer.Position
+	EndPos lexer.Position
 
 	Fields []*ProtoTextField `"{" ( @@ ( "," | ";" )? )* "}"`
 
@@ -97,7 +115,8 @@ type ProtoText struct {
 }
 
 type ProtoTextField struct {
-	Pos lexer.Position
+	Pos    lexer.Position
+	EndPos lexer.Position
 
 	Comments *Comments `@@?`
 
@@ -107,47 +126,55 @@ type ProtoTextField struct {
 }
 
 type Array struct {
-	Pos lexer.Position
+	Pos    lexer.Position
+	EndPos lexer.Position
 
 	Elements []*Value `"[" [ @@ { [ "," ] @@ } ] "]"`
 }
 
 type Extensions struct {
-	Pos lexer.Position
+	Pos    lexer.Position
+	EndPos lexer.Position
 
 	Extensions []*Range `"extensions" @@ { "," @@ }`
 	Options    Options  `[ "[" @@ { "," @@ } "]" ]`
 }
 
 type Reserved struct {
-	Pos lexer.Position
+	Pos    lexer.Position
+	EndPos lexer.Position
 
 	Ranges     []*Range `@@ { "," @@ }`
 	FieldNames []string `| @String { "," @String }`
 }
 
 type Range struct {
-	Start int  `@Int`
-	End   *int `  [ "to" ( @Int`
-	Max   bool `           | @"max" ) ]`
+	Pos lexer.Position
+
+	Start *big.Int `@Int`
+	End   *big.Int `  [ "to" ( @Int`
+	Max   bool     `           | @"max" ) ]`
 }
 
 type Extend struct {
-	Pos lexer.Position
+	Pos    lexer.Position
+	EndPos lexer.Position
 
 	Reference string   `"extend" @("."? Ident { "." Ident })`
 	Fields    []*Field `"{" { @@ [ ";" ] } "}"`
 }
 
 type Service struct {
-	Pos lexer.Position
+	Pos    lexer.Position
+	EndPos lexer.Position
 
 	Name    string          `"service" @Ident`
 	Entries []*ServiceEntry `[ "{" { @@ [ ";" ] } "}" ]`
 }
 
 type ServiceEntry struct {
-	Pos lexer.Position
+	Pos    lexer.Position
+	EndPos lexer.Position
 
 	Comment *Comment `@@`
 	Option  *Option  `| "option" @@`
@@ -155,7 +182,8 @@ type ServiceEntry struct {
 }
 
 type Method struct {
-	Pos lexer.Position
+	Pos    lexer.Position
+	EndPos lexer.Position
 
 	Name              string         `"rpc" @Ident `
 	StreamingRequest  bool           `"(" [ @"stream" ]`
@@ -167,21 +195,24 @@ type Method struct {
 }
 
 type MethodEntry struct {
-	Pos lexer.Position
+	Pos    lexer.Position
+	EndPos lexer.Position
 
 	Comment *Comment `@@`
 	Option  *Option  `| "option" @@`
 }
 
 type Enum struct {
-	Pos lexer.Position
+	Pos    lexer.Position
+	EndPos lexer.Position
 
 	Name   string       `"enum" @Ident`
 	Values []*EnumEntry `"{" { @@ { ";" } } "}"`
 }
 
 type EnumEntry struct {
-	Pos lexer.Position
+	Pos    lexer.Position
+	EndPos lexer.Position
 
 	Comment  *Comment   `@@`
 	Value    *EnumValue `| @@`
@@ -192,23 +223,26 @@ type EnumEntry struct {
 type Options []*Option
 
 type EnumValue struct {
-	Pos lexer.Position
+	Pos    lexer.Position
+	EndPos lexer.Position
 
-	Key   string `@Ident`
-	Value int    `"=" @( [ "-" ] Int )`
+	Key   string   `@Ident`
+	Value *big.Int `"=" @( [ "-" ] Int )`
 
 	Options Options `[ "[" @@ { "," @@ } "]" ]`
 }
 
 type Message struct {
-	Pos lexer.Position
+	Pos    lexer.Position
+	EndPos lexer.Position
 
 	Name    string          `"message" @Ident`
 	Entries []*MessageEntry `"{" { @@ ( ";"* ) } "}"`
 }
 
 type MessageEntry struct {
-	Pos lexer.Position
+	Pos    lexer.Position
+	EndPos lexer.Position
 
 	Comment    *Comment    `@@`
 	Enum       *Enum       `| @@`
@@ -222,14 +256,16 @@ type MessageEntry struct {
 }
 
 type OneOf struct {
-	Pos lexer.Position
+	Pos    lexer.Position
+	EndPos lexer.Position
 
 	Name    string        `"oneof" @Ident`
 	Entries []*OneOfEntry `"{" { @@ { ";" } } "}"`
 }
 
 type OneOfEntry struct {
-	Pos lexer.Position
+	Pos    lexer.Position
+	EndPos lexer.Position
 
 	Comments *Comments `@@?`
 
@@ -238,7 +274,8 @@ type OneOfEntry struct {
 }
 
 type Field struct {
-	Pos lexer.Position
+	Pos    lexer.Position
+	EndPos lexer.Position
 
 	Comments *Comments `@@?`
 
@@ -253,20 +290,22 @@ type Field struct {
 }
 
 type Direct struct {
-	Pos lexer.Position
+	Pos    lexer.Position
+	EndPos lexer.Position
 
-	Type *Type  `@@`
-	Name string `@Ident`
-	Tag  int    `Comment* "=" @Int`
+	Type *Type    `@@`
+	Name string   `@Ident`
+	Tag  *big.Int `Comment* "=" @Int`
 
 	Options Options `[ "[" @@ { "," @@ } "]" ]`
 }
 
 type Group struct {
-	Pos lexer.Position
+	Pos    lexer.Position
+	EndPos lexer.Position
 
 	Name    string          `"group" @Ident`
-	Tag     int             `"=" @Int`
+	Tag     *big.Int        `"=" @Int`
 	Options Options         `[ "[" @@ { "," @@ } "]" ]`
 	Entries []*MessageEntry `"{" { @@ [ ";" ] } "}"`
 }
@@ -318,7 +357,8 @@ func (s *Scalar) Parse(lex *lexer.PeekingLexer) error {
 }
 
 type Type struct {
-	Pos lexer.Position
+	Pos    lexer.Position
+	EndPos lexer.Position
 
 	Scalar    Scalar   `  @@`
 	Map       *MapType `| @@`
@@ -326,7 +366,8 @@ type Type struct {
 }
 
 type MapType struct {
-	Pos lexer.Position
+	Pos    lexer.Position
+	EndPos lexer.Position
 
 	Key   *Type `"map" "<" @@`
 	Value *Type `"," @@ ">"`
@@ -373,7 +414,9 @@ func (v *Value) ToString() string {
 func (v *Value) indentString(indent string) string {
 	switch {
 	case v.String != nil:
-		return `"` + *v.String + `"`
+		return strconv.Quote(*v.String)
+	case v.NaN != nil:
+		return *v.NaN
 	case v.Number != nil:
 		return v.Number.String()
 	case v.Bool != nil:
@@ -399,28 +442,80 @@ func (a *Array) indentString(indent string) string {
 
 var (
 	lex = lexer.MustSimple([]lexer.SimpleRule{
-		{"String", `"(\\"|[^"])*"|'(\\'|[^'])*'`},
+		{"String", `"(\\[^\n]|[^"\\\n])*"|'(\\[^\n]|[^'\\\n])*'`},
 		{"Ident", `[a-zA-Z_]([a-zA-Z_0-9])*`},
-		{"Float", `[-+]?(\d*\.\d+([eE][-+]?\d+)?|\d+[eE][-+]?\d+|inf)`},
-		{"Int", `[-+]?(0[xX][0-9A-Fa-f]+)|([-+]?\d+)`},
+		// inf and nan are identifiers unless they are signed.
+		{"Float", `[-+]?(\d+\.\d*([eE][-+]?\d+)?|\.\d+([eE][-+]?\d+)?|\d+[eE][-+]?\d+)|[-+](inf(inity)?|nan)\b`},
+		{"Int", `[-+]?(0[xX][0-9A-Fa-f]+|0[0-7]*|[1-9]\d*)`},
 		{"Whitespace", `[ \t\n\r\s]+`},
 		{"Comment", `(/\*([^*]|[\r\n]|(\*+([^*/]|[\r\n])))*\*+/)|(//(.*)[^\n]*(\n|$))`},
 		{"Symbols", `[/={}\[\]()<>.,;:]`},
 	})
 
+	commentType    = lex.Symbols()["Comment"]
+	whitespaceType = lex.Symbols()["Whitespace"]
+	floatType      = lex.Symbols()["Float"]
+
 	parser = participle.MustBuild[Proto](
 		participle.UseLookahead(2),
 		participle.Map(unquote, "String"),
+		participle.Map(number, "Float", "Int"),
 		participle.Lexer(lex),
 		participle.Elide("Whitespace", "Comment"),
 	)
 )
 
 // Parse protobuf.
+//
+// Syntax errors are returned as an *Error.
 func Parse(filename string, r io.Reader) (*Proto, error) {
-	return parser.Parse(filename, r)
+	source, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return ParseString(filename, string(source))
 }
 
 func ParseString(filename string, source string) (*Proto, error) {
-	return parser.ParseString(filename, source)
+	proto, err := parser.ParseString(filename, source)
+	d := newDiagnostics(filename, source)
+	if err != nil {
+		return nil, d.diagnose(err)
+	}
+	if err := d.check(proto); err != nil {
+		return nil, err
+	}
+	d.setLiterals(proto)
+	return proto, nil
+}
+
+// Diagnose returns the mistakes in source that ParseString accepts but
+// protoc rejects, such as a missing ";" at the end of a statement, or the
+// error of ParseString.
+func Diagnose(filename string, source string) ([]*Error, error) {
+	proto, err := ParseString(filename, source)
+	if err != nil {
+		return nil, err
+	}
+	return newDiagnostics(filename, source).unterminated(proto), nil
+}
+
+// Lex protobuf source into all of its tokens, including the whitespace
+// and comments that are elided by Parse.
+func Lex(filename string, r io.Reader) ([]lexer.Token, error) {
+	l, err := lex.Lex(filename, r)
+	if err != nil {
+		return nil, err
+	}
+	return lexer.ConsumeAll(l)
+}
+
+// IsComment returns true if the token is a comment.
+func IsComment(t lexer.Token) bool {
+	return t.Type == commentType
+}
+
+// IsTrivia returns true if the token is a comment or whitespace.
+func IsTrivia(t lexer.Token) bool {
+	return IsComment(t) || t.Type == whitespaceType
 }
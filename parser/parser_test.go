@@ -109,6 +109,10 @@ func TestProtoTextString(t *testing.T) {
 		name: "string",
 		in:   Value{String: strP("howdy")},
 		want: `"howdy"`,
+	}, {
+		name: "escaped string",
+		in:   Value{String: strP("say \"hi\"\n")},
+		want: `"say \"hi\"\n"`,
 	}, {
 		name: "bool",
 		in:   Value{Bool: boolP(true)},
@@ -192,7 +196,11 @@ func imports(from *Proto) []*Import {
 var zeroPos = reflect.ValueOf(lexer.Position{})
 
 func clearPos(node Node, next func() error) error {
-	reflect.Indirect(reflect.ValueOf(node)).FieldByName("Pos").Set(zeroPos)
+	v := reflect.Indirect(reflect.ValueOf(node))
+	v.FieldByName("Pos").Set(zeroPos)
+	if endPos := v.FieldByName("EndPos"); endPos.IsValid() {
+		endPos.Set(zeroPos)
+	}
 	return next()
 }
 
@@ -4,13 +4,13 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
-	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
 
 	"github.com/alecthomas/participle/v2/lexer"
 )
 
-var escapeTable = map[rune]rune{
-	'0':  '\000',
+var escapeTable = map[byte]byte{
 	'a':  '\x07',
 	'b':  '\x08',
 	'e':  '\x1B',
@@ -25,95 +25,134 @@ var escapeTable = map[rune]rune{
 	'?':  '\x3F',
 }
 
-var stateBase = map[unquoteState]int{
-	uqHex: 16, uqOctal: 8,
+// C-style unquoting of single and double quoted strings, with the escapes
+// of the protobuf language:
+//
+//   - \a, \b, \e, \f, \n, \r, \t, \v, \\, \', \" and \?
+//   - octal \D to \DDD and hex \xH or \xHH bytes
+//   - \uHHHH and \UHHHHHHHH Unicode code points, encoded as UTF-8. A pair
+//     of \u escapes of UTF-16 surrogates is combined into one code point.
+func unquote(token lexer.Token) (lexer.Token, error) {
+	s := token.Value[1 : len(token.Value)-1]
+	var out strings.Builder
+	for i := 0; i < len(s); {
+		if s[i] != '\\' {
+			out.WriteByte(s[i])
+			i++
+			continue
+		}
+		n, err := unescape(s[i:], &out)
+		if err != nil {
+			return token, fmt.Errorf("%s: %s: %w", token.Pos, token.Value, err)
+		}
+		i += n
+	}
+	token.Value = out.String()
+	return token, nil
 }
 
-type unquoteState int
-
-const (
-	uqDefault unquoteState = iota
-	uqEscape
-	uqHex
-	uqOctal
-)
-
-// C-style unquoting - supports octal \DDD and hex \xDDD
-func unquote(token lexer.Token) (lexer.Token, error) {
-	kind := token.Value[0] // nolint: ifshort
-	token.Value = token.Value[1 : len(token.Value)-1]
-	// Single quoted, no escaping.
-	if kind == '\'' {
-		return token, nil
+// unescape writes the value of the escape sequence at the start of s to
+// out and returns its length.
+func unescape(s string, out *strings.Builder) (int, error) {
+	if len(s) < 2 {
+		return 0, fmt.Errorf("incomplete escape sequence")
 	}
-	out := strings.Builder{}
-	state := uqDefault
-	// Digits being collected in hex/octal modes
-	var digits string
-	for _, rn := range token.Value {
-		switch state {
-		case uqEscape:
-			if rn == 'x' { // nolint: gocritic
-				state = uqHex
-			} else if unicode.Is(unicode.Digit, rn) {
-				state = uqOctal
-				digits = string(rn)
-			} else {
-				escaped, ok := escapeTable[rn]
-				if !ok {
-					return token, fmt.Errorf("%s: %q: unknown escape sequence \"\\%c\"", token.Pos, token.Value, rn)
-				}
-				out.WriteRune(escaped)
-				state = uqDefault
-			}
-			continue
-		case uqHex:
-			if unicode.Is(unicode.ASCII_Hex_Digit, rn) && len(digits) < 2 {
-				digits += string(rn)
-				continue
-			}
-			if err := flushDigits(digits, 16, &out); err != nil {
-				return token, fmt.Errorf("%s: %w", token.Pos, err)
-			}
-			state = uqDefault
-			digits = ""
-		case uqOctal:
-			if unicode.IsDigit(rn) && len(digits) < 3 {
-				digits += string(rn)
-				continue
+	switch c := s[1]; {
+	case isOctal(c):
+		n := 2
+		for n < len(s) && n < 4 && isOctal(s[n]) {
+			n++
+		}
+		v, _ := strconv.ParseUint(s[1:n], 8, 16)
+		if v > 255 {
+			return 0, fmt.Errorf("octal escape sequence %q is larger than 255", s[:n])
+		}
+		out.WriteByte(byte(v))
+		return n, nil
+	case c == 'x' || c == 'X':
+		n := 2
+		for n < len(s) && n < 4 && isHex(s[n]) {
+			n++
+		}
+		if n == 2 {
+			return 0, fmt.Errorf("hex escape sequence %q has no digits", s[:n])
+		}
+		v, _ := strconv.ParseUint(s[2:n], 16, 8)
+		out.WriteByte(byte(v))
+		return n, nil
+	case c == 'u' || c == 'U':
+		r, n, err := unescapeRune(s)
+		if err != nil {
+			return 0, err
+		}
+		if utf16.IsSurrogate(r) {
+			// A high surrogate must be followed by a low surrogate.
+			low, m, err := unescapeRune(s[n:])
+			if err != nil || s[n+1] != 'u' {
+				return 0, fmt.Errorf("unpaired surrogate %q", s[:n])
 			}
-			if err := flushDigits(digits, 8, &out); err != nil {
-				return token, fmt.Errorf("%s: %w", token.Pos, err)
+			if r = utf16.DecodeRune(r, low); r == utf8.RuneError {
+				return 0, fmt.Errorf("invalid surrogate pair %q", s[:n+m])
 			}
-			state = uqDefault
-			digits = ""
-		case uqDefault:
-		default:
-			panic(state)
+			n += m
 		}
-		if rn == '\\' {
-			state = uqEscape
-		} else {
-			out.WriteRune(rn)
+		out.WriteRune(r)
+		return n, nil
+	default:
+		v, ok := escapeTable[c]
+		if !ok {
+			return 0, fmt.Errorf("unknown escape sequence %q", s[:2])
 		}
+		out.WriteByte(v)
+		return 2, nil
 	}
-	if digits != "" {
-		if err := flushDigits(digits, stateBase[state], &out); err != nil {
-			return token, fmt.Errorf("%s: %w", token.Pos, err)
-		}
+}
+
+// unescapeRune returns the code point of the \u or \U escape sequence at
+// the start of s and its length.
+func unescapeRune(s string) (rune, int, error) {
+	if len(s) < 2 || s[0] != '\\' || (s[1] != 'u' && s[1] != 'U') {
+		return 0, 0, fmt.Errorf("expected a Unicode escape sequence")
 	}
-	token.Value = out.String()
-	return token, nil
+	n := 2 + 4
+	if s[1] == 'U' {
+		n = 2 + 8
+	}
+	if len(s) < n || strings.IndexFunc(s[2:n], func(r rune) bool { return r > 0x7f || !isHex(byte(r)) }) >= 0 {
+		return 0, 0, fmt.Errorf("escape sequence \\%c must have %d hex digits", s[1], n-2)
+	}
+	v, _ := strconv.ParseUint(s[2:n], 16, 32)
+	if v > utf8.MaxRune {
+		return 0, 0, fmt.Errorf("escape sequence %q is not a Unicode code point", s[:n])
+	}
+	return rune(v), n, nil
 }
 
-func flushDigits(digits string, base int, out *strings.Builder) error {
-	n, err := strconv.ParseUint(digits, base, 32)
-	if err != nil {
-		return fmt.Errorf("invalid base %d numeric value %s", base, digits)
+func isOctal(c byte) bool { return '0' <= c && c <= '7' }
+
+func isHex(c byte) bool {
+	return ('0' <= c && c <= '9') || ('a' <= c && c <= 'f') || ('A' <= c && c <= 'F')
+}
+
+// number normalises numeric literals so that they can be captured into
+// both *big.Int fields, which are parsed with base 0, and *big.Float
+// fields:
+//
+//   - octal integers such as 0755 are prefixed with 0o, as big.Float
+//     would otherwise parse them as decimal.
+//   - infinity is shortened to inf.
+func number(token lexer.Token) (lexer.Token, error) {
+	v := token.Value
+	sign := ""
+	if v[0] == '-' || v[0] == '+' {
+		sign, v = v[:1], v[1:]
 	}
-	if n > 255 {
-		return fmt.Errorf("base %d value %s larger than 255", base, digits)
+	switch {
+	case v == "infinity":
+		v = "inf"
+	case len(v) > 1 && v[0] == '0' && isOctal(v[1]):
+		v = "0o" + v[1:]
 	}
-	out.WriteByte(byte(n))
-	return nil
+	token.Value = sign + v
+	return token, nil
 }
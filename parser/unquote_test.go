@@ -1,30 +1,296 @@
 package parser
 
 import (
+	"math"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/alecthomas/participle/v2/lexer"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	pb "google.golang.org/protobuf/types/descriptorpb"
 )
 
 func TestUnquote(t *testing.T) {
 	tests := []struct {
 		input    string
 		expected string
+		err      string
 	}{
-		{`"\n\027"`, "\n\027"},
-		{`"\?"`, "\x3f"},
-		{`'\n\027'`, `\n\027`},
-		{`"\n\x17"`, "\n\027"},
-		{`"hello\0world"`, "hello\000world"},
-		{`"hello\x0world"`, "hello\000world"},
-		{`"\0001"`, "\0001"},
-		{`"\x001"`, "\x001"},
-		{`"\341\210\264"`, "ሴ"},
+		{input: `"\n\027"`, expected: "\n\027"},
+		{input: `"\?"`, expected: "\x3f"},
+		{input: `"\a\b\e\f\n\r\t\v\\\'\""`, expected: "\a\b\x1b\f\n\r\t\v\\'\""},
+		{input: `"\n\x17"`, expected: "\n\027"},
+		{input: `"\X41\x4a"`, expected: "AJ"},
+		{input: `"hello\0world"`, expected: "hello\000world"},
+		{input: `"hello\x0world"`, expected: "hello\000world"},
+		{input: `"\0001"`, expected: "\0001"},
+		{input: `"\x001"`, expected: "\x001"},
+		{input: `"\1\12\123\1234"`, expected: "\001\012\123\123" + "4"},
+		{input: `"\341\210\264"`, expected: "ሴ"},
+		{input: `"\8"`, err: `"\8": unknown escape sequence "\\8"`},
+		{input: `"\400"`, err: `"\400": octal escape sequence "\\400" is larger than 255`},
+		{input: `"\xg"`, err: `"\xg": hex escape sequence "\\x" has no digits`},
+		{input: `"\q"`, err: `"\q": unknown escape sequence "\\q"`},
+		// Unicode escapes.
+		{input: `"ሴ"`, expected: "ሴ"},
+		{input: `"été"`, expected: "été"},
+		{input: `"\U0001F600"`, expected: "😀"},
+		{input: `"\U0010FFFF"`, expected: "\U0010FFFF"},
+		{input: `"😀"`, expected: "😀"},
+		{input: `"\u123"`, err: `"\u123": escape sequence \u must have 4 hex digits`},
+		{input: `"\U1F600"`, err: `"\U1F600": escape sequence \U must have 8 hex digits`},
+		{input: `"\U00110000"`, err: `"\U00110000": escape sequence "\\U00110000" is not a Unicode code point`},
+		{input: `"\uD83D"`, err: `"\uD83D": unpaired surrogate "\\uD83D"`},
+		{input: `"\uD83Dx"`, err: `"\uD83Dx": unpaired surrogate "\\uD83D"`},
+		{input: `"\uDE00\uD83D"`, err: `"\uDE00\uD83D": invalid surrogate pair "\\uDE00\\uD83D"`},
+		// Single quoted strings have the same escapes.
+		{input: `'\n\027'`, expected: "\n\027"},
+		{input: `'it\'s "quoted"'`, expected: `it's "quoted"`},
+		{input: `'é'`, expected: "é"},
 	}
 	for _, test := range tests {
-		actual, err := unquote(lexer.Token{Value: test.input})
+		t.Run(test.input, func(t *testing.T) {
+			actual, err := unquote(lexer.Token{Value: test.input, Pos: lexer.Position{Filename: "test.proto", Line: 1, Column: 1}})
+			if test.err != "" {
+				require.EqualError(t, err, "test.proto:1:1: "+test.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, test.expected, actual.Value)
+		})
+	}
+}
+
+func TestNumbers(t *testing.T) {
+	inf, nan := math.Inf(1), math.NaN()
+	tests := []struct {
+		input string
+		want  float64
+		// isNaN is true if the value is a signed nan, and ref true if it is
+		// an identifier.
+		isNaN bool
+		ref   bool
+	}{
+		{input: "0", want: 0},
+		{input: "42", want: 42},
+		{input: "-42", want: -42},
+		{input: "+42", want: 42},
+		{input: "0755", want: 0o755},
+		{input: "-0755", want: -0o755},
+		{input: "00", want: 0},
+		{input: "0x1F", want: 0x1f},
+		{input: "0XaB", want: 0xab},
+		{input: "-0x80000000", want: -0x80000000},
+		{input: "18446744073709551615", want: math.MaxUint64},
+		{input: "1.5", want: 1.5},
+		{input: "1.", want: 1},
+		{input: ".5", want: 0.5},
+		{input: "-.5", want: -0.5},
+		{input: "1e3", want: 1000},
+		{input: "1E+3", want: 1000},
+		{input: "2.5e-3", want: 0.0025},
+		{input: "1.e2", want: 100},
+		{input: ".5E1", want: 5},
+		{input: "-8e-28", want: -8e-28},
+		{input: "-inf", want: -inf},
+		{input: "+inf", want: inf},
+		{input: "-infinity", want: -inf},
+		{input: "+infinity", want: inf},
+		{input: "-nan", want: nan, isNaN: true},
+		{input: "+nan", want: nan, isNaN: true},
+		{input: "inf", ref: true},
+		{input: "infinity", ref: true},
+		{input: "nan", ref: true},
+	}
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			proto, err := ParseString("test.proto", "option (n) = "+test.input+";")
+			require.NoError(t, err)
+			v := proto.Entries[0].Option.Value
+			switch {
+			case test.ref:
+				require.Equal(t, test.input, *v.Reference)
+			case test.isNaN:
+				require.Equal(t, test.input, *v.NaN)
+			default:
+				require.NotNil(t, v.Number)
+				got, _ := v.Number.Float64()
+				require.Equal(t, test.want, got)
+			}
+		})
+	}
+}
+
+func TestIntegerLiterals(t *testing.T) {
+	proto, err := ParseString("test.proto", `
+enum E {
+  A = 0;
+  B = 010;
+  C = -0x10;
+}
+message M {
+  reserved 010 to 0x10;
+  optional int32 f = 011;
+}
+`)
+	require.NoError(t, err)
+	values := proto.Entries[0].Enum.Values
+	require.Equal(t, int64(8), values[1].Value.Value.Int64())
+	require.Equal(t, int64(-16), values[2].Value.Value.Int64())
+	entries := proto.Entries[1].Message.Entries
+	require.Equal(t, int64(8), entries[0].Reserved.Ranges[0].Start.Int64())
+	require.Equal(t, int64(16), entries[0].Reserved.Ranges[0].End.Int64())
+	require.Equal(t, int64(9), entries[1].Field.Direct.Tag.Int64())
+}
+
+func TestInvalidLiterals(t *testing.T) {
+	for _, input := range []string{"09", "0x", "-info", `"a`, `'a\'`} {
+		t.Run(input, func(t *testing.T) {
+			_, err := ParseString("test.proto", "option (n) = "+input+";")
+			require.Error(t, err)
+		})
+	}
+}
+
+// TestDefaultValues compares the values of the default options of the
+// conformance protos with the default_value fields protoc generated for
+// them.
+func TestDefaultValues(t *testing.T) {
+	files, err := filepath.Glob("../testdata/conformance/*.proto")
+	require.NoError(t, err)
+	count := 0
+	for _, file := range files {
+		name := strings.TrimSuffix(filepath.Base(file), ".proto")
+		b, err := os.ReadFile("../testdata/conformance/pb/" + name + ".pb")
+		require.NoError(t, err)
+		fds := &pb.FileDescriptorSet{}
+		require.NoError(t, proto.Unmarshal(b, fds))
+		reg, err := protodesc.FileOptions{AllowUnresolvable: true}.NewFiles(fds)
+		require.NoError(t, err)
+		r, err := os.Open(file)
+		require.NoError(t, err)
+		p, err := Parse(file, r)
+		_ = r.Close()
+		require.NoError(t, err)
+		for _, f := range defaultValues(p) {
+			t.Run(f.name, func(t *testing.T) {
+				d, err := reg.FindDescriptorByName(protoreflect.FullName(f.name))
+				require.NoError(t, err)
+				fd := protodesc.ToFieldDescriptorProto(d.(protoreflect.FieldDescriptor))
+				requireDefault(t, fd, f.value)
+			})
+			count++
+		}
+	}
+	require.Greater(t, count, 50)
+}
+
+type defaultValue struct {
+	name  string
+	value *Value
+}
+
+// defaultValues returns the values of the default options of the fields
+// of p, by the full names of the fields.
+func defaultValues(p *Proto) []defaultValue {
+	var pkg string
+	for _, e := range p.Entries {
+		if e.Package != "" {
+			pkg = e.Package + "."
+		}
+	}
+	var out []defaultValue
+	var fields func(scope string, fs []*Field)
+	var entries func(scope string, es []*MessageEntry)
+	fields = func(scope string, fs []*Field) {
+		for _, f := range fs {
+			switch {
+			case f.Group != nil:
+				entries(scope+f.Group.Name+".", f.Group.Entries)
+			case f.Direct != nil:
+				for _, o := range f.Direct.Options {
+					if len(o.Name) == 1 && o.Name[0].Name == "default" {
+						out = append(out, defaultValue{name: scope + f.Direct.Name, value: o.Value})
+					}
+				}
+			}
+		}
+	}
+	entries = func(scope string, es []*MessageEntry) {
+		for _, e := range es {
+			switch {
+			case e.Message != nil:
+				entries(scope+e.Message.Name+".", e.Message.Entries)
+			case e.Field != nil:
+				fields(scope, []*Field{e.Field})
+			case e.Extend != nil:
+				fields(scope, e.Extend.Fields)
+			case e.Oneof != nil:
+				for _, oe := range e.Oneof.Entries {
+					if oe.Field != nil {
+						fields(scope, []*Field{oe.Field})
+					}
+				}
+			}
+		}
+	}
+	for _, e := range p.Entries {
+		switch {
+		case e.Message != nil:
+			entries(pkg+e.Message.Name+".", e.Message.Entries)
+		case e.Extend != nil:
+			fields(pkg, e.Extend.Fields)
+		}
+	}
+	return out
+}
+
+func requireDefault(t *testing.T, fd *pb.FieldDescriptorProto, v *Value) {
+	t.Helper()
+	want := fd.GetDefaultValue()
+	switch fd.GetType() { //nolint:exhaustive
+	case pb.FieldDescriptorProto_TYPE_STRING:
+		require.Equal(t, want, *v.String)
+	case pb.FieldDescriptorProto_TYPE_BYTES:
+		// protoc C-escapes the default values of bytes fields.
+		unescaped, err := unquote(lexer.Token{Value: `"` + want + `"`})
 		require.NoError(t, err)
-		require.Equal(t, test.expected, actual.Value)
+		require.Equal(t, unescaped.Value, *v.String)
+	case pb.FieldDescriptorProto_TYPE_FLOAT, pb.FieldDescriptorProto_TYPE_DOUBLE:
+		switch {
+		case v.Reference != nil:
+			require.Equal(t, want, *v.Reference)
+		case v.NaN != nil:
+			require.Equal(t, want, *v.NaN)
+		default:
+			bits := 64
+			if fd.GetType() == pb.FieldDescriptorProto_TYPE_FLOAT {
+				bits = 32
+			}
+			f, err := strconv.ParseFloat(want, bits)
+			require.NoError(t, err)
+			got, _ := v.Number.Float64()
+			if bits == 32 {
+				got = float64(float32(got))
+			}
+			require.Equal(t, f, got)
+		}
+	case pb.FieldDescriptorProto_TYPE_BOOL:
+		require.Equal(t, want, strconv.FormatBool(bool(*v.Bool)))
+	case pb.FieldDescriptorProto_TYPE_ENUM:
+		require.Equal(t, want, *v.Reference)
+	default:
+		n, ok := new(big.Int).SetString(want, 10)
+		require.True(t, ok)
+		got, acc := v.Number.Int(nil)
+		require.Equal(t, big.Exact, acc)
+		require.Equal(t, n.String(), got.String())
 	}
 }
@@ -0,0 +1,113 @@
+// Package reflection serves the gRPC Server Reflection protocol from the
+// files of a compiler.Registry, so that clients such as grpcurl can
+// discover services whose protos are not compiled into Go.
+package reflection
+
+import (
+	"google.golang.org/grpc"
+	grpcreflection "google.golang.org/grpc/reflection"
+	v1reflectiongrpc "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	v1alphareflectiongrpc "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/dynamicpb"
+
+	"github.com/alecthomas/protobuf/compiler"
+)
+
+// Register registers the v1 and v1alpha reflection services on s,
+// answering queries from the files of reg and listing the services
+// declared in them.
+func Register(s grpc.ServiceRegistrar, reg *compiler.Registry) {
+	v1alphareflectiongrpc.RegisterServerReflectionServer(s, NewServer(reg))
+	v1reflectiongrpc.RegisterServerReflectionServer(s, NewServerV1(reg))
+}
+
+// NewServer returns the v1alpha reflection service for the files of reg.
+func NewServer(reg *compiler.Registry) v1alphareflectiongrpc.ServerReflectionServer {
+	return grpcreflection.NewServer(serverOptions(reg))
+}
+
+// NewServerV1 returns the v1 reflection service for the files of reg.
+func NewServerV1(reg *compiler.Registry) v1reflectiongrpc.ServerReflectionServer {
+	return grpcreflection.NewServerV1(serverOptions(reg))
+}
+
+func serverOptions(reg *compiler.Registry) grpcreflection.ServerOptions {
+	return grpcreflection.ServerOptions{
+		Services:           services{reg},
+		DescriptorResolver: reg,
+		ExtensionResolver:  extensions{reg},
+	}
+}
+
+// services lists the services declared in the files of a registry.
+type services struct{ reg *compiler.Registry }
+
+// GetServiceInfo returns the services of the registry like
+// grpc.Server.GetServiceInfo, with the path of the file declaring each
+// service as its metadata.
+func (s services) GetServiceInfo() map[string]grpc.ServiceInfo {
+	info := map[string]grpc.ServiceInfo{}
+	s.reg.RangeFiles(func(fd protoreflect.FileDescriptor) bool {
+		for i := 0; i < fd.Services().Len(); i++ {
+			sd := fd.Services().Get(i)
+			si := grpc.ServiceInfo{Metadata: fd.Path()}
+			for j := 0; j < sd.Methods().Len(); j++ {
+				md := sd.Methods().Get(j)
+				si.Methods = append(si.Methods, grpc.MethodInfo{
+					Name:           string(md.Name()),
+					IsClientStream: md.IsStreamingClient(),
+					IsServerStream: md.IsStreamingServer(),
+				})
+			}
+			info[string(sd.FullName())] = si
+		}
+		return true
+	})
+	return info
+}
+
+// extensions resolves the extensions declared in the files of a registry.
+// Extension types the registry resolves from protoregistry.GlobalTypes
+// are replaced by the declarations in its files, so that the files of
+// the registry are served rather than those compiled into the binary.
+type extensions struct{ reg *compiler.Registry }
+
+func (e extensions) FindExtensionByName(field protoreflect.FullName) (protoreflect.ExtensionType, error) {
+	xt, err := e.reg.FindExtensionByName(field)
+	if err != nil {
+		return nil, err
+	}
+	return e.local(xt)
+}
+
+func (e extensions) FindExtensionByNumber(message protoreflect.FullName, field protoreflect.FieldNumber) (protoreflect.ExtensionType, error) {
+	xt, err := e.reg.FindExtensionByNumber(message, field)
+	if err != nil {
+		return nil, err
+	}
+	return e.local(xt)
+}
+
+func (e extensions) RangeExtensionsByMessage(message protoreflect.FullName, fn func(protoreflect.ExtensionType) bool) {
+	e.reg.RangeExtensionsByMessage(message, fn)
+}
+
+// local returns the type of the extension declared in the files of the
+// registry with the name and number of xt.
+func (e extensions) local(xt protoreflect.ExtensionType) (protoreflect.ExtensionType, error) {
+	xd := xt.TypeDescriptor()
+	desc, err := e.reg.FindDescriptorByName(xd.FullName())
+	if err != nil {
+		return nil, err
+	}
+	if desc == xd.Descriptor() {
+		return xt, nil
+	}
+	local, ok := desc.(protoreflect.ExtensionDescriptor)
+	if !ok || local.Number() != xd.Number() || local.ContainingMessage().FullName() != xd.ContainingMessage().FullName() {
+		return nil, protoregistry.NotFound
+	}
+	return dynamicpb.NewExtensionType(local), nil
+}
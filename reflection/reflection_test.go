@@ -0,0 +1,202 @@
+package reflection
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	v1reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	v1alphareflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+	pb "google.golang.org/protobuf/types/descriptorpb"
+
+	"github.com/alecthomas/protobuf/compiler"
+)
+
+func TestReflection(t *testing.T) {
+	tests := []struct {
+		name    string
+		request *v1reflectionpb.ServerReflectionRequest
+		files   []string
+		numbers []int32
+		err     codes.Code
+	}{
+		{name: "FileByFilename",
+			request: &v1reflectionpb.ServerReflectionRequest{
+				MessageRequest: &v1reflectionpb.ServerReflectionRequest_FileByFilename{FileByFilename: "greeter.proto"}},
+			files: []string{"greeter.proto", "google/api/annotations.proto", "google/protobuf/descriptor.proto", "google/api/http.proto"}},
+		{name: "FileByUnknownFilename",
+			request: &v1reflectionpb.ServerReflectionRequest{
+				MessageRequest: &v1reflectionpb.ServerReflectionRequest_FileByFilename{FileByFilename: "unknown.proto"}},
+			err: codes.NotFound},
+		{name: "FileContainingService",
+			request: &v1reflectionpb.ServerReflectionRequest{
+				MessageRequest: &v1reflectionpb.ServerReflectionRequest_FileContainingSymbol{FileContainingSymbol: "greeter.v1.Greeter"}},
+			files: []string{"greeter.proto", "google/api/annotations.proto", "google/protobuf/descriptor.proto", "google/api/http.proto"}},
+		{name: "FileContainingMethod",
+			request: &v1reflectionpb.ServerReflectionRequest{
+				MessageRequest: &v1reflectionpb.ServerReflectionRequest_FileContainingSymbol{FileContainingSymbol: "greeter.v1.Greeter.BidiHello"}},
+			files: []string{"greeter.proto", "google/api/annotations.proto", "google/protobuf/descriptor.proto", "google/api/http.proto"}},
+		{name: "FileContainingMessage",
+			request: &v1reflectionpb.ServerReflectionRequest{
+				MessageRequest: &v1reflectionpb.ServerReflectionRequest_FileContainingSymbol{FileContainingSymbol: "google.api.HttpRule"}},
+			files: []string{"google/api/http.proto"}},
+		{name: "FileContainingUnknownSymbol",
+			request: &v1reflectionpb.ServerReflectionRequest{
+				MessageRequest: &v1reflectionpb.ServerReflectionRequest_FileContainingSymbol{FileContainingSymbol: "greeter.v1.Unknown"}},
+			err: codes.NotFound},
+		{name: "FileContainingExtension",
+			request: &v1reflectionpb.ServerReflectionRequest{
+				MessageRequest: &v1reflectionpb.ServerReflectionRequest_FileContainingExtension{FileContainingExtension: &v1reflectionpb.ExtensionRequest{
+					ContainingType: "google.protobuf.MethodOptions", ExtensionNumber: 50000}}},
+			files: []string{"greeter.proto", "google/api/annotations.proto", "google/protobuf/descriptor.proto", "google/api/http.proto"}},
+		{name: "FileContainingCompiledInExtension",
+			request: &v1reflectionpb.ServerReflectionRequest{
+				MessageRequest: &v1reflectionpb.ServerReflectionRequest_FileContainingExtension{FileContainingExtension: &v1reflectionpb.ExtensionRequest{
+					ContainingType: "google.protobuf.MethodOptions", ExtensionNumber: 72295728}}},
+			files: []string{"google/api/annotations.proto", "google/api/http.proto", "google/protobuf/descriptor.proto"}},
+		{name: "FileContainingUnknownExtension",
+			request: &v1reflectionpb.ServerReflectionRequest{
+				MessageRequest: &v1reflectionpb.ServerReflectionRequest_FileContainingExtension{FileContainingExtension: &v1reflectionpb.ExtensionRequest{
+					ContainingType: "google.protobuf.MethodOptions", ExtensionNumber: 50001}}},
+			err: codes.NotFound},
+		{name: "AllExtensionNumbersOfType",
+			request: &v1reflectionpb.ServerReflectionRequest{
+				MessageRequest: &v1reflectionpb.ServerReflectionRequest_AllExtensionNumbersOfType{AllExtensionNumbersOfType: "google.protobuf.MethodOptions"}},
+			numbers: []int32{50000, 72295728}},
+	}
+	conn := newConn(t)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// Files are sent once per stream, so each test has its own.
+			for version, newStream := range map[string]func(*testing.T, *grpc.ClientConn) func(*v1reflectionpb.ServerReflectionRequest) *v1reflectionpb.ServerReflectionResponse{
+				"v1": newV1Stream, "v1alpha": newV1AlphaStream} {
+				t.Run(version, func(t *testing.T) {
+					resp := newStream(t, conn)(test.request)
+					if test.err != codes.OK {
+						require.Equal(t, int32(test.err), resp.GetErrorResponse().GetErrorCode(), "%v", resp)
+						return
+					}
+					require.Nil(t, resp.GetErrorResponse())
+					if test.numbers != nil {
+						require.Equal(t, test.request.GetAllExtensionNumbersOfType(), resp.GetAllExtensionNumbersResponse().GetBaseTypeName())
+						require.Equal(t, test.numbers, resp.GetAllExtensionNumbersResponse().GetExtensionNumber())
+						return
+					}
+					var files []string
+					for _, b := range resp.GetFileDescriptorResponse().GetFileDescriptorProto() {
+						fd := &pb.FileDescriptorProto{}
+						require.NoError(t, proto.Unmarshal(b, fd))
+						files = append(files, fd.GetName())
+					}
+					require.Equal(t, test.files, files)
+				})
+			}
+		})
+	}
+}
+
+func TestListServices(t *testing.T) {
+	conn := newConn(t)
+	// Only the services of the registry are listed.
+	want := []string{"greeter.v1.Greeter"}
+	for version, stream := range map[string]func(*v1reflectionpb.ServerReflectionRequest) *v1reflectionpb.ServerReflectionResponse{
+		"v1": newV1Stream(t, conn), "v1alpha": newV1AlphaStream(t, conn)} {
+		t.Run(version, func(t *testing.T) {
+			resp := stream(&v1reflectionpb.ServerReflectionRequest{
+				MessageRequest: &v1reflectionpb.ServerReflectionRequest_ListServices{}})
+			var got []string
+			for _, s := range resp.GetListServicesResponse().GetService() {
+				got = append(got, s.GetName())
+			}
+			require.Equal(t, want, got)
+		})
+	}
+}
+
+func TestFilesOfRegistry(t *testing.T) {
+	// The reflection service serves the descriptor.proto of the registry,
+	// not the one compiled into the binary, which has more fields.
+	stream := newV1Stream(t, newConn(t))
+	resp := stream(&v1reflectionpb.ServerReflectionRequest{
+		MessageRequest: &v1reflectionpb.ServerReflectionRequest_FileContainingSymbol{FileContainingSymbol: "google.protobuf.MethodOptions"}})
+	require.Len(t, resp.GetFileDescriptorResponse().GetFileDescriptorProto(), 1)
+	fd := &pb.FileDescriptorProto{}
+	require.NoError(t, proto.Unmarshal(resp.GetFileDescriptorResponse().GetFileDescriptorProto()[0], fd))
+	for _, md := range fd.MessageType {
+		if md.GetName() != "MethodOptions" {
+			continue
+		}
+		for _, field := range md.Field {
+			require.NotEqual(t, "features", field.GetName())
+		}
+		return
+	}
+	t.Fatal("MethodOptions not found")
+}
+
+// newConn returns a client connection to a server with the reflection
+// services of the registry of testdata/greeter.proto.
+func newConn(t *testing.T) *grpc.ClientConn {
+	t.Helper()
+	result, err := compiler.Build([]string{"greeter.proto"}, []string{"testdata", "../compiler/testdata"}, true)
+	require.NoError(t, err)
+	lis := bufconn.Listen(1 << 20)
+	s := grpc.NewServer()
+	Register(s, result.Registry())
+	go func() { _ = s.Serve(lis) }()
+	t.Cleanup(s.Stop)
+	conn, err := grpc.DialContext(context.Background(), "bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	return conn
+}
+
+// newV1Stream returns a function sending requests to the v1 reflection
+// service on one stream.
+func newV1Stream(t *testing.T, conn *grpc.ClientConn) func(*v1reflectionpb.ServerReflectionRequest) *v1reflectionpb.ServerReflectionResponse {
+	t.Helper()
+	stream, err := v1reflectionpb.NewServerReflectionClient(conn).ServerReflectionInfo(context.Background())
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = stream.CloseSend() })
+	return func(req *v1reflectionpb.ServerReflectionRequest) *v1reflectionpb.ServerReflectionResponse {
+		require.NoError(t, stream.Send(req))
+		resp, err := stream.Recv()
+		require.NoError(t, err)
+		return resp
+	}
+}
+
+// newV1AlphaStream returns a function sending v1 requests to the v1alpha
+// reflection service on one stream, converting requests and responses
+// through their wire format, which is the same for both versions.
+func newV1AlphaStream(t *testing.T, conn *grpc.ClientConn) func(*v1reflectionpb.ServerReflectionRequest) *v1reflectionpb.ServerReflectionResponse {
+	t.Helper()
+	stream, err := v1alphareflectionpb.NewServerReflectionClient(conn).ServerReflectionInfo(context.Background())
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = stream.CloseSend() })
+	return func(req *v1reflectionpb.ServerReflectionRequest) *v1reflectionpb.ServerReflectionResponse {
+		alphaReq := &v1alphareflectionpb.ServerReflectionRequest{}
+		convert(t, req, alphaReq)
+		require.NoError(t, stream.Send(alphaReq))
+		alphaResp, err := stream.Recv()
+		require.NoError(t, err)
+		resp := &v1reflectionpb.ServerReflectionResponse{}
+		convert(t, alphaResp, resp)
+		return resp
+	}
+}
+
+func convert(t *testing.T, from, to proto.Message) {
+	t.Helper()
+	b, err := proto.Marshal(from)
+	require.NoError(t, err)
+	require.NoError(t, proto.Unmarshal(b, to))
+}
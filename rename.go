@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+
+	"github.com/alecthomas/protobuf/edit"
+)
+
+type RenameConfig struct {
+	ProtoPath []string `short:"I" help:"Search paths for proto imports."`
+	From      string   `required:"" help:"Full name of the message or enum to rename."`
+	To        string   `required:"" help:"New full name of the message or enum, in the same scope."`
+	Tombstone bool     `help:"Leave a deprecated message with the old name that reserves all field numbers."`
+	DryRun    bool     `help:"Print the files that would change without writing them."`
+	Files     []string `arg:"" help:"Proto files to rename the type in, along with all their imports."`
+}
+
+func (c *RenameConfig) Run() error {
+	set, err := edit.Load(c.Files, c.ProtoPath)
+	if err != nil {
+		return err
+	}
+	var options []edit.RenameOption
+	if c.Tombstone {
+		options = append(options, edit.Tombstone())
+	}
+	if err := set.RenameType(protoreflect.FullName(c.From), protoreflect.FullName(c.To), options...); err != nil {
+		return err
+	}
+	for _, f := range set.Modified() {
+		fmt.Println(f.Path)
+		if c.DryRun {
+			continue
+		}
+		info, err := os.Stat(f.Path)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(f.Path, []byte(f.String()), info.Mode().Perm()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *RenameConfig) AfterApply() error {
+	if len(c.Files) == 0 {
+		return fmt.Errorf(`missing .proto input file(s)`)
+	}
+	return nil
+}